@@ -34,14 +34,33 @@ func (s *Server) configureRouter() {
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
+			http.MethodHead,
 			http.MethodOptions,
 		}),
 		handlers.AllowedHeaders([]string{
 			"Content-Type",
 			"Authorization",
 			"X-Requested-With",
+			"X-Share-Password",
+			"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+			"X-Amz-Server-Side-Encryption-Customer-Key",
+			"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
 			"Access-Control-Allow-Origin",
+			"Tus-Resumable",
+			"Upload-Length",
+			"Upload-Offset",
+			"Upload-Metadata",
+		}),
+		handlers.ExposedHeaders([]string{
+			"Location",
+			"Tus-Resumable",
+			"Tus-Version",
+			"Tus-Extension",
+			"Upload-Offset",
+			"Upload-Length",
+			"Upload-Expires",
 		}),
 	)
 
@@ -59,16 +78,55 @@ func (s *Server) configureRouter() {
 
 	// 5. Роуты на S3Server
 	s.router.HandleFunc("/files", s.redirectToS3()).Methods(http.MethodGet)
+	s.router.HandleFunc("/files/{filename:.+}", s.redirectToS3()).Methods(http.MethodGet, http.MethodHead)
+	s.router.HandleFunc("/tags/{filename:.+}", s.redirectToS3()).Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
 	s.router.HandleFunc("/download", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/download/{filename:.+}", s.redirectToS3()).Methods(http.MethodGet, http.MethodHead)
+	s.router.HandleFunc("/archive", s.redirectToS3()).Methods(http.MethodGet, http.MethodPost)
+	s.router.HandleFunc("/thumbnail", s.redirectToS3()).Methods(http.MethodGet)
 	s.router.HandleFunc("/upload", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/upload/{filename:.+}", s.redirectToS3()).Methods(http.MethodPut)
 	s.router.HandleFunc("/delete", s.redirectToS3()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/rename", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/move", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/copy", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/batch", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/trash", s.redirectToS3()).Methods(http.MethodGet, http.MethodDelete)
+	s.router.HandleFunc("/trash/{id}", s.redirectToS3()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/trash/{id}/restore", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/lifecycle", s.redirectToS3()).Methods(http.MethodGet, http.MethodPost)
+	s.router.HandleFunc("/lifecycle/dry-run", s.redirectToS3()).Methods(http.MethodGet)
+	s.router.HandleFunc("/lifecycle/{id}", s.redirectToS3()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/presign", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/presigned/download/{filename:.+}", s.redirectToS3()).Methods(http.MethodGet, http.MethodHead)
+	s.router.HandleFunc("/presigned/upload/{filename:.+}", s.redirectToS3()).Methods(http.MethodPut)
 	s.router.HandleFunc("/share", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/shares", s.redirectToS3()).Methods(http.MethodGet)
+	s.router.HandleFunc("/shares/{id}", s.redirectToS3()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/buckets", s.redirectToS3()).Methods(http.MethodGet, http.MethodPost)
+	s.router.HandleFunc("/buckets/{bucket}", s.redirectToS3()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/versioning", s.redirectToS3()).Methods(http.MethodGet, http.MethodPut)
+	s.router.HandleFunc("/versions", s.redirectToS3()).Methods(http.MethodGet, http.MethodDelete)
+	s.router.HandleFunc("/restore", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/uploads", s.redirectToS3()).Methods(http.MethodGet, http.MethodPost)
+	s.router.HandleFunc("/uploads/{upload_id}", s.redirectToS3()).Methods(http.MethodGet, http.MethodDelete)
+	s.router.HandleFunc("/uploads/{upload_id}/parts/{part_number}", s.redirectToS3()).Methods(http.MethodPut)
+	s.router.HandleFunc("/uploads/{upload_id}/complete", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/tus", s.redirectToS3()).Methods(http.MethodPost, http.MethodOptions)
+	s.router.HandleFunc("/tus/{id}", s.redirectToS3()).Methods(http.MethodHead, http.MethodPatch, http.MethodDelete)
+	s.router.HandleFunc("/chunks", s.redirectToS3()).Methods(http.MethodGet)
+	s.router.HandleFunc("/chunks/missing", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/chunks/assemble", s.redirectToS3()).Methods(http.MethodPost)
+	s.router.HandleFunc("/chunks/{hash}", s.redirectToS3()).Methods(http.MethodPut)
+	s.router.HandleFunc("/usage", s.redirectToS3()).Methods(http.MethodGet)
+	s.router.HandleFunc("/keys", s.redirectToS3()).Methods(http.MethodGet, http.MethodPost)
+	s.router.HandleFunc("/keys/{key}", s.redirectToS3()).Methods(http.MethodDelete)
 
 	// 6. Роуты на FileServer (ну пока что просто на S3Server) TODO: сделать отдельный сервер
 	s.router.HandleFunc("/login", s.redirectToFile()).Methods(http.MethodGet)
 	s.router.HandleFunc("/register", s.redirectToFile()).Methods(http.MethodGet)
 	s.router.HandleFunc("/share/{uuid}", s.redirectToFile()).Methods(http.MethodGet, http.MethodOptions)
-	s.router.HandleFunc("/file/{uuid}", s.redirectToFile()).Methods(http.MethodGet)
+	s.router.HandleFunc("/file/{uuid}", s.redirectToFile()).Methods(http.MethodGet, http.MethodHead)
 	s.router.HandleFunc("/", s.redirectToFile()).Methods(http.MethodGet)
 }
 
@@ -9,10 +9,12 @@ import (
 
 var (
 	configPath string
+	rewrap     bool
 )
 
 func init() {
 	flag.StringVar(&configPath, "config-path", "s3/configs/apiserver.toml", "config file path")
+	flag.BoolVar(&rewrap, "rewrap", false, "re-encrypt data keys with the active encryption key and exit")
 }
 
 func main() {
@@ -25,6 +27,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if rewrap {
+		n, err := apiserver.Rewrap(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("rewrapped %d blobs", n)
+		return
+	}
+
 	log.Println("S3 server started successfully")
 	if err := apiserver.Start(config); err != nil {
 		log.Fatal(err)
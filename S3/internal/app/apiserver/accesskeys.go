@@ -0,0 +1,55 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var errAccessKeyNotFound = errors.New("access key not found")
+
+// handleCreateAccessKey выдает пользователю ключи для S3-совместимого API.
+// Секрет возвращается только в этом ответе.
+func (s *Server) handleCreateAccessKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		key, err := s.filestore.CreateAccessKey(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, key)
+	}
+}
+
+func (s *Server) handleAccessKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		keys, err := s.filestore.ListAccessKeys(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		s.respond(w, r, http.StatusOK, keys)
+	}
+}
+
+func (s *Server) handleDeleteAccessKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteAccessKey(r.Context(), userID, mux.Vars(r)["key"]); err != nil {
+			if errors.Is(err, filestore.ErrAccessKeyNotFound) {
+				s.error(w, r, http.StatusNotFound, errAccessKeyNotFound)
+				return
+			}
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
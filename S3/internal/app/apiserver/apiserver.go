@@ -1,14 +1,37 @@
 package apiserver
 
 import (
+	"S3_project/S3/internal/app/imaging"
+	"S3_project/S3/internal/app/store/blobstore"
 	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
 	_ "github.com/lib/pq"
 )
 
+var (
+	// Иначе подпись ссылки можно было бы получить из подписи JWT, и наоборот.
+	errDefaultSecretKey   = errors.New("secret_key must be distinct from the JWT secret")
+	errChunksWithoutDedup = errors.New("chunk_size requires deduplicate = true")
+	// Содержимое по хешу общее для пользователей и шифруется общим ключом.
+	errPerUserDedup = errors.New("encryption_per_user requires deduplicate = false")
+)
+
+// presignInfo отделяет ключ presigned URL, выведенный из секрета сервиса, от других его применений.
+const presignInfo = "S3 Service presigned URL"
+
 func Start(config *Config) error {
+	presign, err := presignKey(config)
+	if err != nil {
+		return err
+	}
+
 	db, err := newDB(config.DatabaseURL)
 	if err != nil {
 		return err
@@ -17,10 +40,126 @@ func Start(config *Config) error {
 	defer func(db *sql.DB) {
 		_ = db.Close()
 	}(db)
-	fileStore := filestore.New(db, config.storePath)
+	blobs, err := newBlobs(config)
+	if err != nil {
+		return err
+	}
+
+	uploadTTL, err := time.ParseDuration(config.UploadTTL)
+	if err != nil {
+		return err
+	}
+
+	trashRetention, err := time.ParseDuration(config.TrashRetention)
+	if err != nil {
+		return err
+	}
+
+	lifecycleInterval, err := time.ParseDuration(config.LifecycleInterval)
+	if err != nil {
+		return err
+	}
+
+	if config.Compression != "" && !blobstore.ValidCompression(config.Compression) {
+		return blobstore.ErrUnknownCompression
+	}
+	if config.ChunkSize != 0 && !blobstore.ValidChunkSize(config.ChunkSize) {
+		return blobstore.ErrInvalidChunkSize
+	}
+	if config.ChunkSize != 0 && !config.Deduplicate {
+		return errChunksWithoutDedup
+	}
+	if config.EncryptionPerUser && len(config.EncryptionKeys) > 0 && config.Deduplicate {
+		return errPerUserDedup
+	}
+
+	fileStore := filestore.New(db, blobs)
+	fileStore.DefaultQuota = filestore.Quota{MaxBytes: config.QuotaBytes, MaxFiles: config.QuotaFiles}
+	fileStore.TrashRetention = trashRetention
+	fileStore.UploadTTL = uploadTTL
+	fileStore.Compression = filestore.CompressionPolicy{
+		Algorithm:    config.Compression,
+		MinSize:      config.CompressionMin,
+		ContentTypes: config.CompressionTypes,
+	}
+	fileStore.ChunkSize = config.ChunkSize
+	fileStore.Dedup = config.Deduplicate
 	srv := NewServer(fileStore, config.apiGatewayUrl)
+	srv.presignSecret = presign
+	if config.ThumbnailCache > 0 {
+		srv.thumbnails, err = imaging.OpenCache(config.ThumbnailDir, config.ThumbnailCache)
+		if err != nil {
+			return err
+		}
+		srv.thumbnailJobs = make(chan thumbnailJob, thumbnailQueueSize)
+		go srv.runThumbnailWorker()
+	}
+	go srv.runUploadJanitor(uploadTTL)
+	if trashRetention > 0 {
+		go srv.runTrashJanitor(trashRetention)
+	}
+	if lifecycleInterval > 0 {
+		go srv.runLifecycleScheduler(lifecycleInterval)
+	}
+
+	errs := make(chan error, 2)
+	if config.S3BindAddr != "" {
+		s3srv := newS3Server(srv, config.S3Region)
+		go func() {
+			errs <- http.ListenAndServe(config.S3BindAddr, s3srv)
+		}()
+	}
+	go func() {
+		errs <- http.ListenAndServe(config.BindAddr, srv)
+	}()
+
+	return <-errs
+}
+
+// Rewrap перешифровывает ключи данных блобов активным ключом шифрования из конфигурации
+// и возвращает число переписанных блобов. Сервер в это время должен быть остановлен.
+func Rewrap(config *Config) (int, error) {
+	blobs, err := newBlobs(config)
+	if err != nil {
+		return 0, err
+	}
+	encrypted, ok := blobs.(*blobstore.EncryptedBackend)
+	if !ok {
+		return 0, errors.New("encryption keys are not configured")
+	}
+	return encrypted.Rewrap(context.Background())
+}
 
-	return http.ListenAndServe(config.BindAddr, srv)
+// newBlobs создает хранилище содержимого, шифрующее блобы, если заданы ключи шифрования.
+// presignKey возвращает ключ подписи presigned URL: secret_key из конфигурации, а без него —
+// ключ, выведенный из секрета сервиса через HKDF, так что подпись JWT им не является.
+func presignKey(config *Config) ([]byte, error) {
+	if config.SecretKey == string(secretKey) {
+		return nil, errDefaultSecretKey
+	}
+	if config.SecretKey != "" {
+		return []byte(config.SecretKey), nil
+	}
+	return hkdf.Key(sha256.New, secretKey, nil, presignInfo, sha256.Size)
+}
+
+func newBlobs(config *Config) (blobstore.BlobBackend, error) {
+	blobs, err := blobstore.New(config.StorageBackend, config.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.EncryptionKeys) == 0 {
+		return blobs, nil
+	}
+
+	keys, err := blobstore.NewKeyring(config.EncryptionKeyID, config.EncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	if config.EncryptionPerUser {
+		keys.Scope = filestore.KeyOwner
+	}
+	return blobstore.NewEncryptedBackend(blobs, keys), nil
 }
 
 func newDB(databaseURL string) (*sql.DB, error) {
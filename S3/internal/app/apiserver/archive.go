@@ -0,0 +1,290 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+
+	// maxArchiveFiles — наибольшее число файлов, перечисленных в запросе архива по именам.
+	maxArchiveFiles = 1000
+	// archivePageSize — сколько файлов папки читается из базы за раз.
+	archivePageSize = 500
+)
+
+var (
+	errArchiveFormat       = errors.New("format must be zip or tar.gz")
+	errArchiveEmpty        = errors.New("filenames or prefix is required")
+	errArchiveTooManyFiles = errors.New("too many filenames")
+)
+
+// archiveRequest — что положить в архив: перечисленные файлы или все файлы папки prefix.
+type archiveRequest struct {
+	Bucket    string   `json:"bucket"`
+	Filenames []string `json:"filenames"`
+	Prefix    string   `json:"prefix"`
+	Format    string   `json:"format"`
+}
+
+// handleArchive отдает несколько файлов или папку одним архивом ZIP или tar.gz. Архив
+// собирается на лету по мере чтения файлов и не хранится ни в памяти, ни на диске.
+// POST принимает archiveRequest в теле, GET — те же поля параметрами (filename повторяется),
+// чтобы архив можно было скачать обычной ссылкой.
+func (s *Server) handleArchive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &archiveRequest{}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				s.error(w, r, http.StatusBadRequest, err)
+				return
+			}
+		} else {
+			query := r.URL.Query()
+			req.Bucket = query.Get("bucket")
+			req.Filenames = query["filename"]
+			req.Prefix = query.Get("prefix")
+			req.Format = query.Get("format")
+		}
+
+		if req.Format == "" {
+			req.Format = archiveZip
+		}
+		if req.Format != archiveZip && req.Format != archiveTarGz {
+			s.error(w, r, http.StatusBadRequest, errArchiveFormat)
+			return
+		}
+		if len(req.Filenames) > maxArchiveFiles {
+			s.error(w, r, http.StatusBadRequest, errArchiveTooManyFiles)
+			return
+		}
+
+		if len(req.Filenames) == 0 {
+			if req.Prefix == "" {
+				s.error(w, r, http.StatusBadRequest, errArchiveEmpty)
+				return
+			}
+			s.serveFolderArchive(w, r, userID, req.Bucket, req.Prefix, req.Format)
+			return
+		}
+
+		objects, err := s.archiveObjects(r.Context(), userID, req.Bucket, req.Filenames)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		next := func() ([]filestore.Object, error) {
+			batch := objects
+			objects = nil
+			return batch, nil
+		}
+		s.serveArchive(w, r, userID, req.Bucket, "", "archive", req.Format, next)
+	}
+}
+
+// archiveObjects проверяет перечисленные файлы до начала архива, пока можно ответить ошибкой.
+// Повторы имен пропускаются.
+func (s *Server) archiveObjects(ctx context.Context, userID int, bucket string, filenames []string) ([]filestore.Object, error) {
+	seen := make(map[string]bool, len(filenames))
+	objects := make([]filestore.Object, 0, len(filenames))
+	for _, filename := range filenames {
+		if seen[filename] {
+			continue
+		}
+		seen[filename] = true
+
+		o, err := s.filestore.Head(ctx, userID, bucket, filename)
+		if err != nil {
+			return nil, err
+		}
+		if o.KeyFingerprint != "" {
+			return nil, filestore.ErrCustomerKeyRequired
+		}
+		objects = append(objects, *o)
+	}
+	return objects, nil
+}
+
+// serveFolderArchive отдает архив всех файлов папки prefix. Имена в архиве — пути
+// относительно папки, сам архив называется по ней.
+func (s *Server) serveFolderArchive(w http.ResponseWriter, r *http.Request, userID int, bucket, prefix, format string) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	opts := filestore.ListOptions{Prefix: prefix, Limit: archivePageSize}
+	done := false
+	next := func() ([]filestore.Object, error) {
+		if done {
+			return nil, nil
+		}
+		list, err := s.filestore.ListFiles(r.Context(), userID, bucket, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.Cursor = list.NextCursor
+		done = list.NextCursor == ""
+		return list.Objects, nil
+	}
+	s.serveArchive(w, r, userID, bucket, prefix, path.Base(prefix), format, next)
+}
+
+// serveArchive пишет в ответ архив файлов, которые по порциям возвращает next (пустая
+// порция — конец). Ошибки до первого файла возвращаются обычным ответом; после начала
+// архива статус изменить уже нельзя, поэтому соединение обрывается, чтобы клиент
+// не принял недописанный архив за целый. Файлы, удаленные за время сборки архива
+// или зашифрованные ключом клиента, пропускаются.
+func (s *Server) serveArchive(w http.ResponseWriter, r *http.Request, userID int, bucket, prefix, name, format string, next func() ([]filestore.Object, error)) {
+	batch, err := next()
+	if err != nil {
+		s.storeError(w, r, err)
+		return
+	}
+	if len(batch) == 0 {
+		s.error(w, r, http.StatusNotFound, errFileNotFound)
+		return
+	}
+
+	contentType := "application/zip"
+	if format == archiveTarGz {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	archive := newArchiveWriter(w, format)
+	for len(batch) > 0 {
+		for _, o := range batch {
+			if err := s.addToArchive(r.Context(), archive, userID, bucket, o.Key, strings.TrimPrefix(o.Key, prefix)); err != nil {
+				s.logger.Error("archive", zap.String("filename", o.Key), zap.Error(err))
+				panic(http.ErrAbortHandler)
+			}
+		}
+		if batch, err = next(); err != nil {
+			s.logger.Error("archive", zap.Error(err))
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		s.logger.Error("archive", zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func (s *Server) addToArchive(ctx context.Context, archive archiveWriter, userID int, bucket, filename, name string) error {
+	f, o, err := s.filestore.Open(ctx, userID, bucket, filename, nil)
+	if errors.Is(err, filestore.ErrObjectNotFound) || errors.Is(err, filestore.ErrCustomerKeyRequired) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func(f io.Closer) {
+		_ = f.Close()
+	}(f)
+
+	if err := archive.Add(name, o, f); err != nil {
+		return err
+	}
+	if err := s.filestore.MarkAccessed(ctx, userID, bucket, filename); err != nil {
+		s.logger.Error("mark accessed", zap.Error(err))
+	}
+	return nil
+}
+
+// archiveWriter дописывает файлы в архив, который пишется сразу в ответ.
+type archiveWriter interface {
+	Add(name string, o *filestore.Object, r io.Reader) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format string) archiveWriter {
+	if format == archiveTarGz {
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipWriter) Add(name string, o *filestore.Object, r io.Reader) error {
+	method := zip.Deflate
+	if precompressed(o.ContentType) {
+		method = zip.Store
+	}
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: o.LastModified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (a *zipWriter) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzWriter) Add(name string, o *filestore.Object, r io.Reader) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     o.Size,
+		Mode:     0o644,
+		ModTime:  o.LastModified,
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.tw, r)
+	return err
+}
+
+func (a *tarGzWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// precompressed сообщает, что содержимое уже сжато и в ZIP его лучше хранить как есть.
+func precompressed(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/x-xz", "application/x-bzip2":
+		return true
+	}
+	return false
+}
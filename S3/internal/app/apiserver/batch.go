@@ -0,0 +1,179 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errBatchEmpty = errors.New("operations are required")
+
+// batchOperation — операция пакета. Поля, кроме op, bucket и filename, относятся
+// к отдельным операциям и повторяют поля одиночных запросов.
+type batchOperation struct {
+	Op       string `json:"op"`
+	Bucket   string `json:"bucket"`
+	Filename string `json:"filename"`
+	// delete
+	Permanent bool `json:"permanent"`
+	// move: по умолчанию бакет и имя совпадают с исходными, поэтому хотя бы одно нужно задать.
+	DestBucket   *string `json:"dest_bucket"`
+	DestFilename string  `json:"dest_filename"`
+	Overwrite    bool    `json:"overwrite"`
+	// share; folder — и для unshare всех ссылок на папку
+	ExpiresAt    time.Time `json:"expires_at"`
+	ExpiresIn    int64     `json:"expires_in"`
+	Password     string    `json:"password"`
+	MaxDownloads int       `json:"max_downloads"`
+	Folder       bool      `json:"folder"`
+	// unshare: без id отзываются все действующие ссылки на файл
+	ID string `json:"id"`
+	// tag
+	Tags map[string]string `json:"tags"`
+}
+
+type batchResult struct {
+	Op        string           `json:"op"`
+	Filename  string           `json:"filename"`
+	Status    string           `json:"status"`
+	TrashID   string           `json:"trash_id,omitempty"`
+	VersionID string           `json:"version_id,omitempty"`
+	Share     *filestore.Share `json:"share,omitempty"`
+	Revoked   int              `json:"revoked,omitempty"`
+	Code      int              `json:"code,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// handleBatch выполняет до filestore.MaxBatchOps операций delete, share, unshare, move
+// и tag одним запросом и возвращает итог каждой в том же порядке. Ошибка операции
+// не отменяет остальные: у нее в итоге статус error и HTTP-код, которым ответил бы
+// одиночный запрос.
+func (s *Server) handleBatch() http.HandlerFunc {
+	type request struct {
+		Operations []batchOperation `json:"operations"`
+	}
+	type response struct {
+		Results []batchResult `json:"results"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if len(req.Operations) == 0 {
+			s.error(w, r, http.StatusBadRequest, errBatchEmpty)
+			return
+		}
+		if len(req.Operations) > filestore.MaxBatchOps {
+			s.error(w, r, http.StatusBadRequest, filestore.ErrTooManyOps)
+			return
+		}
+
+		results := make([]batchResult, len(req.Operations))
+		ops := make([]filestore.BatchOp, 0, len(req.Operations))
+		index := make([]int, 0, len(req.Operations))
+		for i, o := range req.Operations {
+			results[i] = batchResult{Op: o.Op, Filename: o.Filename, Status: "ok"}
+			op, err := batchOp(o)
+			if err != nil {
+				results[i].fail(http.StatusBadRequest, err)
+				continue
+			}
+			ops = append(ops, op)
+			index = append(index, i)
+		}
+
+		done, err := s.filestore.Batch(r.Context(), userID, ops)
+		if done == nil && err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		if err != nil {
+			s.logger.Error("batch", zap.Error(err))
+		}
+
+		for j, res := range done {
+			result := &results[index[j]]
+			if res.Err != nil {
+				result.fail(batchStatus(res.Err))
+				continue
+			}
+			if res.Trash != nil {
+				result.TrashID = res.Trash.ID
+			}
+			result.VersionID = res.VersionID
+			result.Share = res.Share
+			result.Revoked = res.Revoked
+		}
+		s.respond(w, r, http.StatusOK, &response{Results: results})
+	}
+}
+
+// batchOp проверяет операцию так же, как одиночный запрос, и переводит ее для хранилища.
+func batchOp(o batchOperation) (filestore.BatchOp, error) {
+	op := filestore.BatchOp{Op: o.Op, Bucket: o.Bucket, Filename: o.Filename}
+	if o.Filename == "" {
+		return op, errEmptyFile
+	}
+
+	switch o.Op {
+	case filestore.BatchDelete:
+		op.Permanent = o.Permanent
+	case filestore.BatchMove:
+		op.DstBucket, op.DstFilename, op.Overwrite = o.Bucket, o.Filename, o.Overwrite
+		if o.DestBucket != nil {
+			op.DstBucket = *o.DestBucket
+		}
+		if o.DestFilename != "" {
+			op.DstFilename = o.DestFilename
+		}
+		if !validFilename(op.DstFilename) {
+			return op, errInvalidFilename
+		}
+	case filestore.BatchShare:
+		if o.ExpiresIn < 0 || o.MaxDownloads < 0 {
+			return op, errInvalidShareOptions
+		}
+		op.Share = filestore.ShareOptions{
+			ExpiresAt:    o.ExpiresAt,
+			Password:     o.Password,
+			MaxDownloads: o.MaxDownloads,
+			Folder:       o.Folder,
+		}
+		if o.ExpiresIn > 0 {
+			op.Share.ExpiresAt = time.Now().Add(time.Duration(o.ExpiresIn) * time.Second)
+		}
+	case filestore.BatchUnshare:
+		op.ShareID = o.ID
+		op.Share.Folder = o.Folder
+	case filestore.BatchTag:
+		op.Tags = o.Tags
+	default:
+		return op, filestore.ErrUnknownOp
+	}
+	return op, nil
+}
+
+// batchStatus переводит ошибку операции пакета в HTTP-код так же, как storeError и shareError.
+func batchStatus(err error) (int, error) {
+	switch {
+	case errors.Is(err, filestore.ErrShareNotFound):
+		return http.StatusNotFound, err
+	case errors.Is(err, filestore.ErrUnknownOp):
+		return http.StatusBadRequest, err
+	}
+	return storeStatus(err)
+}
+
+func (res *batchResult) fail(code int, err error) {
+	res.Status = "error"
+	res.Code = code
+	res.Error = err.Error()
+}
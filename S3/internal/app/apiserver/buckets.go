@@ -0,0 +1,69 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+var errInvalidBucketName = errors.New("invalid bucket name")
+
+func (s *Server) handleBuckets() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		buckets, err := s.filestore.ListBuckets(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		if buckets == nil {
+			buckets = []filestore.Bucket{}
+		}
+		s.respond(w, r, http.StatusOK, buckets)
+	}
+}
+
+// handleAddBucket создает бакет. Имена подчиняются тем же правилам, что и в S3 API,
+// чтобы бакет был доступен через оба интерфейса.
+func (s *Server) handleAddBucket() http.HandlerFunc {
+	type request struct {
+		Name string `json:"name"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if !bucketNameRegexp.MatchString(req.Name) {
+			s.error(w, r, http.StatusBadRequest, errInvalidBucketName)
+			return
+		}
+
+		if err := s.filestore.CreateBucket(r.Context(), userID, req.Name); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, map[string]string{"status": "ok"})
+	}
+}
+
+// handleRemoveBucket удаляет пустой бакет, для непустого возвращает 409.
+func (s *Server) handleRemoveBucket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteBucket(r.Context(), userID, mux.Vars(r)["bucket"]); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
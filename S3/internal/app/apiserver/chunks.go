@@ -0,0 +1,100 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleChunking возвращает параметры деления файлов на фрагменты, чтобы клиент
+// делил файлы так же, как сервер.
+func (s *Server) handleChunking() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chunking, err := s.filestore.Chunking()
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, chunking)
+	}
+}
+
+// handleMissingChunks отвечает, каких фрагментов из списка у пользователя еще нет.
+func (s *Server) handleMissingChunks() http.HandlerFunc {
+	type request struct {
+		Hashes []string `json:"hashes"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		missing, err := s.filestore.MissingChunks(r.Context(), userID, req.Hashes)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string][]string{"missing": missing})
+	}
+}
+
+// handlePutChunk принимает тело запроса как фрагмент с SHA-256 из пути.
+func (s *Server) handlePutChunk() http.HandlerFunc {
+	type response struct {
+		Hash string `json:"hash"`
+		Size int64  `json:"size"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		hash := mux.Vars(r)["hash"]
+
+		size, err := s.filestore.PutChunk(r.Context(), userID, hash, r.Body)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Hash: hash, Size: size})
+	}
+}
+
+// handleAssembleChunks записывает файл из фрагментов по списку их хешей так же,
+// как обычную загрузку: с версионированием, метаданными и ключом клиента из заголовков.
+func (s *Server) handleAssembleChunks() http.HandlerFunc {
+	type request struct {
+		Bucket      string            `json:"bucket"`
+		Filename    string            `json:"filename"`
+		ContentType string            `json:"content_type"`
+		Metadata    map[string]string `json:"metadata"`
+		Chunks      []string          `json:"chunks"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		body, err := s.filestore.AssembleChunks(r.Context(), userID, req.Chunks)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		defer func(body io.Closer) {
+			_ = body.Close()
+		}(body)
+
+		s.saveUpload(w, r, userID, req.Bucket, req.Filename, body, filestore.Metadata{
+			ContentType: req.ContentType,
+			User:        req.Metadata,
+		})
+	}
+}
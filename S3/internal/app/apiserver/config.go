@@ -1,21 +1,49 @@
 package apiserver
 
 type Config struct {
-	BindAddr      string `toml:"bind_addr"`
-	LogLevel      string `toml:"log_level"`
-	DatabaseURL   string `toml:"database_url"`
-	storePath     string `toml:"store_path"`
-	secretKey     string `toml:"secret_key"`
-	apiGatewayUrl string `toml:"api_gateway_url"`
+	BindAddr          string            `toml:"bind_addr"`
+	LogLevel          string            `toml:"log_level"`
+	DatabaseURL       string            `toml:"database_url"`
+	StorageBackend    string            `toml:"storage_backend"`
+	StorePath         string            `toml:"store_path"`
+	S3BindAddr        string            `toml:"s3_bind_addr"`
+	S3Region          string            `toml:"s3_region"`
+	UploadTTL         string            `toml:"upload_ttl"`
+	TrashRetention    string            `toml:"trash_retention"`
+	LifecycleInterval string            `toml:"lifecycle_interval"`
+	QuotaBytes        int64             `toml:"quota_bytes"`
+	QuotaFiles        int64             `toml:"quota_files"`
+	Compression       string            `toml:"compression"`
+	CompressionMin    int64             `toml:"compression_min_size"`
+	CompressionTypes  []string          `toml:"compression_types"`
+	ChunkSize         int64             `toml:"chunk_size"`
+	Deduplicate       bool              `toml:"deduplicate"`
+	ThumbnailDir      string            `toml:"thumbnail_cache_dir"`
+	ThumbnailCache    int64             `toml:"thumbnail_cache_size"`
+	EncryptionKeyID   string            `toml:"encryption_key_id"`
+	EncryptionPerUser bool              `toml:"encryption_per_user"`
+	EncryptionKeys    map[string]string `toml:"encryption_keys"`
+	SecretKey         string            `toml:"secret_key"`
+	apiGatewayUrl     string            `toml:"api_gateway_url"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		BindAddr:      ":8080",
-		LogLevel:      "info",
-		DatabaseURL:   "host=localhost user=postgres dbname=s3 password=postgres sslmode=disable",
-		storePath:     "storage",
-		secretKey:     "secret",
-		apiGatewayUrl: "http://127.0.1:7000",
+		BindAddr:          ":8080",
+		LogLevel:          "info",
+		DatabaseURL:       "host=localhost user=postgres dbname=s3 password=postgres sslmode=disable",
+		StorageBackend:    "disk",
+		StorePath:         "storage",
+		S3BindAddr:        ":9000",
+		S3Region:          "us-east-1",
+		UploadTTL:         "24h",
+		TrashRetention:    "720h",
+		LifecycleInterval: "1h",
+		CompressionMin:    1024,
+		CompressionTypes:  []string{"text/", "application/json", "application/xml", "application/javascript"},
+		Deduplicate:       true,
+		ThumbnailDir:      "thumbnails",
+		ThumbnailCache:    256 << 20,
+		apiGatewayUrl:     "http://127.0.1:7000",
 	}
 }
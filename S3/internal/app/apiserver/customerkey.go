@@ -0,0 +1,64 @@
+package apiserver
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+// Заголовки ключа клиента (SSE-C) те же, что в S3, и в /api, и в S3 API.
+const (
+	sseCustomerAlgorithm = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKey       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+	sseAlgorithmAES256   = "AES256"
+)
+
+var (
+	errInvalidCustomerKey    = errors.New("customer key must be 32 bytes in base64, algorithm AES256, key MD5 must match the key")
+	errCustomerKeyMultipart  = errors.New("customer keys are not supported for multipart uploads")
+	errS3InvalidCustomerKey  = &s3Error{"InvalidArgument", "The secret key was invalid for the specified algorithm", http.StatusBadRequest}
+	errS3CustomerKeyRequired = &s3Error{"InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object", http.StatusBadRequest}
+	errS3CustomerKeyMismatch = &s3Error{"AccessDenied", "The provided customer key does not match the key the object was encrypted with", http.StatusForbidden}
+)
+
+// customerKeyFromHeader возвращает ключ клиента из заголовков запроса или nil, если
+// их нет. Key-MD5 необязателен, но если задан, должен совпадать с ключом.
+func customerKeyFromHeader(h http.Header) ([]byte, error) {
+	algorithm, encoded, sum := h.Get(sseCustomerAlgorithm), h.Get(sseCustomerKey), h.Get(sseCustomerKeyMD5)
+	if algorithm == "" && encoded == "" && sum == "" {
+		return nil, nil
+	}
+	if algorithm != sseAlgorithmAES256 {
+		return nil, errInvalidCustomerKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errInvalidCustomerKey
+	}
+	if sum != "" && sum != customerKeyMD5(key) {
+		return nil, errInvalidCustomerKey
+	}
+	return key, nil
+}
+
+// hasCustomerKey сообщает, что запрос передает ключ клиента.
+func hasCustomerKey(h http.Header) bool {
+	return h.Get(sseCustomerAlgorithm) != "" || h.Get(sseCustomerKey) != "" || h.Get(sseCustomerKeyMD5) != ""
+}
+
+// setCustomerKeyHeaders подтверждает в ответе, что содержимое зашифровано ключом клиента, как S3.
+func setCustomerKeyHeaders(h http.Header, key []byte) {
+	if key == nil {
+		return
+	}
+	h.Set(sseCustomerAlgorithm, sseAlgorithmAES256)
+	h.Set(sseCustomerKeyMD5, customerKeyMD5(key))
+}
+
+func customerKeyMD5(key []byte) string {
+	sum := md5.Sum(key)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
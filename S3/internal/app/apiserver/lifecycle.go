@@ -0,0 +1,130 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// lifecycleDryRunLimit — сколько действий показывает пробный прогон.
+const lifecycleDryRunLimit = 1000
+
+// handleLifecycleRules возвращает правила жизненного цикла пользователя.
+func (s *Server) handleLifecycleRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		rules, err := s.filestore.ListRules(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		if rules == nil {
+			rules = []filestore.LifecycleRule{}
+		}
+		s.respond(w, r, http.StatusOK, rules)
+	}
+}
+
+// handleCreateLifecycleRule добавляет правило. Без bucket правило действует во всех бакетах,
+// "" — только в корневом пространстве.
+func (s *Server) handleCreateLifecycleRule() http.HandlerFunc {
+	type request struct {
+		Bucket *string `json:"bucket"`
+		Prefix string  `json:"prefix"`
+		Action string  `json:"action"`
+		Days   int     `json:"days"`
+		Since  string  `json:"since"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		rule, err := s.filestore.CreateRule(r.Context(), userID, filestore.LifecycleRule{
+			Bucket: req.Bucket,
+			Prefix: req.Prefix,
+			Action: req.Action,
+			Days:   req.Days,
+			Since:  req.Since,
+		})
+		if err != nil {
+			switch {
+			case errors.Is(err, filestore.ErrInvalidRuleAction),
+				errors.Is(err, filestore.ErrInvalidRuleSince),
+				errors.Is(err, filestore.ErrInvalidRuleDays),
+				errors.Is(err, filestore.ErrRuleNoCompression):
+				s.error(w, r, http.StatusBadRequest, err)
+			default:
+				s.storeError(w, r, err)
+			}
+			return
+		}
+		s.respond(w, r, http.StatusCreated, rule)
+	}
+}
+
+// handleDeleteLifecycleRule удаляет правило.
+func (s *Server) handleDeleteLifecycleRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteRule(r.Context(), userID, mux.Vars(r)["id"]); err != nil {
+			if errors.Is(err, filestore.ErrRuleNotFound) {
+				s.error(w, r, http.StatusNotFound, err)
+				return
+			}
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleLifecycleDryRun показывает, что правила пользователя сделали бы с файлами сейчас,
+// ничего не меняя.
+func (s *Server) handleLifecycleDryRun() http.HandlerFunc {
+	type response struct {
+		Actions   []filestore.LifecycleAction `json:"actions"`
+		Truncated bool                        `json:"truncated"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		actions, truncated, err := s.filestore.PlanLifecycle(r.Context(), userID, lifecycleDryRunLimit)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		if actions == nil {
+			actions = []filestore.LifecycleAction{}
+		}
+		s.respond(w, r, http.StatusOK, &response{Actions: actions, Truncated: truncated})
+	}
+}
+
+// runLifecycleScheduler периодически применяет правила жизненного цикла всех пользователей.
+func (s *Server) runLifecycleScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.filestore.RunLifecycle(context.Background())
+		if err != nil {
+			s.logger.Error("lifecycle scheduler", zap.Error(err))
+		}
+		if n > 0 {
+			s.logger.Info("lifecycle scheduler", zap.Int("processed files", n))
+		}
+	}
+}
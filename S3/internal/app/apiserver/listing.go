@@ -0,0 +1,96 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 1000
+)
+
+var (
+	errInvalidListLimit = errors.New("limit must be between 1 and 1000")
+	errInvalidListOrder = errors.New("order must be asc or desc")
+	errInvalidListDate  = errors.New("from and to must be RFC 3339 timestamps or dates (YYYY-MM-DD)")
+	errInvalidPublic    = errors.New("public must be true or false")
+)
+
+// listOptionsOf разбирает параметры листинга /files: prefix, delimiter, sort (name, date, size),
+// order (asc, desc), q (подстрока имени), ext (расширения через запятую), from и to
+// (дата загрузки, to не включается), public, tag (условия по тегам, можно несколько), limit и cursor.
+func listOptionsOf(query url.Values) (filestore.ListOptions, error) {
+	opts := filestore.ListOptions{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		Sort:      query.Get("sort"),
+		Name:      query.Get("q"),
+		Limit:     defaultListLimit,
+		Cursor:    query.Get("cursor"),
+	}
+
+	switch strings.ToLower(query.Get("order")) {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, errInvalidListOrder
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, errInvalidListLimit
+		}
+		opts.Limit = limit
+	}
+
+	for _, ext := range strings.Split(query.Get("ext"), ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			opts.Extensions = append(opts.Extensions, ext)
+		}
+	}
+
+	var err error
+	if opts.From, err = parseListDate(query.Get("from")); err != nil {
+		return opts, err
+	}
+	if opts.To, err = parseListDate(query.Get("to")); err != nil {
+		return opts, err
+	}
+
+	for _, expr := range query["tag"] {
+		t, err := parseTagFilter(expr)
+		if err != nil {
+			return opts, err
+		}
+		opts.Tags = append(opts.Tags, t)
+	}
+
+	if v := query.Get("public"); v != "" {
+		public, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, errInvalidPublic
+		}
+		opts.Public = &public
+	}
+	return opts, nil
+}
+
+func parseListDate(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errInvalidListDate
+}
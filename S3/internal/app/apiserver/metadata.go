@@ -0,0 +1,110 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// metaPrefix — префикс заголовков с пользовательскими метаданными в /api.
+	metaPrefix = "X-Meta-"
+	// s3MetaPrefix — то же для S3 API.
+	s3MetaPrefix = "X-Amz-Meta-"
+)
+
+// fileInfo — метаданные файла в ответах /api.
+type fileInfo struct {
+	Name         string            `json:"name"`
+	Date         int               `json:"date"`
+	Folder       bool              `json:"folder,omitempty"`
+	Public       bool              `json:"public,omitempty"`
+	Size         int64             `json:"size"`
+	ContentType  string            `json:"content_type,omitempty"`
+	SHA256       string            `json:"sha256,omitempty"`
+	LastModified *time.Time        `json:"last_modified,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+func newFileInfo(o *filestore.Object) fileInfo {
+	info := fileInfo{
+		Name:        o.Key,
+		Date:        int(o.UploadedAt.Unix() - 10800), // Преобразование времени в Unix timestamp с учетом часового пояса
+		Size:        o.Size,
+		ContentType: o.ContentType,
+		SHA256:      o.SHA256,
+		Metadata:    o.Metadata,
+	}
+	if !o.LastModified.IsZero() {
+		lastModified := o.LastModified.UTC()
+		info.LastModified = &lastModified
+	}
+	return info
+}
+
+// metadataFromHeader собирает метаданные загрузки из заголовков: Content-Type
+// и пользовательские пары из заголовков с префиксом prefix. Ключи хранятся в нижнем регистре.
+func metadataFromHeader(h http.Header, prefix string) filestore.Metadata {
+	meta := filestore.Metadata{ContentType: contentTypeOf(h)}
+	for name, values := range h {
+		if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+			continue
+		}
+		if meta.User == nil {
+			meta.User = make(map[string]string)
+		}
+		meta.User[strings.ToLower(name[len(prefix):])] = strings.Join(values, ",")
+	}
+	return meta
+}
+
+// contentTypeOf возвращает тип содержимого, указанный клиентом. application/octet-stream
+// браузеры и клиенты ставят по умолчанию, поэтому тогда тип определяется хранилищем.
+func contentTypeOf(h http.Header) string {
+	ctype := h.Get("Content-Type")
+	if ctype == "application/octet-stream" {
+		return ""
+	}
+	return ctype
+}
+
+// setMetadataHeaders отдает пользовательские метаданные заголовками с префиксом prefix.
+func setMetadataHeaders(h http.Header, prefix string, o *filestore.Object) {
+	for k, v := range o.Metadata {
+		h.Set(prefix+k, v)
+	}
+	if o.SHA256 != "" {
+		h.Set("X-Checksum-Sha256", o.SHA256)
+	}
+}
+
+// handleStat возвращает метаданные файла без содержимого: GET — в JSON,
+// HEAD — заголовками, как при скачивании. Бакет задается параметром bucket.
+func (s *Server) handleStat() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		o, err := s.filestore.Head(r.Context(), userID, r.URL.Query().Get("bucket"), mux.Vars(r)["filename"])
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+
+		if r.Method == http.MethodHead {
+			if o.ContentType != "" {
+				w.Header().Set("Content-Type", o.ContentType)
+			}
+			w.Header().Set("Content-Length", strconv.FormatInt(o.Size, 10))
+			w.Header().Set("Last-Modified", o.LastModified.UTC().Format(http.TimeFormat))
+			w.Header().Set("ETag", etag(o))
+			setMetadataHeaders(w.Header(), metaPrefix, o)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		s.respond(w, r, http.StatusOK, newFileInfo(o))
+	}
+}
@@ -0,0 +1,133 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errInvalidNewName = errors.New("new_name must be a file name without \"/\"")
+
+// handleRename переименовывает файл, оставляя его в той же папке. Ссылки на файл сохраняются.
+func (s *Server) handleRename() http.HandlerFunc {
+	type request struct {
+		Bucket    string `json:"bucket"`
+		Filename  string `json:"filename"`
+		NewName   string `json:"new_name"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if req.NewName == "" || strings.Contains(req.NewName, "/") {
+			s.error(w, r, http.StatusBadRequest, errInvalidNewName)
+			return
+		}
+
+		dst := req.Filename[:strings.LastIndex(req.Filename, "/")+1] + req.NewName
+		s.move(w, r, userID, req.Bucket, req.Filename, req.Bucket, dst, req.Overwrite)
+	}
+}
+
+// handleMove переносит файл в папку dest_folder ("" — корень) бакета dest_bucket,
+// по умолчанию того же. Имя файла сохраняется.
+func (s *Server) handleMove() http.HandlerFunc {
+	type request struct {
+		Bucket     string  `json:"bucket"`
+		Filename   string  `json:"filename"`
+		DestBucket *string `json:"dest_bucket"`
+		DestFolder string  `json:"dest_folder"`
+		Overwrite  bool    `json:"overwrite"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+
+		dstBucket := req.Bucket
+		if req.DestBucket != nil {
+			dstBucket = *req.DestBucket
+		}
+		dst := req.Filename[strings.LastIndex(req.Filename, "/")+1:]
+		if folder := strings.TrimSuffix(req.DestFolder, "/"); folder != "" {
+			dst = folder + "/" + dst
+		}
+		s.move(w, r, userID, req.Bucket, req.Filename, dstBucket, dst, req.Overwrite)
+	}
+}
+
+// handleCopy копирует файл на стороне сервера в dest_filename бакета dest_bucket.
+// По умолчанию имя и бакет совпадают с исходными, поэтому хотя бы одно нужно задать.
+func (s *Server) handleCopy() http.HandlerFunc {
+	type request struct {
+		Bucket       string  `json:"bucket"`
+		Filename     string  `json:"filename"`
+		DestBucket   *string `json:"dest_bucket"`
+		DestFilename string  `json:"dest_filename"`
+		Overwrite    bool    `json:"overwrite"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+
+		dstBucket, dst := req.Bucket, req.Filename
+		if req.DestBucket != nil {
+			dstBucket = *req.DestBucket
+		}
+		if req.DestFilename != "" {
+			dst = req.DestFilename
+		}
+		if !validFilename(dst) {
+			s.error(w, r, http.StatusBadRequest, errInvalidFilename)
+			return
+		}
+
+		o, err := s.filestore.Copy(r.Context(), userID, req.Bucket, req.Filename, dstBucket, dst, req.Overwrite)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, newFileInfo(o))
+	}
+}
+
+func (s *Server) move(w http.ResponseWriter, r *http.Request, userID int, bucket, filename, dstBucket, dst string, overwrite bool) {
+	if !validFilename(dst) {
+		s.error(w, r, http.StatusBadRequest, errInvalidFilename)
+		return
+	}
+
+	o, err := s.filestore.Move(r.Context(), userID, bucket, filename, dstBucket, dst, overwrite)
+	if err != nil {
+		s.storeError(w, r, err)
+		return
+	}
+	s.respond(w, r, http.StatusOK, newFileInfo(o))
+}
@@ -0,0 +1,211 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	presignDefaultExpiry = time.Hour
+	presignMaxExpiry     = 7 * 24 * time.Hour
+)
+
+var (
+	errInvalidPresignMethod = errors.New("method must be GET or PUT")
+	errInvalidPresignExpiry = errors.New("expires_in must be between 1 second and 7 days")
+	errInvalidPresignSize   = errors.New("max_size must not be negative")
+	errPresignedMalformed   = errors.New("presigned URL is malformed")
+	errPresignedExpired     = errors.New("presigned URL has expired")
+	errPresignedSignature   = errors.New("presigned URL signature does not match")
+	errPresignedTooLarge    = errors.New("request body is larger than the presigned URL allows")
+)
+
+// presignedParams — то, что подписано в presigned URL. Имя файла берется из пути,
+// остальное — из параметров запроса.
+type presignedParams struct {
+	Method    string
+	UserID    int
+	Bucket    string
+	Filename  string
+	VersionID string
+	Expires   int64
+	MaxSize   int64
+}
+
+// signature подписывает параметры секретом secret (HMAC-SHA256).
+func (p *presignedParams) signature(secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%d\n%s\n%s\n%s\n%d\n%d",
+		p.Method, p.UserID, p.Bucket, p.Filename, p.VersionID, p.Expires, p.MaxSize)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// path строит путь presigned URL относительно шлюза, подписанный секретом secret.
+func (p *presignedParams) path(secret []byte) string {
+	action := "download"
+	if p.Method == http.MethodPut {
+		action = "upload"
+	}
+
+	segments := strings.Split(p.Filename, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	query := url.Values{}
+	if p.Bucket != "" {
+		query.Set("bucket", p.Bucket)
+	}
+	if p.VersionID != "" {
+		query.Set("version_id", p.VersionID)
+	}
+	query.Set("user", strconv.Itoa(p.UserID))
+	query.Set("expires", strconv.FormatInt(p.Expires, 10))
+	if p.MaxSize > 0 {
+		query.Set("max_size", strconv.FormatInt(p.MaxSize, 10))
+	}
+	query.Set("signature", p.signature(secret))
+
+	return "/presigned/" + action + "/" + strings.Join(segments, "/") + "?" + query.Encode()
+}
+
+// handlePresign выдает владельцу файла ссылку для скачивания (GET) или загрузки (PUT)
+// без cookie. Ссылка действует expires_in секунд (по умолчанию час, не больше 7 дней);
+// max_size ограничивает размер загружаемого по ссылке файла.
+func (s *Server) handlePresign() http.HandlerFunc {
+	type request struct {
+		Method    string `json:"method"`
+		Bucket    string `json:"bucket"`
+		Filename  string `json:"filename"`
+		VersionID string `json:"version_id"`
+		ExpiresIn int64  `json:"expires_in"`
+		MaxSize   int64  `json:"max_size"`
+	}
+	type response struct {
+		URL       string    `json:"url"`
+		Method    string    `json:"method"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		method := strings.ToUpper(req.Method)
+		if method == "" {
+			method = http.MethodGet
+		}
+		if method != http.MethodGet && method != http.MethodPut {
+			s.error(w, r, http.StatusBadRequest, errInvalidPresignMethod)
+			return
+		}
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if !validFilename(req.Filename) {
+			s.error(w, r, http.StatusBadRequest, errInvalidFilename)
+			return
+		}
+
+		expiresIn := presignDefaultExpiry
+		if req.ExpiresIn != 0 {
+			expiresIn = time.Duration(req.ExpiresIn) * time.Second
+		}
+		if expiresIn <= 0 || expiresIn > presignMaxExpiry {
+			s.error(w, r, http.StatusBadRequest, errInvalidPresignExpiry)
+			return
+		}
+		if req.MaxSize < 0 {
+			s.error(w, r, http.StatusBadRequest, errInvalidPresignSize)
+			return
+		}
+
+		expiresAt := time.Now().Add(expiresIn).Truncate(time.Second)
+		p := &presignedParams{
+			Method:   method,
+			UserID:   userID,
+			Bucket:   req.Bucket,
+			Filename: req.Filename,
+			Expires:  expiresAt.Unix(),
+		}
+		// Версия имеет смысл только для скачивания, ограничение размера — только для загрузки.
+		if method == http.MethodGet {
+			p.VersionID = req.VersionID
+		} else {
+			p.MaxSize = req.MaxSize
+		}
+
+		s.respond(w, r, http.StatusOK, &response{URL: p.path(s.presignSecret), Method: method, ExpiresAt: expiresAt.UTC()})
+	}
+}
+
+// verifyPresigned пропускает запрос по presigned URL вместо cookie: проверяет подпись,
+// срок действия и ограничение размера, а затем выполняет запрос от имени владельца ссылки.
+func (s *Server) verifyPresigned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		p := &presignedParams{
+			Method:    method,
+			Bucket:    query.Get("bucket"),
+			Filename:  mux.Vars(r)["filename"],
+			VersionID: query.Get("version_id"),
+		}
+
+		var err error
+		if p.UserID, err = strconv.Atoi(query.Get("user")); err != nil {
+			s.error(w, r, http.StatusForbidden, errPresignedMalformed)
+			return
+		}
+		if p.Expires, err = strconv.ParseInt(query.Get("expires"), 10, 64); err != nil {
+			s.error(w, r, http.StatusForbidden, errPresignedMalformed)
+			return
+		}
+		if v := query.Get("max_size"); v != "" {
+			if p.MaxSize, err = strconv.ParseInt(v, 10, 64); err != nil {
+				s.error(w, r, http.StatusForbidden, errPresignedMalformed)
+				return
+			}
+		}
+
+		if !hmac.Equal([]byte(p.signature(s.presignSecret)), []byte(query.Get("signature"))) {
+			s.error(w, r, http.StatusForbidden, errPresignedSignature)
+			return
+		}
+		if time.Now().Unix() > p.Expires {
+			s.error(w, r, http.StatusForbidden, errPresignedExpired)
+			return
+		}
+
+		if p.MaxSize > 0 {
+			if r.ContentLength > p.MaxSize {
+				s.error(w, r, http.StatusRequestEntityTooLarge, errPresignedTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, p.MaxSize)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyUserId, p.UserID)))
+	})
+}
@@ -0,0 +1,30 @@
+package apiserver
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPresignKey(t *testing.T) {
+	derived, err := presignKey(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Без secret_key ключ выводится из секрета сервиса, но с ним не совпадает.
+	if len(derived) != 32 || bytes.Equal(derived, secretKey) {
+		t.Errorf("derived key %x, want 32 bytes distinct from the JWT secret", derived)
+	}
+	if again, _ := presignKey(&Config{}); !bytes.Equal(again, derived) {
+		t.Error("derived key changes between starts, issued URLs would break")
+	}
+
+	own, err := presignKey(&Config{SecretKey: "own secret"})
+	if err != nil || string(own) != "own secret" {
+		t.Errorf("presignKey() = %q, %v, want the configured secret_key", own, err)
+	}
+
+	if _, err := presignKey(&Config{SecretKey: string(secretKey)}); !errors.Is(err, errDefaultSecretKey) {
+		t.Errorf("JWT secret as secret_key: error = %v, want errDefaultSecretKey", err)
+	}
+}
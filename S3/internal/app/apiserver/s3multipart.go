@@ -0,0 +1,279 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *s3Server) handleCreateMultipartUpload() http.HandlerFunc {
+	type response struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if err := validObjectKey(vars["key"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if hasCustomerKey(r.Header) {
+			s.s3Error(w, r, errS3NotImplemented)
+			return
+		}
+
+		upload, err := s.filestore.CreateUpload(r.Context(), userID, vars["bucket"], vars["key"], metadataFromHeader(r.Header, s3MetaPrefix))
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		s.respondXML(w, r, http.StatusOK, &response{
+			Xmlns:    s3XMLNamespace,
+			Bucket:   upload.Bucket,
+			Key:      upload.Filename,
+			UploadID: upload.UploadID,
+		})
+	}
+}
+
+func (s *s3Server) handlePutObjectPart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		partNumber, err := strconv.Atoi(vars["partNumber"])
+		if err != nil || partNumber < 1 || partNumber > filestore.MaxPartNumber {
+			s.s3Error(w, r, errS3InvalidArgument)
+			return
+		}
+		if _, err := s.findUpload(r, vars["uploadId"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		part, err := s.filestore.UploadPart(r.Context(), userID, vars["uploadId"], partNumber, r.Body)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		w.Header().Set("ETag", "\""+part.ETag+"\"")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *s3Server) handleCompleteMultipartUpload() http.HandlerFunc {
+	type request struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}
+	type response struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:"Location"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		ETag     string   `xml:"ETag"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		req := &request{}
+		if err := xml.NewDecoder(r.Body).Decode(req); err != nil || len(req.Parts) == 0 {
+			s.s3Error(w, r, errS3MalformedXML)
+			return
+		}
+		if _, err := s.findUpload(r, vars["uploadId"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		parts := make([]filestore.Part, len(req.Parts))
+		for i, p := range req.Parts {
+			parts[i] = filestore.Part{PartNumber: p.PartNumber, ETag: p.ETag}
+		}
+
+		upload, body, err := s.filestore.AssembleUpload(r.Context(), userID, vars["uploadId"], parts)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		defer func(body io.Closer) {
+			_ = body.Close()
+		}(body)
+
+		o, err := s.filestore.PutObject(r.Context(), userID, vars["bucket"], vars["key"], body, upload.Metadata)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		if err := s.filestore.DeleteUpload(r.Context(), userID, vars["uploadId"]); err != nil {
+			s.logger.Error("error", zap.Error(err))
+		}
+		s.enqueueThumbnail(userID, vars["bucket"], vars["key"])
+
+		s.respondXML(w, r, http.StatusOK, &response{
+			Xmlns:    s3XMLNamespace,
+			Location: "/" + vars["bucket"] + "/" + vars["key"],
+			Bucket:   vars["bucket"],
+			Key:      vars["key"],
+			ETag:     etag(o),
+		})
+	}
+}
+
+func (s *s3Server) handleAbortMultipartUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		uploadID := mux.Vars(r)["uploadId"]
+
+		if _, err := s.findUpload(r, uploadID); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if err := s.filestore.DeleteUpload(r.Context(), userID, uploadID); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleListParts отдает все части одной страницей: их не больше MaxPartNumber.
+func (s *s3Server) handleListParts() http.HandlerFunc {
+	type part struct {
+		PartNumber   int    `xml:"PartNumber"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+	}
+	type response struct {
+		XMLName      xml.Name `xml:"ListPartsResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		Bucket       string   `xml:"Bucket"`
+		Key          string   `xml:"Key"`
+		UploadID     string   `xml:"UploadId"`
+		Initiator    s3Owner  `xml:"Initiator"`
+		Owner        s3Owner  `xml:"Owner"`
+		StorageClass string   `xml:"StorageClass"`
+		MaxParts     int      `xml:"MaxParts"`
+		IsTruncated  bool     `xml:"IsTruncated"`
+		Parts        []part   `xml:"Part"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if _, err := s.findUpload(r, vars["uploadId"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		parts, err := s.filestore.ListParts(r.Context(), userID, vars["uploadId"])
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Xmlns:        s3XMLNamespace,
+			Bucket:       vars["bucket"],
+			Key:          vars["key"],
+			UploadID:     vars["uploadId"],
+			Initiator:    s3OwnerOf(userID),
+			Owner:        s3OwnerOf(userID),
+			StorageClass: "STANDARD",
+			MaxParts:     filestore.MaxPartNumber,
+			Parts:        make([]part, len(parts)),
+		}
+		for i, p := range parts {
+			resp.Parts[i] = part{
+				PartNumber:   p.PartNumber,
+				LastModified: p.LastModified.UTC().Format(s3TimeFormat),
+				ETag:         "\"" + p.ETag + "\"",
+				Size:         p.Size,
+			}
+		}
+		s.respondXML(w, r, http.StatusOK, resp)
+	}
+}
+
+// handleListMultipartUploads отдает все незавершенные загрузки бакета одной страницей.
+func (s *s3Server) handleListMultipartUploads() http.HandlerFunc {
+	type upload struct {
+		Key          string  `xml:"Key"`
+		UploadID     string  `xml:"UploadId"`
+		Initiator    s3Owner `xml:"Initiator"`
+		Owner        s3Owner `xml:"Owner"`
+		StorageClass string  `xml:"StorageClass"`
+		Initiated    string  `xml:"Initiated"`
+	}
+	type response struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Xmlns       string   `xml:"xmlns,attr"`
+		Bucket      string   `xml:"Bucket"`
+		MaxUploads  int      `xml:"MaxUploads"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		Uploads     []upload `xml:"Upload"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		bucket := mux.Vars(r)["bucket"]
+
+		uploads, err := s.filestore.ListUploads(r.Context(), userID, bucket)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Xmlns:      s3XMLNamespace,
+			Bucket:     bucket,
+			MaxUploads: s3DefaultMaxKeys,
+			Uploads:    make([]upload, len(uploads)),
+		}
+		for i, u := range uploads {
+			resp.Uploads[i] = upload{
+				Key:          u.Filename,
+				UploadID:     u.UploadID,
+				Initiator:    s3OwnerOf(userID),
+				Owner:        s3OwnerOf(userID),
+				StorageClass: "STANDARD",
+				Initiated:    u.CreatedAt.UTC().Format(s3TimeFormat),
+			}
+		}
+		s.respondXML(w, r, http.StatusOK, resp)
+	}
+}
+
+// findUpload находит загрузку и проверяет, что она начата для бакета и ключа из пути.
+func (s *s3Server) findUpload(r *http.Request, uploadID string) (*filestore.Upload, error) {
+	userID := r.Context().Value(ctxKeyUserId).(int)
+	vars := mux.Vars(r)
+
+	upload, err := s.filestore.FindUpload(r.Context(), userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Bucket != vars["bucket"] || upload.Filename != vars["key"] {
+		return nil, errS3NoSuchUpload
+	}
+	return upload, nil
+}
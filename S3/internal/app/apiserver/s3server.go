@@ -0,0 +1,645 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	s3XMLNamespace    = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat      = "2006-01-02T15:04:05.000Z"
+	s3DefaultMaxKeys  = 1000
+	s3DefaultRegion   = "us-east-1"
+	s3MaxObjectKeyLen = 1024
+)
+
+var bucketNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3Error — ошибка в формате S3 REST API.
+type s3Error struct {
+	Code    string
+	Message string
+	Status  int
+}
+
+func (e *s3Error) Error() string {
+	return e.Message
+}
+
+var (
+	errS3AccessDenied            = &s3Error{"AccessDenied", "Access Denied", http.StatusForbidden}
+	errS3AuthorizationMalformed  = &s3Error{"AuthorizationHeaderMalformed", "The authorization header is malformed", http.StatusBadRequest}
+	errS3InvalidAccessKeyID      = &s3Error{"InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records", http.StatusForbidden}
+	errS3SignatureDoesNotMatch   = &s3Error{"SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided", http.StatusForbidden}
+	errS3RequestTimeTooSkewed    = &s3Error{"RequestTimeTooSkewed", "The difference between the request time and the server's time is too large", http.StatusForbidden}
+	errS3PresignedExpired        = &s3Error{"AccessDenied", "Request has expired", http.StatusForbidden}
+	errS3MissingContentSHA256    = &s3Error{"InvalidRequest", "Missing required header for this request: x-amz-content-sha256", http.StatusBadRequest}
+	errS3InvalidContentSHA256    = &s3Error{"InvalidArgument", "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-AWS4-HMAC-SHA256-PAYLOAD, STREAMING-UNSIGNED-PAYLOAD-TRAILER or a valid sha256 value", http.StatusBadRequest}
+	errS3ContentSHA256Mismatch   = &s3Error{"XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed", http.StatusBadRequest}
+	errS3IncompleteBody          = &s3Error{"IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header", http.StatusBadRequest}
+	errS3InvalidBucketName       = &s3Error{"InvalidBucketName", "The specified bucket is not valid", http.StatusBadRequest}
+	errS3BucketAlreadyOwnedByYou = &s3Error{"BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it", http.StatusConflict}
+	errS3BucketNotEmpty          = &s3Error{"BucketNotEmpty", "The bucket you tried to delete is not empty", http.StatusConflict}
+	errS3NoSuchBucket            = &s3Error{"NoSuchBucket", "The specified bucket does not exist", http.StatusNotFound}
+	errS3NoSuchKey               = &s3Error{"NoSuchKey", "The specified key does not exist", http.StatusNotFound}
+	errS3NoSuchUpload            = &s3Error{"NoSuchUpload", "The specified multipart upload does not exist", http.StatusNotFound}
+	errS3InvalidPart             = &s3Error{"InvalidPart", "One or more of the specified parts could not be found", http.StatusBadRequest}
+	errS3InvalidPartOrder        = &s3Error{"InvalidPartOrder", "The list of parts was not in ascending order", http.StatusBadRequest}
+	errS3MalformedXML            = &s3Error{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema", http.StatusBadRequest}
+	errS3KeyTooLong              = &s3Error{"KeyTooLongError", "Your key is too long", http.StatusBadRequest}
+	errS3MetadataTooLarge        = &s3Error{"MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size", http.StatusBadRequest}
+	errS3QuotaExceeded           = &s3Error{"QuotaExceeded", "Your upload exceeds the storage quota", http.StatusRequestEntityTooLarge}
+	errS3FileQuotaExceeded       = &s3Error{"QuotaExceeded", "You have reached the maximum number of objects", http.StatusInsufficientStorage}
+	errS3InvalidTag              = &s3Error{"InvalidTag", "The tag key must be 1-128 characters long and the tag value at most 256", http.StatusBadRequest}
+	errS3DuplicateTagKey         = &s3Error{"InvalidTag", "Cannot provide multiple Tags with the same key", http.StatusBadRequest}
+	errS3TooManyTags             = &s3Error{"BadRequest", "Object tags cannot be greater than 10", http.StatusBadRequest}
+	errS3InvalidArgument         = &s3Error{"InvalidArgument", "Invalid Argument", http.StatusBadRequest}
+	errS3NotImplemented          = &s3Error{"NotImplemented", "A header or query you provided implies functionality that is not implemented", http.StatusNotImplemented}
+	errS3InternalError           = &s3Error{"InternalError", "We encountered an internal error. Please try again", http.StatusInternalServerError}
+)
+
+// s3Server — S3-совместимый REST API поверх того же FileStore, что и /api.
+// Слушает отдельный адрес, чтобы пути вида /{bucket}/{key} не пересекались
+// со страницами и JSON API основного сервера.
+type s3Server struct {
+	*Server
+	router *mux.Router
+	region string
+}
+
+func newS3Server(s *Server, region string) *s3Server {
+	if region == "" {
+		region = s3DefaultRegion
+	}
+
+	s3 := &s3Server{
+		Server: s,
+		router: mux.NewRouter().SkipClean(true),
+		region: region,
+	}
+
+	s3.configureRouter()
+	return s3
+}
+
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+func (s *s3Server) configureRouter() {
+	s.router.Use(s.setRequestID)
+	s.router.Use(s.logRequest)
+	s.router.Use(s.authenticateSigV4)
+
+	s.router.HandleFunc("/", s.handleListBuckets()).Methods(http.MethodGet)
+
+	s.router.HandleFunc("/{bucket}", s.handleCreateBucket()).Methods(http.MethodPut)
+	s.router.HandleFunc("/{bucket}", s.handleHeadBucket()).Methods(http.MethodHead)
+	s.router.HandleFunc("/{bucket}", s.handleDeleteBucket()).Methods(http.MethodDelete)
+	s.router.HandleFunc("/{bucket}", s.handleGetBucketLocation()).Methods(http.MethodGet).Queries("location", "")
+	s.router.HandleFunc("/{bucket}", s.handleListMultipartUploads()).Methods(http.MethodGet).Queries("uploads", "")
+	s.router.HandleFunc("/{bucket}", s.handleListObjectsV2()).Methods(http.MethodGet)
+	s.router.HandleFunc("/{bucket}/", s.handleListObjectsV2()).Methods(http.MethodGet)
+
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleCreateMultipartUpload()).Methods(http.MethodPost).Queries("uploads", "")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleCompleteMultipartUpload()).Methods(http.MethodPost).Queries("uploadId", "{uploadId}")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handlePutObjectPart()).Methods(http.MethodPut).Queries("partNumber", "{partNumber}", "uploadId", "{uploadId}")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleListParts()).Methods(http.MethodGet).Queries("uploadId", "{uploadId}")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleAbortMultipartUpload()).Methods(http.MethodDelete).Queries("uploadId", "{uploadId}")
+
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleGetObjectTagging()).Methods(http.MethodGet).Queries("tagging", "")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handlePutObjectTagging()).Methods(http.MethodPut).Queries("tagging", "")
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleDeleteObjectTagging()).Methods(http.MethodDelete).Queries("tagging", "")
+
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handlePutObject()).Methods(http.MethodPut)
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleGetObject()).Methods(http.MethodGet, http.MethodHead)
+	s.router.HandleFunc("/{bucket}/{key:.+}", s.handleDeleteObject()).Methods(http.MethodDelete)
+
+	s.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.s3Error(w, r, errS3NotImplemented)
+	})
+	s.router.MethodNotAllowedHandler = s.router.NotFoundHandler
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func (s *s3Server) handleListBuckets() http.HandlerFunc {
+	type bucket struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	}
+	type response struct {
+		XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+		Xmlns   string   `xml:"xmlns,attr"`
+		Owner   s3Owner  `xml:"Owner"`
+		Buckets []bucket `xml:"Buckets>Bucket"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		buckets, err := s.filestore.ListBuckets(r.Context(), userID)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Xmlns:   s3XMLNamespace,
+			Owner:   s3OwnerOf(userID),
+			Buckets: make([]bucket, len(buckets)),
+		}
+		for i, b := range buckets {
+			resp.Buckets[i] = bucket{Name: b.Name, CreationDate: b.CreatedAt.UTC().Format(s3TimeFormat)}
+		}
+		s.respondXML(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *s3Server) handleCreateBucket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		bucket := mux.Vars(r)["bucket"]
+
+		if !bucketNameRegexp.MatchString(bucket) {
+			s.s3Error(w, r, errS3InvalidBucketName)
+			return
+		}
+
+		// Тело может содержать CreateBucketConfiguration с регионом — он у нас один.
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		if err := s.filestore.CreateBucket(r.Context(), userID, bucket); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		w.Header().Set("Location", "/"+bucket)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *s3Server) handleHeadBucket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		exists, err := s.filestore.BucketExists(r.Context(), userID, mux.Vars(r)["bucket"])
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("X-Amz-Bucket-Region", s.region)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *s3Server) handleDeleteBucket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteBucket(r.Context(), userID, mux.Vars(r)["bucket"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *s3Server) handleGetBucketLocation() http.HandlerFunc {
+	type response struct {
+		XMLName  xml.Name `xml:"LocationConstraint"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string   `xml:",chardata"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		exists, err := s.filestore.BucketExists(r.Context(), userID, mux.Vars(r)["bucket"])
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if !exists {
+			s.s3Error(w, r, errS3NoSuchBucket)
+			return
+		}
+
+		// Для us-east-1 S3 возвращает пустой LocationConstraint.
+		location := s.region
+		if location == s3DefaultRegion {
+			location = ""
+		}
+		s.respondXML(w, r, http.StatusOK, &response{Xmlns: s3XMLNamespace, Location: location})
+	}
+}
+
+func (s *s3Server) handleListObjectsV2() http.HandlerFunc {
+	type object struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	type response struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Xmlns                 string         `xml:"xmlns,attr"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		EncodingType          string         `xml:"EncodingType,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		KeyCount              int            `xml:"KeyCount"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		q := r.URL.Query()
+
+		maxKeys := s3DefaultMaxKeys
+		if v := q.Get("max-keys"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				s.s3Error(w, r, errS3InvalidArgument)
+				return
+			}
+			maxKeys = min(n, s3DefaultMaxKeys)
+		}
+		// encoding-type=url просит кодировать ключи в ответе: в XML 1.0 не всякий символ
+		// ключа можно передать.
+		encodingType := q.Get("encoding-type")
+		if encodingType != "" && encodingType != "url" {
+			s.s3Error(w, r, errS3InvalidArgument)
+			return
+		}
+		encode := func(s string) string {
+			if encodingType == "" {
+				return s
+			}
+			return s3URLEncode(s)
+		}
+
+		resp := &response{
+			Xmlns:             s3XMLNamespace,
+			Name:              mux.Vars(r)["bucket"],
+			Prefix:            q.Get("prefix"),
+			Delimiter:         q.Get("delimiter"),
+			StartAfter:        q.Get("start-after"),
+			ContinuationToken: q.Get("continuation-token"),
+			MaxKeys:           maxKeys,
+		}
+
+		after := resp.StartAfter
+		if resp.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(resp.ContinuationToken)
+			if err != nil {
+				s.s3Error(w, r, errS3InvalidArgument)
+				return
+			}
+			after = string(token)
+		}
+
+		// limit 0 в ListObjects означает "без ограничения", поэтому при max-keys=0
+		// бакет только проверяется, а страница отдается пустой.
+		list, err := s.filestore.ListObjects(r.Context(), userID, resp.Name, resp.Prefix, resp.Delimiter, after, max(maxKeys, 1))
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if maxKeys == 0 {
+			list = &filestore.ObjectList{}
+		}
+		if list.IsTruncated {
+			resp.IsTruncated = true
+			resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(list.NextStartAfter))
+		}
+
+		resp.KeyCount = len(list.Objects) + len(list.CommonPrefixes)
+		resp.EncodingType = encodingType
+		resp.Prefix, resp.Delimiter, resp.StartAfter = encode(resp.Prefix), encode(resp.Delimiter), encode(resp.StartAfter)
+		for _, prefix := range list.CommonPrefixes {
+			resp.CommonPrefixes = append(resp.CommonPrefixes, commonPrefix{Prefix: encode(prefix)})
+		}
+		resp.Contents = make([]object, len(list.Objects))
+		for i, o := range list.Objects {
+			resp.Contents[i] = object{
+				Key:          encode(o.Key),
+				LastModified: o.LastModified.UTC().Format(s3TimeFormat),
+				ETag:         etag(&o),
+				Size:         o.Size,
+				StorageClass: "STANDARD",
+			}
+		}
+		s.respondXML(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *s3Server) handlePutObject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if err := validObjectKey(vars["key"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if r.Header.Get("X-Amz-Copy-Source") != "" || hasSubresource(r) {
+			s.s3Error(w, r, errS3NotImplemented)
+			return
+		}
+
+		tags, tagged, err := tagsFromHeader(r.Header)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		key, err := customerKeyFromHeader(r.Header)
+		if err != nil {
+			s.s3Error(w, r, errS3InvalidCustomerKey)
+			return
+		}
+
+		meta := metadataFromHeader(r.Header, s3MetaPrefix)
+		meta.CustomerKey = key
+		o, err := s.filestore.PutObject(r.Context(), userID, vars["bucket"], vars["key"], r.Body, meta)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if tagged {
+			if err := s.filestore.SetTags(r.Context(), userID, vars["bucket"], vars["key"], tags); err != nil {
+				s.s3Error(w, r, err)
+				return
+			}
+		}
+
+		s.enqueueThumbnail(userID, vars["bucket"], vars["key"])
+
+		w.Header().Set("ETag", etag(o))
+		setCustomerKeyHeaders(w.Header(), key)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *s3Server) handleGetObject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if hasSubresource(r) {
+			s.s3Error(w, r, errS3NotImplemented)
+			return
+		}
+
+		key, err := customerKeyFromHeader(r.Header)
+		if err != nil {
+			s.s3Error(w, r, errS3InvalidCustomerKey)
+			return
+		}
+
+		f, o, err := s.filestore.Open(r.Context(), userID, vars["bucket"], vars["key"], key)
+		if err != nil {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(s3ErrorOf(err).Status)
+				return
+			}
+			s.s3Error(w, r, err)
+			return
+		}
+		defer func(f io.Closer) {
+			_ = f.Close()
+		}(f)
+
+		if r.Method == http.MethodGet {
+			if err := s.filestore.MarkAccessed(r.Context(), userID, vars["bucket"], vars["key"]); err != nil {
+				s.logger.Error("mark accessed", zap.Error(err))
+			}
+		}
+
+		if o.ContentType != "" {
+			w.Header().Set("Content-Type", o.ContentType)
+		} else {
+			w.Header().Set("Content-Type", "binary/octet-stream")
+		}
+		w.Header().Set("ETag", etag(o))
+		setMetadataHeaders(w.Header(), s3MetaPrefix, o)
+		setCustomerKeyHeaders(w.Header(), key)
+
+		// Переопределение заголовков ответа, как в GetObject у S3 (обычно из presigned URL).
+		q := r.URL.Query()
+		if v := q.Get("response-content-type"); v != "" {
+			w.Header().Set("Content-Type", v)
+		}
+		if v := q.Get("response-content-disposition"); v != "" {
+			w.Header().Set("Content-Disposition", v)
+		}
+		if v := q.Get("response-cache-control"); v != "" {
+			w.Header().Set("Cache-Control", v)
+		}
+
+		http.ServeContent(w, r, "", o.LastModified, f)
+	}
+}
+
+func (s *s3Server) handleDeleteObject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if hasSubresource(r) {
+			s.s3Error(w, r, errS3NotImplemented)
+			return
+		}
+
+		if err := s.filestore.DeleteObject(r.Context(), userID, vars["bucket"], vars["key"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// authenticateSigV4 проверяет подпись AWS Signature V4 (заголовок Authorization
+// или presigned URL) по ключам из access_keys и кладет владельца ключа в контекст.
+func (s *s3Server) authenticateSigV4(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sig, err := parseSigV4(r)
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		key, err := s.filestore.FindAccessKey(r.Context(), sig.accessKey)
+		if err != nil {
+			if errors.Is(err, filestore.ErrAccessKeyNotFound) {
+				s.s3Error(w, r, errS3InvalidAccessKeyID)
+				return
+			}
+			s.s3Error(w, r, err)
+			return
+		}
+
+		signingKey, err := sig.verify(r, key.SecretKey, s.region, time.Now().UTC())
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		if err := sig.wrapBody(r, signingKey); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyUserId, key.UserID)))
+	})
+}
+
+func (s *s3Server) s3Error(w http.ResponseWriter, r *http.Request, err error) {
+	e := s3ErrorOf(err)
+	if e.Status >= http.StatusInternalServerError {
+		s.logger.Error("error", zap.Error(err))
+	}
+
+	requestID, _ := r.Context().Value(ctxKeyRequestID).(string)
+	s.respondXML(w, r, e.Status, &s3ErrorResponse{
+		Code:      e.Code,
+		Message:   e.Message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	})
+}
+
+func (s *s3Server) respondXML(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	if data != nil && r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, xml.Header)
+		_ = xml.NewEncoder(w).Encode(data)
+	}
+}
+
+// s3ErrorOf переводит ошибки хранилища в коды S3.
+func s3ErrorOf(err error) *s3Error {
+	var e *s3Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, filestore.ErrBucketNotFound):
+		return errS3NoSuchBucket
+	case errors.Is(err, filestore.ErrBucketAlreadyExists):
+		return errS3BucketAlreadyOwnedByYou
+	case errors.Is(err, filestore.ErrBucketNotEmpty):
+		return errS3BucketNotEmpty
+	case errors.Is(err, filestore.ErrObjectNotFound):
+		return errS3NoSuchKey
+	case errors.Is(err, filestore.ErrUploadNotFound):
+		return errS3NoSuchUpload
+	case errors.Is(err, filestore.ErrInvalidPart):
+		return errS3InvalidPart
+	case errors.Is(err, filestore.ErrInvalidPartList):
+		return errS3InvalidPartOrder
+	case errors.Is(err, filestore.ErrMetadataTooLarge):
+		return errS3MetadataTooLarge
+	case errors.Is(err, filestore.ErrQuotaExceeded):
+		return errS3QuotaExceeded
+	case errors.Is(err, filestore.ErrFileQuotaExceeded):
+		return errS3FileQuotaExceeded
+	case errors.Is(err, filestore.ErrInvalidTag):
+		return errS3InvalidTag
+	case errors.Is(err, filestore.ErrTooManyTags):
+		return errS3TooManyTags
+	case errors.Is(err, filestore.ErrCustomerKeyRequired):
+		return errS3CustomerKeyRequired
+	case errors.Is(err, filestore.ErrCustomerKeyMismatch):
+		return errS3CustomerKeyMismatch
+	default:
+		return errS3InternalError
+	}
+}
+
+func s3OwnerOf(userID int) s3Owner {
+	id := strconv.Itoa(userID)
+	return s3Owner{ID: id, DisplayName: id}
+}
+
+func validObjectKey(key string) error {
+	if len(key) > s3MaxObjectKeyLen {
+		return errS3KeyTooLong
+	}
+	if key == "." || key == ".." {
+		return errS3InvalidArgument
+	}
+	return nil
+}
+
+// hasSubresource сообщает, что запрос адресован подресурсу объекта (?acl, ?tagging, ...),
+// который пока не поддерживается.
+func hasSubresource(r *http.Request) bool {
+	for name := range r.URL.Query() {
+		switch name {
+		case "X-Amz-Algorithm", "X-Amz-Credential", "X-Amz-Date", "X-Amz-Expires",
+			"X-Amz-SignedHeaders", "X-Amz-Signature", "X-Amz-Content-Sha256", "x-id",
+			"response-content-type", "response-content-disposition", "response-cache-control":
+			continue
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// s3URLEncode кодирует ключ для ответа с encoding-type=url. SDK декодируют его как
+// значение query-строки, поэтому пробел становится '+'; '/' оставлен как есть.
+func s3URLEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "%2F", "/")
+}
+
+// etag строит ETag объекта. Как и в S3, это MD5 содержимого, а для собранного из частей —
+// MD5 от MD5-сумм частей с их числом: SDK сверяют ETag с MD5 отправленного. У объектов,
+// загруженных до появления MD5, ETag — SHA-256 содержимого, а у содержимого без хешей
+// (зашифрованного ключом клиента) он строится по времени изменения и размеру.
+func etag(o *filestore.Object) string {
+	if o.ETag != "" {
+		return "\"" + o.ETag + "\""
+	}
+	if o.SHA256 != "" {
+		return "\"" + o.SHA256 + "\""
+	}
+	return fmt.Sprintf("\"%x-%x\"", o.LastModified.UnixNano(), o.Size)
+}
@@ -0,0 +1,51 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestS3URLEncode(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"photos/2006/january.jpg", "photos/2006/january.jpg"},
+		{"my file+1.txt", "my+file%2B1.txt"},
+		{"a&b=c?", "a%26b%3Dc%3F"},
+		{"ctrl\x01key", "ctrl%01key"},
+		{"файл", "%D1%84%D0%B0%D0%B9%D0%BB"},
+	}
+	for _, tc := range tests {
+		got := s3URLEncode(tc.key)
+		if got != tc.want {
+			t.Errorf("s3URLEncode(%q) = %q, want %q", tc.key, got, tc.want)
+		}
+		if decoded, err := url.QueryUnescape(got); err != nil || decoded != tc.key {
+			t.Errorf("%q decodes to %q, %v", got, decoded, err)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	summed := &filestore.Object{SHA256: "ab12", ETag: "cd34-2", Size: 10, LastModified: modTime}
+	if got := etag(summed); got != `"cd34-2"` {
+		t.Errorf("etag() = %s, want the stored ETag", got)
+	}
+
+	// Загруженные до появления MD5 — по SHA-256.
+	hashed := &filestore.Object{SHA256: "ab12", Size: 10, LastModified: modTime}
+	if got := etag(hashed); got != `"ab12"` {
+		t.Errorf("etag() = %s, want the content hash", got)
+	}
+
+	// Без хеша — по времени изменения и размеру.
+	plain := &filestore.Object{Size: 10, LastModified: modTime}
+	later := &filestore.Object{Size: 10, LastModified: modTime.Add(time.Second)}
+	if etag(plain) == etag(later) || etag(plain) == etag(hashed) {
+		t.Errorf("different objects have the same ETag %s", etag(plain))
+	}
+}
@@ -0,0 +1,104 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/mux"
+)
+
+type s3Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+type s3Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	TagSet  []s3Tag  `xml:"TagSet>Tag"`
+}
+
+func (s *s3Server) handleGetObjectTagging() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		tags, err := s.filestore.Tags(r.Context(), userID, vars["bucket"], vars["key"])
+		if err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+
+		resp := &s3Tagging{Xmlns: s3XMLNamespace, TagSet: make([]s3Tag, 0, len(tags))}
+		for k, v := range tags {
+			resp.TagSet = append(resp.TagSet, s3Tag{Key: k, Value: v})
+		}
+		s.respondXML(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *s3Server) handlePutObjectTagging() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		req := &s3Tagging{}
+		if err := xml.NewDecoder(r.Body).Decode(req); err != nil {
+			s.s3Error(w, r, errS3MalformedXML)
+			return
+		}
+		tags := make(map[string]string, len(req.TagSet))
+		for _, t := range req.TagSet {
+			if _, ok := tags[t.Key]; ok {
+				s.s3Error(w, r, errS3DuplicateTagKey)
+				return
+			}
+			tags[t.Key] = t.Value
+		}
+
+		if err := s.filestore.SetTags(r.Context(), userID, vars["bucket"], vars["key"], tags); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *s3Server) handleDeleteObjectTagging() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		if err := s.filestore.DeleteTags(r.Context(), userID, vars["bucket"], vars["key"]); err != nil {
+			s.s3Error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// tagsFromHeader разбирает теги из заголовка x-amz-tagging (key1=value1&key2=value2).
+// ok == false, если заголовка нет.
+func tagsFromHeader(h http.Header) (tags map[string]string, ok bool, err error) {
+	v := h.Get("X-Amz-Tagging")
+	if v == "" {
+		return nil, false, nil
+	}
+	values, err := url.ParseQuery(v)
+	if err != nil {
+		return nil, true, errS3InvalidTag
+	}
+	tags = make(map[string]string, len(values))
+	for k, vs := range values {
+		if len(vs) > 1 {
+			return nil, true, errS3DuplicateTagKey
+		}
+		tags[k] = vs[0]
+	}
+	if len(tags) > filestore.MaxTags {
+		return nil, true, errS3TooManyTags
+	}
+	return tags, true, nil
+}
@@ -1,9 +1,9 @@
 package apiserver
 
 import (
+	"S3_project/S3/internal/app/imaging"
 	"S3_project/S3/internal/app/store/filestore"
 	"context"
-	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -13,19 +13,23 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+	"io"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	filesNames           = "files"
-	fileDates            = "dates"
 	authorization        = "Authorization"
 	ctxKeyUserId  crtKey = iota
 	ctxKeyRequestID
 )
 
+const maxFilenameLength = 1024
+
 var (
 	secretKey              = []byte("secret")
 	errInternalServerError = errors.New("internal server error")
@@ -34,6 +38,8 @@ var (
 	errFileAlreadyExist    = errors.New("file already exist")
 	errDataBaseError       = errors.New("database error")
 	errFileNotFound        = errors.New("file not found")
+	errInvalidFilename     = errors.New("invalid filename")
+	errMissingFilePart     = errors.New("multipart form has no \"file\" part")
 )
 
 type crtKey int8
@@ -42,6 +48,13 @@ type Server struct {
 	router    *mux.Router
 	logger    *zap.Logger
 	filestore filestore.FileStore
+
+	// presignSecret подписывает presigned URL (см. presignKey).
+	presignSecret []byte
+
+	// thumbnails — дисковый кэш миниатюр или nil, если кэш выключен.
+	thumbnails    *imaging.Cache
+	thumbnailJobs chan thumbnailJob
 }
 
 func NewServer(filestore *filestore.FileStore, apiGatewayUrl string) *Server {
@@ -68,14 +81,31 @@ func (s *Server) configureRouter(apiGatewayUrl string) {
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
+			http.MethodHead,
 			http.MethodOptions,
 		}),
 		handlers.AllowedHeaders([]string{
 			"Content-Type",
 			"Authorization",
 			"X-Requested-With",
+			"X-Share-Password",
 			"Access-Control-Allow-Origin",
+			"Tus-Resumable",
+			"Upload-Length",
+			"Upload-Offset",
+			"Upload-Metadata",
+		}),
+		// Клиенту tus нужны заголовки ответов с адресом и смещением загрузки.
+		handlers.ExposedHeaders([]string{
+			"Location",
+			"Tus-Resumable",
+			"Tus-Version",
+			"Tus-Extension",
+			"Upload-Offset",
+			"Upload-Length",
+			"Upload-Expires",
 		}),
 	)
 
@@ -87,15 +117,77 @@ func (s *Server) configureRouter(apiGatewayUrl string) {
 	s.router.HandleFunc("/login", s.handleLogin()).Methods(http.MethodGet)
 	s.router.HandleFunc("/register", s.handleRegister()).Methods(http.MethodGet)
 	s.router.HandleFunc("/share/{uuid}", s.handleShared()).Methods(http.MethodGet)
-	s.router.HandleFunc("/file/{uuid}", s.handleDownloadFile()).Methods(http.MethodGet)
+	s.router.HandleFunc("/file/{uuid}", s.handleDownloadFile()).Methods(http.MethodGet, http.MethodHead)
+
+	// Presigned URL проверяются подписью вместо cookie, поэтому их маршруты
+	// регистрируются до общего /api с authenticateUser.
+	presigned := s.router.PathPrefix("/api/presigned").Subrouter()
+	presigned.Use(s.verifyPresigned)
+	presigned.HandleFunc("/download/{filename:.+}", s.handleDownloadRaw()).Methods(http.MethodGet, http.MethodHead)
+	presigned.HandleFunc("/upload/{filename:.+}", s.handleUploadRaw()).Methods(http.MethodPut)
 
 	api := s.router.PathPrefix("/api").Subrouter()
 	api.Use(s.authenticateUser)
 	api.HandleFunc("/files", s.handleFiles()).Methods(http.MethodGet)
+	api.HandleFunc("/files/{filename:.+}", s.handleStat()).Methods(http.MethodGet, http.MethodHead)
+	api.HandleFunc("/tags/{filename:.+}", s.handleTags()).Methods(http.MethodGet)
+	api.HandleFunc("/tags/{filename:.+}", s.handleSetTags()).Methods(http.MethodPut)
+	api.HandleFunc("/tags/{filename:.+}", s.handleDeleteTags()).Methods(http.MethodDelete)
 	api.HandleFunc("/download", s.handleDownload()).Methods(http.MethodPost)
+	api.HandleFunc("/download/{filename:.+}", s.handleDownloadRaw()).Methods(http.MethodGet, http.MethodHead)
+	api.HandleFunc("/archive", s.handleArchive()).Methods(http.MethodGet, http.MethodPost)
+	api.HandleFunc("/thumbnail", s.handleThumbnail()).Methods(http.MethodGet)
 	api.HandleFunc("/upload", s.handleUpload()).Methods(http.MethodPost)
+	api.HandleFunc("/upload/{filename:.+}", s.handleUploadRaw()).Methods(http.MethodPut)
 	api.HandleFunc("/delete", s.handleDelete()).Methods(http.MethodDelete)
+	api.HandleFunc("/rename", s.handleRename()).Methods(http.MethodPost)
+	api.HandleFunc("/move", s.handleMove()).Methods(http.MethodPost)
+	api.HandleFunc("/copy", s.handleCopy()).Methods(http.MethodPost)
+	api.HandleFunc("/batch", s.handleBatch()).Methods(http.MethodPost)
+	api.HandleFunc("/trash", s.handleTrash()).Methods(http.MethodGet)
+	api.HandleFunc("/trash", s.handleEmptyTrash()).Methods(http.MethodDelete)
+	api.HandleFunc("/trash/{id}", s.handleDeleteTrash()).Methods(http.MethodDelete)
+	api.HandleFunc("/trash/{id}/restore", s.handleRestoreTrash()).Methods(http.MethodPost)
+	api.HandleFunc("/lifecycle", s.handleLifecycleRules()).Methods(http.MethodGet)
+	api.HandleFunc("/lifecycle", s.handleCreateLifecycleRule()).Methods(http.MethodPost)
+	api.HandleFunc("/lifecycle/dry-run", s.handleLifecycleDryRun()).Methods(http.MethodGet)
+	api.HandleFunc("/lifecycle/{id}", s.handleDeleteLifecycleRule()).Methods(http.MethodDelete)
+	api.HandleFunc("/presign", s.handlePresign()).Methods(http.MethodPost)
 	api.HandleFunc("/share", s.handleShareFile()).Methods(http.MethodPost)
+	api.HandleFunc("/shares", s.handleShares()).Methods(http.MethodGet)
+	api.HandleFunc("/shares/{id}", s.handleRevokeShare()).Methods(http.MethodDelete)
+	api.HandleFunc("/buckets", s.handleBuckets()).Methods(http.MethodGet)
+	api.HandleFunc("/buckets", s.handleAddBucket()).Methods(http.MethodPost)
+	api.HandleFunc("/buckets/{bucket}", s.handleRemoveBucket()).Methods(http.MethodDelete)
+	api.HandleFunc("/versioning", s.handleVersioning()).Methods(http.MethodGet)
+	api.HandleFunc("/versioning", s.handleSetVersioning()).Methods(http.MethodPut)
+	api.HandleFunc("/versions", s.handleVersions()).Methods(http.MethodGet)
+	api.HandleFunc("/versions", s.handleDeleteVersion()).Methods(http.MethodDelete)
+	api.HandleFunc("/restore", s.handleRestore()).Methods(http.MethodPost)
+	api.HandleFunc("/uploads", s.handleUploads()).Methods(http.MethodGet)
+	api.HandleFunc("/uploads", s.handleCreateUpload()).Methods(http.MethodPost)
+	api.HandleFunc("/uploads/{upload_id}", s.handleUploadInfo()).Methods(http.MethodGet)
+	api.HandleFunc("/uploads/{upload_id}", s.handleAbortUpload()).Methods(http.MethodDelete)
+	api.HandleFunc("/uploads/{upload_id}/parts/{part_number}", s.handleUploadPart()).Methods(http.MethodPut)
+	api.HandleFunc("/uploads/{upload_id}/complete", s.handleCompleteUpload()).Methods(http.MethodPost)
+
+	// Докачиваемые загрузки по протоколу tus 1.0.
+	tus := api.PathPrefix("/tus").Subrouter()
+	tus.Use(s.tusResumable)
+	tus.HandleFunc("", s.handleTusOptions()).Methods(http.MethodOptions)
+	tus.HandleFunc("", s.handleTusCreate()).Methods(http.MethodPost)
+	tus.HandleFunc("/{id}", s.handleTusHead()).Methods(http.MethodHead)
+	tus.HandleFunc("/{id}", s.handleTusPatch()).Methods(http.MethodPatch)
+	tus.HandleFunc("/{id}", s.handleTusDelete()).Methods(http.MethodDelete)
+
+	api.HandleFunc("/chunks", s.handleChunking()).Methods(http.MethodGet)
+	api.HandleFunc("/chunks/missing", s.handleMissingChunks()).Methods(http.MethodPost)
+	api.HandleFunc("/chunks/assemble", s.handleAssembleChunks()).Methods(http.MethodPost)
+	api.HandleFunc("/chunks/{hash}", s.handlePutChunk()).Methods(http.MethodPut)
+	api.HandleFunc("/usage", s.handleUsage()).Methods(http.MethodGet)
+	api.HandleFunc("/keys", s.handleAccessKeys()).Methods(http.MethodGet)
+	api.HandleFunc("/keys", s.handleCreateAccessKey()).Methods(http.MethodPost)
+	api.HandleFunc("/keys/{key}", s.handleDeleteAccessKey()).Methods(http.MethodDelete)
 
 	s.router.PathPrefix("/").Handler(http.FileServer(http.Dir("S3\\static")))
 }
@@ -123,7 +215,9 @@ func (s *Server) handleRegister() http.HandlerFunc {
 
 func (s *Server) handleDelete() http.HandlerFunc {
 	type request struct {
-		Filename string `json:"filename"`
+		Bucket    string `json:"bucket"`
+		Filename  string `json:"filename"`
+		Permanent bool   `json:"permanent"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(ctxKeyUserId).(int)
@@ -139,7 +233,13 @@ func (s *Server) handleDelete() http.HandlerFunc {
 			return
 		}
 
-		userFiles, err := s.filestore.FindFiles(userID)
+		versioned, err := s.filestore.VersioningEnabled(r.Context(), userID, req.Bucket)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+
+		userFiles, err := s.filestore.FindFiles(userID, req.Bucket)
 		if err != nil {
 			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
 			return
@@ -147,12 +247,37 @@ func (s *Server) handleDelete() http.HandlerFunc {
 
 		for i := 0; i < len(userFiles); i++ {
 			if userFiles[i] == req.Filename {
-				err := s.filestore.Delete(userID, req.Filename)
+				if versioned {
+					// При версионировании файл не удаляется, а скрывается маркером удаления.
+					versionID, err := s.filestore.DeleteVersioned(r.Context(), userID, req.Bucket, req.Filename)
+					if err != nil {
+						s.error(w, r, http.StatusInternalServerError, err)
+						return
+					}
+					s.respond(w, r, http.StatusOK, map[string]string{"status": "ok", "version_id": versionID})
+					return
+				}
+
+				if req.Permanent {
+					if err := s.filestore.Delete(r.Context(), userID, req.Bucket, req.Filename); err != nil {
+						s.error(w, r, http.StatusInternalServerError, err)
+						return
+					}
+					s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+					return
+				}
+
+				// Без versioning файл попадает в корзину, откуда его можно восстановить.
+				item, err := s.filestore.Trash(r.Context(), userID, req.Bucket, req.Filename)
 				if err != nil {
 					s.error(w, r, http.StatusInternalServerError, err)
+					return
 				}
-
-				s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+				resp := map[string]string{"status": "ok"}
+				if item != nil {
+					resp["trash_id"] = item.ID
+				}
+				s.respond(w, r, http.StatusOK, resp)
 				return
 			}
 		}
@@ -161,111 +286,188 @@ func (s *Server) handleDelete() http.HandlerFunc {
 	}
 }
 
+// handleUpload принимает файл как multipart/form-data (поле "file" и
+// необязательные поля "bucket" и "filename", идущие до него) и пишет его на диск потоково.
+// Тип содержимого берется из заголовка части "file", пользовательские метаданные —
+// из заголовков X-Meta-* запроса.
+// JSON с base64 в поле "file" оставлен для обратной совместимости.
 func (s *Server) handleUpload() http.HandlerFunc {
 	type request struct {
-		Filename string `json:"filename"`
-		File     string `json:"file"`
+		Bucket      string            `json:"bucket"`
+		Filename    string            `json:"filename"`
+		File        string            `json:"file"`
+		ContentType string            `json:"content_type"`
+		Metadata    map[string]string `json:"metadata"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(ctxKeyUserId).(int)
 
-		req := &request{}
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if req.Filename == "" {
-			s.error(w, r, http.StatusBadRequest, errEmptyFile)
-			return
-		}
-
-		userFiles, err := s.filestore.FindFiles(userID)
-		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
-			return
-		}
-
-		for i := 0; i < len(userFiles); i++ {
-			if userFiles[i] == req.Filename {
-				s.error(w, r, http.StatusBadRequest, errFileAlreadyExist)
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "multipart/form-data" {
+			mr, err := r.MultipartReader()
+			if err != nil {
+				s.error(w, r, http.StatusBadRequest, err)
 				return
 			}
-		}
 
-		fileBytes, err := base64.StdEncoding.DecodeString(req.File)
-		if err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
+			bucket, filename := "", ""
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					s.error(w, r, http.StatusBadRequest, err)
+					return
+				}
+
+				switch part.FormName() {
+				case "bucket":
+					b, err := io.ReadAll(io.LimitReader(part, maxFilenameLength+1))
+					if err != nil {
+						s.error(w, r, http.StatusBadRequest, err)
+						return
+					}
+					bucket = string(b)
+				case "filename":
+					b, err := io.ReadAll(io.LimitReader(part, maxFilenameLength+1))
+					if err != nil {
+						s.error(w, r, http.StatusBadRequest, err)
+						return
+					}
+					filename = string(b)
+				case "file":
+					if filename == "" {
+						filename = part.FileName()
+					}
+					meta := metadataFromHeader(r.Header, metaPrefix)
+					meta.ContentType = contentTypeOf(http.Header(part.Header))
+					s.saveUpload(w, r, userID, bucket, filename, part, meta)
+					return
+				}
+			}
+			s.error(w, r, http.StatusBadRequest, errMissingFilePart)
 			return
 		}
 
-		if err := s.filestore.Save(userID, req.Filename, fileBytes); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+		meta := filestore.Metadata{ContentType: req.ContentType, User: req.Metadata}
+		s.saveUpload(w, r, userID, req.Bucket, req.Filename, base64.NewDecoder(base64.StdEncoding, strings.NewReader(req.File)), meta)
 	}
 }
 
-func (s *Server) handleShareFile() func(http.ResponseWriter, *http.Request) {
-	type request struct {
-		Filename string `json:"filename"`
-	}
+// handleUploadRaw принимает тело PUT-запроса как есть, имя файла берется из пути,
+// бакет — из параметра bucket, метаданные — из Content-Type и заголовков X-Meta-*.
+func (s *Server) handleUploadRaw() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(ctxKeyUserId).(int)
+		s.saveUpload(w, r, userID, r.URL.Query().Get("bucket"), mux.Vars(r)["filename"], r.Body, metadataFromHeader(r.Header, metaPrefix))
+	}
+}
 
-		req := &request{}
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			s.error(w, r, http.StatusBadRequest, err)
-			return
-		}
-
-		if req.Filename == "" {
-			s.error(w, r, http.StatusBadRequest, errEmptyFile)
-			return
-		}
+func (s *Server) saveUpload(w http.ResponseWriter, r *http.Request, userID int, bucket, filename string, body io.Reader, meta filestore.Metadata) {
+	key, err := customerKeyFromHeader(r.Header)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, err)
+		return
+	}
+	meta.CustomerKey = key
 
-		Uuid, err := s.filestore.Share(userID, req.Filename)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				s.error(w, r, http.StatusNotFound, errFileNotFound)
-				return
-			}
-			s.error(w, r, http.StatusInternalServerError, err)
-			return
-		}
-		s.respond(w, r, http.StatusOK, map[string]string{"status": Uuid})
+	versionID, err := s.storeUpload(r.Context(), userID, bucket, filename, body, meta)
+	if err != nil {
+		s.uploadError(w, r, err)
 		return
 	}
+	setCustomerKeyHeaders(w.Header(), key)
+
+	resp := map[string]string{"status": "ok"}
+	if versionID != "" {
+		resp["version_id"] = versionID
+	}
+	s.respond(w, r, http.StatusOK, resp)
 }
 
-func (s *Server) handleDownloadFile() func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		Uuid := vars["uuid"]
+// storeUpload проверяет имя и записывает файл. При версионировании повторная загрузка
+// того же имени создает новую версию, ее идентификатор возвращается.
+func (s *Server) storeUpload(ctx context.Context, userID int, bucket, filename string, body io.Reader, meta filestore.Metadata) (string, error) {
+	if filename == "" {
+		return "", errEmptyFile
+	}
+
+	if !validFilename(filename) {
+		return "", errInvalidFilename
+	}
 
-		userID, filename, err := s.filestore.FindByUUID(Uuid)
+	versioned, err := s.filestore.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return "", errDataBaseError
+	}
+	if versioned {
+		versionID, err := s.filestore.SaveVersion(ctx, userID, bucket, filename, body, meta)
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				s.error(w, r, http.StatusNotFound, errFileNotFound)
-				return
-			}
-			s.error(w, r, http.StatusInternalServerError, err)
-			return
+			return "", err
 		}
-		fileBytes, err := s.filestore.GetFileBytes(userID, filename)
-		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+		s.enqueueThumbnail(userID, bucket, filename)
+		return versionID, nil
+	}
+
+	userFiles, err := s.filestore.FindFiles(userID, bucket)
+	if err != nil {
+		return "", errDataBaseError
+	}
+
+	for i := 0; i < len(userFiles); i++ {
+		if userFiles[i] == filename {
+			return "", errFileAlreadyExist
 		}
-		// http.ServeFile(w, r, fullFilename)
-		s.respond(w, r, http.StatusOK, map[string]string{"base64": base64.StdEncoding.EncodeToString(fileBytes), "filename": filename})
-		return
+	}
+
+	if err := s.filestore.Save(ctx, userID, bucket, filename, body, meta); err != nil {
+		return "", err
+	}
+	s.enqueueThumbnail(userID, bucket, filename)
+	return "", nil
+}
+
+// uploadError отвечает на ошибку storeUpload.
+func (s *Server) uploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var (
+		corrupt  base64.CorruptInputError
+		tooLarge *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &tooLarge):
+		s.error(w, r, http.StatusRequestEntityTooLarge, errPresignedTooLarge)
+	case errors.Is(err, errEmptyFile),
+		errors.Is(err, errInvalidFilename),
+		errors.Is(err, errFileAlreadyExist),
+		errors.Is(err, filestore.ErrMetadataTooLarge),
+		errors.As(err, &corrupt):
+		s.error(w, r, http.StatusBadRequest, err)
+	default:
+		s.storeError(w, r, err)
+	}
+}
+
+// handleDownloadRaw отдает файл владельцу в бинарном виде с поддержкой Range и кэширования.
+// Бакет задается параметром bucket, по умолчанию — корневое пространство,
+// параметр version_id выбирает старую версию файла.
+func (s *Server) handleDownloadRaw() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		query := r.URL.Query()
+		s.serveFile(w, r, userID, query.Get("bucket"), mux.Vars(r)["filename"], query.Get("version_id"))
 	}
 }
 
 func (s *Server) handleDownload() http.HandlerFunc {
 	type request struct {
+		Bucket   string `json:"bucket"`
 		Filename string `json:"filename"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -280,18 +482,24 @@ func (s *Server) handleDownload() http.HandlerFunc {
 			return
 		}
 
+		key, err := customerKeyFromHeader(r.Header)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
 		userID := r.Context().Value(ctxKeyUserId).(int)
 
-		userFiles, err := s.filestore.FindFiles(userID)
+		userFiles, err := s.filestore.FindFiles(userID, req.Bucket)
 		if err != nil {
 			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
 			return
 		}
 		for i := 0; i < len(userFiles); i++ {
 			if userFiles[i] == req.Filename {
-				fileBytes, err := s.filestore.GetFileBytes(userID, req.Filename)
+				fileBytes, err := s.filestore.GetFileBytes(r.Context(), userID, req.Bucket, req.Filename, key)
 				if err != nil {
-					s.error(w, r, http.StatusInternalServerError, err)
+					s.storeError(w, r, err)
 					return
 				}
 				s.respond(w, r, http.StatusOK, map[string]string{"status": base64.StdEncoding.EncodeToString(fileBytes)})
@@ -303,36 +511,50 @@ func (s *Server) handleDownload() http.HandlerFunc {
 	}
 }
 
+// handleFiles возвращает страницу файлов бакета (параметр bucket) с ключами, начинающимися с prefix,
+// вместе с их метаданными. Если задан delimiter, вложенные пути сворачиваются в папки с "folder": true,
+// которые идут в начале первой страницы. Следующую страницу возвращает запрос с cursor=next_cursor
+// и теми же параметрами; на последней странице next_cursor пустой.
 func (s *Server) handleFiles() http.HandlerFunc {
-	type FileInfo struct {
-		Name string `json:"name"`
-		Date int    `json:"date"`
+	type response struct {
+		Files      []fileInfo `json:"files"`
+		NextCursor string     `json:"next_cursor"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(ctxKeyUserId).(int)
-		names, dates, err := s.filestore.FindFilesWithDates(userID)
+
+		opts, err := listOptionsOf(r.URL.Query())
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
-			return
-		}
-		numFiles := len(names)
-		if numFiles == 0 {
-			s.respond(w, r, http.StatusNoContent, map[string]string{filesNames: "", fileDates: ""})
+			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-		if numFiles < 0 {
-			s.error(w, r, http.StatusInternalServerError, errInternalServerError)
+
+		list, err := s.filestore.ListFiles(r.Context(), userID, r.URL.Query().Get("bucket"), opts)
+		if err != nil {
+			switch {
+			case errors.Is(err, filestore.ErrBucketNotFound):
+				s.error(w, r, http.StatusNotFound, err)
+			case errors.Is(err, filestore.ErrInvalidCursor), errors.Is(err, filestore.ErrInvalidSort):
+				s.error(w, r, http.StatusBadRequest, err)
+			default:
+				s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			}
 			return
 		}
 
-		files := make([]FileInfo, len(names))
-		for i := range names {
-			files[i] = FileInfo{
-				Name: names[i],
-				Date: dates[i],
-			}
+		files := make([]fileInfo, 0, len(list.Folders)+len(list.Objects))
+		for _, folder := range list.Folders {
+			files = append(files, fileInfo{
+				Name:   folder,
+				Folder: true,
+			})
 		}
-		s.respond(w, r, http.StatusOK, files)
+		for i := range list.Objects {
+			info := newFileInfo(&list.Objects[i])
+			info.Public = list.Public[info.Name]
+			files = append(files, info)
+		}
+		s.respond(w, r, http.StatusOK, &response{Files: files, NextCursor: list.NextCursor})
 	}
 }
 
@@ -422,11 +644,108 @@ func (s *Server) authenticateUser(next http.Handler) http.Handler {
 	})
 }
 
+// serveFile потоково отдает файл из хранилища, а при непустом versionID — его версию.
+// Range, If-None-Match, If-Modified-Since и 206 Partial Content обрабатывает http.ServeContent.
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, userID int, bucket, filename, versionID string) {
+	key, err := customerKeyFromHeader(r.Header)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		f io.ReadSeekCloser
+		o *filestore.Object
+	)
+	if versionID != "" {
+		f, o, err = s.filestore.OpenVersion(r.Context(), userID, bucket, filename, versionID, key)
+	} else {
+		f, o, err = s.filestore.Open(r.Context(), userID, bucket, filename, key)
+	}
+	if err != nil {
+		if errors.Is(err, filestore.ErrObjectNotFound) || errors.Is(err, filestore.ErrVersionNotFound) {
+			s.error(w, r, http.StatusNotFound, errFileNotFound)
+			return
+		}
+		s.storeError(w, r, err)
+		return
+	}
+	defer func(f io.Closer) {
+		_ = f.Close()
+	}(f)
+
+	if r.Method == http.MethodGet && versionID == "" {
+		if err := s.filestore.MarkAccessed(r.Context(), userID, bucket, filename); err != nil {
+			s.logger.Error("mark accessed", zap.Error(err))
+		}
+	}
+
+	disposition := "attachment"
+	if r.URL.Query().Get("disposition") == "inline" {
+		disposition = "inline"
+	}
+	if o.ContentType != "" {
+		w.Header().Set("Content-Type", o.ContentType)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(filename)}))
+	w.Header().Set("ETag", etag(o))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	setMetadataHeaders(w.Header(), metaPrefix, o)
+	setCustomerKeyHeaders(w.Header(), key)
+
+	http.ServeContent(w, r, filename, o.LastModified, f)
+}
+
 func (s *Server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
 	s.logger.Error("error", zap.Error(err))
 	s.respond(w, r, code, map[string]string{"error": err.Error()})
 }
 
+// storeError переводит ошибки хранилища в HTTP-статусы.
+func (s *Server) storeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, err := storeStatus(err)
+	s.error(w, r, code, err)
+}
+
+// storeStatus переводит ошибку хранилища в HTTP-статус и ошибку, которую видит клиент.
+func storeStatus(err error) (int, error) {
+	switch {
+	case errors.Is(err, filestore.ErrBucketNotFound),
+		errors.Is(err, filestore.ErrObjectNotFound),
+		errors.Is(err, filestore.ErrVersionNotFound),
+		errors.Is(err, filestore.ErrUploadNotFound),
+		errors.Is(err, filestore.ErrTrashItemNotFound):
+		return http.StatusNotFound, err
+	case errors.Is(err, filestore.ErrInvalidPart),
+		errors.Is(err, filestore.ErrInvalidPartList),
+		errors.Is(err, filestore.ErrMetadataTooLarge),
+		errors.Is(err, filestore.ErrSameObject),
+		errors.Is(err, filestore.ErrInvalidTag),
+		errors.Is(err, filestore.ErrTooManyTags),
+		errors.Is(err, filestore.ErrCustomerKeyRequired),
+		errors.Is(err, filestore.ErrChunkNotFound),
+		errors.Is(err, filestore.ErrChunkHashMismatch):
+		return http.StatusBadRequest, err
+	case errors.Is(err, filestore.ErrCustomerKeyMismatch):
+		return http.StatusForbidden, err
+	case errors.Is(err, filestore.ErrObjectAlreadyExists):
+		return http.StatusBadRequest, errFileAlreadyExist
+	case errors.Is(err, filestore.ErrPathConflict),
+		errors.Is(err, filestore.ErrBucketAlreadyExists),
+		errors.Is(err, filestore.ErrBucketNotEmpty):
+		return http.StatusConflict, err
+	case errors.Is(err, filestore.ErrQuotaExceeded),
+		errors.Is(err, filestore.ErrChunkTooLarge):
+		return http.StatusRequestEntityTooLarge, err
+	case errors.Is(err, filestore.ErrFileQuotaExceeded):
+		return http.StatusInsufficientStorage, err
+	case errors.Is(err, filestore.ErrChunkingDisabled):
+		return http.StatusNotImplemented, err
+	default:
+		return http.StatusInternalServerError, err
+	}
+}
+
 func (s *Server) respond(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
@@ -440,3 +759,17 @@ func parseToken(tokenString string) (*jwt.Token, error) {
 		return secretKey, nil
 	})
 }
+
+// validFilename не пропускает имена, которые могут выйти за пределы каталога пользователя.
+// "/" разделяет папки, поэтому пустые сегменты, "." и ".." запрещены.
+func validFilename(filename string) bool {
+	if len(filename) > maxFilenameLength || strings.ContainsAny(filename, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(filename, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sharePasswordHeader — заголовок с паролем ссылки. Параметром запроса пароль не принимается:
+// адрес попадает в журналы сервера и прокси и в историю браузера.
+const sharePasswordHeader = "X-Share-Password"
+
+var errInvalidShareOptions = errors.New("expires_in and max_downloads must not be negative")
+
+// handleShareFile создает новую публичную ссылку на файл, а при folder — на папку filename.
+// Срок действия (expires_at или expires_in в секундах), пароль и лимит скачиваний необязательны.
+// Идентификатор ссылки по-прежнему возвращается и в поле status.
+func (s *Server) handleShareFile() http.HandlerFunc {
+	type request struct {
+		Bucket       string    `json:"bucket"`
+		Filename     string    `json:"filename"`
+		ExpiresAt    time.Time `json:"expires_at"`
+		ExpiresIn    int64     `json:"expires_in"`
+		Password     string    `json:"password"`
+		MaxDownloads int       `json:"max_downloads"`
+		Folder       bool      `json:"folder"`
+	}
+	type response struct {
+		Status string `json:"status"`
+		*filestore.Share
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if req.ExpiresIn < 0 || req.MaxDownloads < 0 {
+			s.error(w, r, http.StatusBadRequest, errInvalidShareOptions)
+			return
+		}
+
+		opts := filestore.ShareOptions{
+			ExpiresAt:    req.ExpiresAt,
+			Password:     req.Password,
+			MaxDownloads: req.MaxDownloads,
+			Folder:       req.Folder,
+		}
+		if req.ExpiresIn > 0 {
+			opts.ExpiresAt = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		}
+
+		share, err := s.filestore.Share(r.Context(), userID, req.Bucket, req.Filename, opts)
+		if err != nil {
+			if errors.Is(err, filestore.ErrObjectNotFound) {
+				s.error(w, r, http.StatusNotFound, errFileNotFound)
+				return
+			}
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Status: share.ID, Share: share})
+	}
+}
+
+// handleShares возвращает ссылки на файл (параметры bucket и filename),
+// а без filename — на все файлы бакета.
+func (s *Server) handleShares() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		query := r.URL.Query()
+
+		shares, err := s.filestore.ListShares(r.Context(), userID, query.Get("bucket"), query.Get("filename"))
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		if shares == nil {
+			shares = []filestore.Share{}
+		}
+		s.respond(w, r, http.StatusOK, shares)
+	}
+}
+
+func (s *Server) handleRevokeShare() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.RevokeShare(r.Context(), userID, mux.Vars(r)["id"]); err != nil {
+			s.shareError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleDownloadFile отдает файл по публичной ссылке, а по ссылке на папку — архив ее файлов
+// (параметр format: zip или tar.gz). Скачиванием считается ответ на GET, который отдает файл
+// с первого байта (см. shareDownloadWriter), поэтому докачка лимит не расходует.
+func (s *Server) handleDownloadFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, err := s.filestore.FindByUUID(r.Context(), mux.Vars(r)["uuid"], r.Header.Get(sharePasswordHeader))
+		if err != nil {
+			s.shareError(w, r, err)
+			return
+		}
+
+		format := archiveZip
+		if share.Folder {
+			if v := r.URL.Query().Get("format"); v != "" {
+				format = v
+			}
+			if format != archiveZip && format != archiveTarGz {
+				s.error(w, r, http.StatusBadRequest, errArchiveFormat)
+				return
+			}
+		}
+
+		if r.Method == http.MethodGet {
+			w = s.countShareDownload(w, r, share.ID)
+		}
+		if share.Folder {
+			s.serveFolderArchive(w, r, share.UserID, share.Bucket, share.Filename, format)
+			return
+		}
+		s.serveFile(w, r, share.UserID, share.Bucket, share.Filename, "")
+	}
+}
+
+// countShareDownload оборачивает w так, чтобы скачивание по ссылке id засчитывалось,
+// когда ответ начинает отдавать файл.
+func (s *Server) countShareDownload(w http.ResponseWriter, r *http.Request, id string) http.ResponseWriter {
+	return newShareDownloadWriter(w,
+		func() error {
+			return s.filestore.CountShareDownload(r.Context(), id)
+		},
+		func(w http.ResponseWriter, err error) {
+			s.shareError(w, r, err)
+		},
+	)
+}
+
+// shareDownloadWriter засчитывает скачивание, когда ответ уже решен: статус 200 или 206
+// с диапазоном от первого байта. Диапазон при этом разобран так же, как его разбирает
+// http.ServeContent, поэтому считаются и суффиксные диапазоны, покрывающие весь файл,
+// и "bytes= 0-", а 304 и докачка с середины — нет. Если лимит исчерпан, вместо файла
+// отдается ошибка.
+type shareDownloadWriter struct {
+	http.ResponseWriter
+	count func() error
+	fail  func(w http.ResponseWriter, err error)
+	// header — заголовки до отдачи файла: при ошибке заголовки файла отбрасываются.
+	header      http.Header
+	wroteHeader bool
+	err         error
+}
+
+func newShareDownloadWriter(w http.ResponseWriter, count func() error, fail func(w http.ResponseWriter, err error)) *shareDownloadWriter {
+	return &shareDownloadWriter{
+		ResponseWriter: w,
+		count:          count,
+		fail:           fail,
+		header:         w.Header().Clone(),
+	}
+}
+
+func (w *shareDownloadWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if countsAsDownload(code, w.Header()) {
+		if err := w.count(); err != nil {
+			w.err = err
+			h := w.Header()
+			clear(h)
+			for key, values := range w.header {
+				h[key] = values
+			}
+			w.fail(w.ResponseWriter, err)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *shareDownloadWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *shareDownloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countsAsDownload сообщает, отдает ли ответ с кодом code и заголовками h файл с первого байта.
+// Ответ на несколько диапазонов (multipart/byteranges) считается всегда.
+func countsAsDownload(code int, h http.Header) bool {
+	switch code {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		contentRange := h.Get("Content-Range")
+		return contentRange == "" || strings.HasPrefix(contentRange, "bytes 0-")
+	}
+	return false
+}
+
+// shareError переводит ошибки публичных ссылок в HTTP-статусы.
+func (s *Server) shareError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrShareNotFound):
+		s.error(w, r, http.StatusNotFound, errFileNotFound)
+	case errors.Is(err, filestore.ErrShareExpired), errors.Is(err, filestore.ErrShareLimitExceeded):
+		s.error(w, r, http.StatusGone, err)
+	case errors.Is(err, filestore.ErrSharePassword):
+		s.error(w, r, http.StatusUnauthorized, err)
+	default:
+		s.error(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,94 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestShareDownloadCounting(t *testing.T) {
+	content := []byte("0123456789")
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantCount  int
+	}{
+		{"whole file", nil, http.StatusOK, 1},
+		{"from the start", map[string]string{"Range": "bytes=0-"}, http.StatusPartialContent, 1},
+		{"first bytes", map[string]string{"Range": "bytes=0-3"}, http.StatusPartialContent, 1},
+		{"space before the range", map[string]string{"Range": "bytes= 0-"}, http.StatusPartialContent, 1},
+		{"suffix covering the file", map[string]string{"Range": "bytes=-10"}, http.StatusPartialContent, 1},
+		{"suffix longer than the file", map[string]string{"Range": "bytes=-100"}, http.StatusPartialContent, 1},
+		{"several ranges", map[string]string{"Range": "bytes=0-1,5-6"}, http.StatusPartialContent, 1},
+		{"resume", map[string]string{"Range": "bytes=4-"}, http.StatusPartialContent, 0},
+		{"file tail", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, 0},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, 0},
+		{"not modified", map[string]string{"If-None-Match": `"v1"`}, http.StatusNotModified, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/file/1", nil)
+			for key, value := range tc.header {
+				r.Header.Set(key, value)
+			}
+			counted := 0
+			w := serveShared(r, content, func() error {
+				counted++
+				return nil
+			})
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tc.wantStatus)
+			}
+			if counted != tc.wantCount {
+				t.Errorf("counted %d downloads, want %d", counted, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestShareDownloadLimitExceeded(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/file/1", nil)
+	r.Header.Set("Range", "bytes=-100")
+	w := serveShared(r, []byte("secret content"), func() error {
+		return filestore.ErrShareLimitExceeded
+	})
+
+	if w.Code != http.StatusGone {
+		t.Errorf("status %d, want %d", w.Code, http.StatusGone)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("body %q contains the file", w.Body)
+	}
+	for _, key := range []string{"Content-Range", "Content-Disposition", "ETag"} {
+		if v := w.Header().Get(key); v != "" {
+			t.Errorf("%s = %q left from the file", key, v)
+		}
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the header set before the file", got)
+	}
+}
+
+// serveShared отдает content так же, как serveFile, засчитывая скачивания через count.
+func serveShared(r *http.Request, content []byte, count func() error) *httptest.ResponseRecorder {
+	s := &Server{logger: zap.NewNop()}
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Access-Control-Allow-Origin", "*")
+
+	w := newShareDownloadWriter(rec, count, func(w http.ResponseWriter, err error) {
+		s.shareError(w, r, err)
+	})
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "a.txt", time.Now(), bytes.NewReader(content))
+	return rec
+}
@@ -0,0 +1,484 @@
+package apiserver
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm        = "AWS4-HMAC-SHA256"
+	sigV4ChunkAlgorithm   = "AWS4-HMAC-SHA256-PAYLOAD"
+	sigV4Terminator       = "aws4_request"
+	amzDateFormat         = "20060102T150405Z"
+	unsignedPayload       = "UNSIGNED-PAYLOAD"
+	streamingPayload      = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingUnsigned     = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptyPayloadHash      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew          = 15 * time.Minute
+	maxPresignedExpires   = 7 * 24 * time.Hour
+	maxChunkHeaderLength  = 4096
+	maxChunkTrailerLength = 4096
+)
+
+// sigV4Request — разобранные параметры подписи из заголовка Authorization
+// или из query-строки presigned URL.
+type sigV4Request struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+func (v *sigV4Request) scope() string {
+	return strings.Join([]string{v.date, v.region, v.service, sigV4Terminator}, "/")
+}
+
+func parseSigV4(r *http.Request) (*sigV4Request, error) {
+	q := r.URL.Query()
+	if q.Get("X-Amz-Algorithm") != "" {
+		return parsePresignedSigV4(q)
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil, errS3AccessDenied
+	}
+	if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	v := &sigV4Request{
+		amzDate:     r.Header.Get("X-Amz-Date"),
+		payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, errS3AuthorizationMalformed
+		}
+		switch name {
+		case "Credential":
+			if err := v.parseCredential(value); err != nil {
+				return nil, err
+			}
+		case "SignedHeaders":
+			v.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			v.signature = value
+		}
+	}
+
+	if v.accessKey == "" || len(v.signedHeaders) == 0 || v.signature == "" || v.amzDate == "" {
+		return nil, errS3AuthorizationMalformed
+	}
+	if v.payloadHash == "" {
+		return nil, errS3MissingContentSHA256
+	}
+	return v, nil
+}
+
+func parsePresignedSigV4(q url.Values) (*sigV4Request, error) {
+	if q.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	v := &sigV4Request{
+		signedHeaders: strings.Split(q.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     q.Get("X-Amz-Signature"),
+		amzDate:       q.Get("X-Amz-Date"),
+		payloadHash:   unsignedPayload,
+		presigned:     true,
+	}
+	if hash := q.Get("X-Amz-Content-Sha256"); hash != "" {
+		v.payloadHash = hash
+	}
+	if err := v.parseCredential(q.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+
+	expires, err := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if err != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignedExpires {
+		return nil, errS3AuthorizationMalformed
+	}
+	v.expires = time.Duration(expires) * time.Second
+
+	if v.signature == "" || v.amzDate == "" {
+		return nil, errS3AuthorizationMalformed
+	}
+	return v, nil
+}
+
+func (v *sigV4Request) parseCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != sigV4Terminator {
+		return errS3AuthorizationMalformed
+	}
+	v.accessKey, v.date, v.region, v.service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// verify проверяет подпись запроса секретом secret и возвращает ключ подписи,
+// который нужен для проверки подписей чанков потоковой загрузки.
+func (v *sigV4Request) verify(r *http.Request, secret, region string, now time.Time) ([]byte, error) {
+	t, err := time.Parse(amzDateFormat, v.amzDate)
+	if err != nil || t.Format("20060102") != v.date {
+		return nil, errS3AuthorizationMalformed
+	}
+	if v.region != region || v.service != "s3" {
+		return nil, errS3AuthorizationMalformed
+	}
+
+	if v.presigned {
+		if now.Before(t.Add(-maxClockSkew)) {
+			return nil, errS3RequestTimeTooSkewed
+		}
+		if now.After(t.Add(v.expires)) {
+			return nil, errS3PresignedExpired
+		}
+	} else if now.Sub(t) > maxClockSkew || t.Sub(now) > maxClockSkew {
+		return nil, errS3RequestTimeTooSkewed
+	}
+
+	key := sigV4SigningKey(secret, v.date, v.region, v.service)
+	toSign := strings.Join([]string{
+		sigV4Algorithm,
+		v.amzDate,
+		v.scope(),
+		sha256Hex([]byte(canonicalRequest(r, v.signedHeaders, v.payloadHash, v.presigned))),
+	}, "\n")
+
+	expected := hex.EncodeToString(hmacSHA256(key, []byte(toSign)))
+	if !hmac.Equal([]byte(expected), []byte(v.signature)) {
+		return nil, errS3SignatureDoesNotMatch
+	}
+	return key, nil
+}
+
+// wrapBody подменяет тело запроса так, чтобы обработчики читали уже проверенные данные:
+// сверяет SHA-256 тела с x-amz-content-sha256 или снимает aws-chunked кодирование.
+func (v *sigV4Request) wrapBody(r *http.Request, key []byte) error {
+	switch v.payloadHash {
+	case unsignedPayload:
+		return nil
+	case streamingPayload, streamingUnsigned:
+		r.Body = &awsChunkedReader{
+			body:      r.Body,
+			r:         bufio.NewReader(r.Body),
+			signed:    v.payloadHash == streamingPayload,
+			key:       key,
+			amzDate:   v.amzDate,
+			scope:     v.scope(),
+			prevSig:   v.signature,
+			chunkHash: sha256.New(),
+		}
+		if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+			if n, err := strconv.ParseInt(decoded, 10, 64); err == nil {
+				r.ContentLength = n
+			}
+		}
+		return nil
+	default:
+		if _, err := hex.DecodeString(v.payloadHash); err != nil || len(v.payloadHash) != sha256.Size*2 {
+			return errS3InvalidContentSHA256
+		}
+		r.Body = &payloadHashReader{
+			ReadCloser: r.Body,
+			hash:       sha256.New(),
+			expected:   v.payloadHash,
+		}
+		return nil
+	}
+}
+
+func canonicalRequest(r *http.Request, signedHeaders []string, payloadHash string, presigned bool) string {
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(path, false),
+		canonicalQuery(r.URL.Query(), presigned),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(q url.Values, presigned bool) string {
+	if presigned {
+		q.Del("X-Amz-Signature")
+	}
+
+	type pair struct{ name, value string }
+	pairs := make([]pair, 0, len(q))
+	for name, values := range q {
+		for _, value := range values {
+			pairs = append(pairs, pair{awsURIEncode(name, true), awsURIEncode(value, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].name != pairs[j].name {
+			return pairs[i].name < pairs[j].name
+		}
+		return pairs[i].value < pairs[j].value
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p.name + "=" + p.value
+	}
+	return strings.Join(encoded, "&")
+}
+
+func canonicalHeaders(r *http.Request, signedHeaders []string) string {
+	var b strings.Builder
+	for _, name := range signedHeaders {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values(name)
+			if len(values) == 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		default:
+			values = r.Header.Values(name)
+		}
+
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(trimmed, ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// awsURIEncode кодирует строку по правилам SigV4: не экранируются только
+// A-Z, a-z, 0-9, '-', '.', '_', '~' и, для пути, '/'.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sigV4SigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte(sigV4Terminator))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// payloadHashReader сверяет SHA-256 прочитанного тела с подписанным значением
+// и возвращает ошибку вместо io.EOF, если они не совпали.
+type payloadHashReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (r *payloadHashReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if errors.Is(err, io.EOF) && hex.EncodeToString(r.hash.Sum(nil)) != r.expected {
+		return n, errS3ContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// awsChunkedReader декодирует тело в формате aws-chunked:
+//
+//	<hex-size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;chunk-signature=<sig>]\r\n[trailers]\r\n
+//
+// Для подписанных потоков подпись каждого чанка проверяется по цепочке от подписи запроса.
+type awsChunkedReader struct {
+	body      io.Closer
+	r         *bufio.Reader
+	signed    bool
+	key       []byte
+	amzDate   string
+	scope     string
+	prevSig   string
+	chunkSig  string
+	chunkHash hash.Hash
+	remaining int64
+	inChunk   bool
+	err       error
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil && c.remaining == 0 {
+		if c.inChunk {
+			c.err = c.finishChunk()
+			continue
+		}
+		c.err = c.nextChunk()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.chunkHash.Write(p[:n])
+	c.remaining -= int64(n)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *awsChunkedReader) Close() error {
+	return c.body.Close()
+}
+
+func (c *awsChunkedReader) nextChunk() error {
+	line, err := c.readLine(maxChunkHeaderLength)
+	if err != nil {
+		return err
+	}
+
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 {
+		return errS3IncompleteBody
+	}
+
+	c.chunkSig = ""
+	if c.signed {
+		sig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok {
+			return errS3SignatureDoesNotMatch
+		}
+		c.chunkSig = sig
+	}
+
+	c.chunkHash.Reset()
+	if size == 0 {
+		if err := c.verifyChunk(); err != nil {
+			return err
+		}
+		return c.readTrailers()
+	}
+
+	c.remaining = size
+	c.inChunk = true
+	return nil
+}
+
+func (c *awsChunkedReader) finishChunk() error {
+	c.inChunk = false
+	line, err := c.readLine(2)
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return errS3IncompleteBody
+	}
+	return c.verifyChunk()
+}
+
+func (c *awsChunkedReader) verifyChunk() error {
+	if !c.signed {
+		return nil
+	}
+
+	toSign := strings.Join([]string{
+		sigV4ChunkAlgorithm,
+		c.amzDate,
+		c.scope,
+		c.prevSig,
+		emptyPayloadHash,
+		hex.EncodeToString(c.chunkHash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, []byte(toSign)))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return errS3SignatureDoesNotMatch
+	}
+
+	c.prevSig = c.chunkSig
+	return nil
+}
+
+// readTrailers пропускает заголовки после последнего чанка (например, x-amz-checksum-crc32).
+func (c *awsChunkedReader) readTrailers() error {
+	for {
+		line, err := c.readLine(maxChunkTrailerLength)
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			return io.EOF
+		}
+	}
+}
+
+func (c *awsChunkedReader) readLine(limit int) (string, error) {
+	var b strings.Builder
+	for b.Len() <= limit {
+		ch, err := c.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return "", errS3IncompleteBody
+			}
+			return "", err
+		}
+		if ch == '\n' {
+			return strings.TrimSuffix(b.String(), "\r"), nil
+		}
+		b.WriteByte(ch)
+	}
+	return "", errS3IncompleteBody
+}
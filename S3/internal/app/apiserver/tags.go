@@ -0,0 +1,84 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var errInvalidTagFilter = errors.New("tag filter must be key, !key, key=value or key!=value")
+
+// handleTags возвращает теги файла (бакет — параметр bucket).
+func (s *Server) handleTags() http.HandlerFunc {
+	type response struct {
+		Tags map[string]string `json:"tags"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		tags, err := s.filestore.Tags(r.Context(), userID, r.URL.Query().Get("bucket"), mux.Vars(r)["filename"])
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Tags: tags})
+	}
+}
+
+// handleSetTags заменяет теги файла целиком набором из тела запроса.
+func (s *Server) handleSetTags() http.HandlerFunc {
+	type request struct {
+		Tags map[string]string `json:"tags"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.filestore.SetTags(r.Context(), userID, r.URL.Query().Get("bucket"), mux.Vars(r)["filename"], req.Tags); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleDeleteTags удаляет все теги файла.
+func (s *Server) handleDeleteTags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteTags(r.Context(), userID, r.URL.Query().Get("bucket"), mux.Vars(r)["filename"]); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// parseTagFilter разбирает условие поиска по тегу: "key" — тег есть, "!key" — тега нет,
+// "key=value" — тег с этим значением, "key!=value" — тега с этим значением нет.
+func parseTagFilter(expr string) (filestore.TagFilter, error) {
+	var t filestore.TagFilter
+	if key, value, ok := strings.Cut(expr, "!="); ok {
+		t = filestore.TagFilter{Key: key, Value: value, HasValue: true, Negate: true}
+	} else if key, value, ok := strings.Cut(expr, "="); ok {
+		t = filestore.TagFilter{Key: key, Value: value, HasValue: true}
+	} else if key, ok := strings.CutPrefix(expr, "!"); ok {
+		t = filestore.TagFilter{Key: key, Negate: true}
+	} else {
+		t = filestore.TagFilter{Key: expr}
+	}
+	if t.Key == "" {
+		return t, errInvalidTagFilter
+	}
+	return t, nil
+}
@@ -0,0 +1,200 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/imaging"
+	"S3_project/S3/internal/app/store/filestore"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// thumbnailQueueSize — сколько загруженных файлов может ждать построения миниатюры.
+// Если очередь полна, миниатюра строится при первом запросе.
+const thumbnailQueueSize = 256
+
+var errInvalidCrop = errors.New("crop must be x,y,width,height")
+
+// defaultThumbnail — параметры миниатюры по умолчанию, которую строит runThumbnailWorker.
+// Пустые параметры нормализуются всегда, поэтому ошибка здесь — ошибка в imaging.
+var defaultThumbnail = func() imaging.Options {
+	opts, err := imaging.Options{}.Normalize()
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}()
+
+type thumbnailJob struct {
+	userID   int
+	bucket   string
+	filename string
+}
+
+// enqueueThumbnail ставит загруженный файл в очередь на построение миниатюры по умолчанию.
+func (s *Server) enqueueThumbnail(userID int, bucket, filename string) {
+	select {
+	case s.thumbnailJobs <- thumbnailJob{userID: userID, bucket: bucket, filename: filename}:
+	default:
+	}
+}
+
+// runThumbnailWorker заранее строит миниатюры загруженных изображений, чтобы список
+// файлов показывал их без задержки.
+func (s *Server) runThumbnailWorker() {
+	for job := range s.thumbnailJobs {
+		o, err := s.filestore.Head(context.Background(), job.userID, job.bucket, job.filename)
+		if err != nil || o.KeyFingerprint != "" || !imaging.Supported(o.ContentType) {
+			continue
+		}
+		if _, _, err := s.thumbnail(context.Background(), job.userID, job.bucket, job.filename, "", nil, defaultThumbnail); err != nil {
+			s.logger.Error("thumbnail worker", zap.String("filename", job.filename), zap.Error(err))
+		}
+	}
+}
+
+// handleThumbnail отдает миниатюру изображения или его преобразованную копию. Параметры
+// w, h, fit, crop, format и q описывают преобразование (см. imaging.Options), без них —
+// миниатюра по умолчанию. Результаты кэшируются на диске по содержимому файла.
+func (s *Server) handleThumbnail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		query := r.URL.Query()
+
+		filename := query.Get("filename")
+		if filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		opts, err := thumbnailOptions(query)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		key, err := customerKeyFromHeader(r.Header)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		data, contentType, err := s.thumbnail(r.Context(), userID, query.Get("bucket"), filename, query.Get("version_id"), key, opts)
+		if err != nil {
+			switch {
+			case errors.Is(err, imaging.ErrUnsupportedImage):
+				s.error(w, r, http.StatusUnsupportedMediaType, err)
+			case errors.Is(err, imaging.ErrImageTooLarge):
+				s.error(w, r, http.StatusRequestEntityTooLarge, err)
+			case errors.Is(err, imaging.ErrInvalidOptions):
+				s.error(w, r, http.StatusBadRequest, err)
+			default:
+				s.storeError(w, r, err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		sum := sha256.Sum256(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}
+}
+
+// thumbnail строит преобразованную копию изображения или берет ее из кэша. Копии файлов,
+// зашифрованных ключом клиента, не кэшируются: на диске они лежали бы незашифрованными.
+func (s *Server) thumbnail(ctx context.Context, userID int, bucket, filename, versionID string, key []byte, opts imaging.Options) ([]byte, string, error) {
+	var (
+		f   io.ReadSeekCloser
+		o   *filestore.Object
+		err error
+	)
+	if versionID != "" {
+		f, o, err = s.filestore.OpenVersion(ctx, userID, bucket, filename, versionID, key)
+	} else {
+		f, o, err = s.filestore.Open(ctx, userID, bucket, filename, key)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer func(f io.Closer) {
+		_ = f.Close()
+	}(f)
+	if !imaging.Supported(o.ContentType) {
+		return nil, "", imaging.ErrUnsupportedImage
+	}
+
+	cacheKey := ""
+	if s.thumbnails != nil && o.KeyFingerprint == "" {
+		cacheKey = imaging.Key(thumbnailSource(userID, bucket, filename, o), opts)
+		if data, ok := s.thumbnails.Get(cacheKey); ok {
+			return data, http.DetectContentType(data), nil
+		}
+	}
+
+	data, contentType, err := imaging.Transform(f, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	if cacheKey != "" {
+		if err := s.thumbnails.Put(cacheKey, data); err != nil {
+			s.logger.Error("thumbnail cache", zap.Error(err))
+		}
+	}
+	return data, contentType, nil
+}
+
+// thumbnailSource идентифицирует содержимое изображения: одинаковые файлы делят
+// копии в кэше, а перезаписанный файл получает новые. У файлов, загруженных
+// до появления SHA-256, содержимое определяется именем и временем изменения.
+func thumbnailSource(userID int, bucket, filename string, o *filestore.Object) string {
+	if o.SHA256 != "" {
+		return o.SHA256
+	}
+	return fmt.Sprintf("%d/%s/%s@%d/%d", userID, bucket, filename, o.LastModified.UnixNano(), o.Size)
+}
+
+// thumbnailOptions читает параметры преобразования из запроса.
+func thumbnailOptions(query url.Values) (imaging.Options, error) {
+	var (
+		opts imaging.Options
+		err  error
+	)
+	for name, dst := range map[string]*int{"w": &opts.Width, "h": &opts.Height, "q": &opts.Quality} {
+		if v := query.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return opts, fmt.Errorf("%w: %s must be a number", imaging.ErrInvalidOptions, name)
+			}
+		}
+	}
+	opts.Fit = query.Get("fit")
+	opts.Format = strings.ToLower(query.Get("format"))
+	if opts.Format == "jpg" {
+		opts.Format = imaging.FormatJPEG
+	}
+
+	if v := query.Get("crop"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return opts, errInvalidCrop
+		}
+		var n [4]int
+		for i, p := range parts {
+			if n[i], err = strconv.Atoi(strings.TrimSpace(p)); err != nil {
+				return opts, errInvalidCrop
+			}
+		}
+		opts.Crop = image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3])
+	}
+	return opts.Normalize()
+}
@@ -0,0 +1,123 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// trashJanitorInterval — как часто из корзины удаляются файлы с истекшим сроком хранения.
+const trashJanitorInterval = time.Hour
+
+// handleTrash возвращает содержимое корзины, а с параметром bucket — только файлы,
+// удаленные из этого бакета.
+func (s *Server) handleTrash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		items, err := s.filestore.ListTrash(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Has("bucket") {
+			bucket := query.Get("bucket")
+			filtered := items[:0]
+			for _, item := range items {
+				if item.Bucket == bucket {
+					filtered = append(filtered, item)
+				}
+			}
+			items = filtered
+		}
+		if items == nil {
+			items = []filestore.TrashItem{}
+		}
+		s.respond(w, r, http.StatusOK, items)
+	}
+}
+
+// handleRestoreTrash возвращает файл из корзины. Необязательное поле filename
+// задает новое имя, если прежнее уже занято.
+func (s *Server) handleRestoreTrash() http.HandlerFunc {
+	type request struct {
+		Filename string `json:"filename"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if req.Filename != "" && !validFilename(req.Filename) {
+			s.error(w, r, http.StatusBadRequest, errInvalidFilename)
+			return
+		}
+
+		o, err := s.filestore.RestoreTrash(r.Context(), userID, mux.Vars(r)["id"], req.Filename)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, newFileInfo(o))
+	}
+}
+
+// handleDeleteTrash безвозвратно удаляет один файл из корзины.
+func (s *Server) handleDeleteTrash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteTrash(r.Context(), userID, mux.Vars(r)["id"]); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleEmptyTrash безвозвратно удаляет все файлы из корзины.
+func (s *Server) handleEmptyTrash() http.HandlerFunc {
+	type response struct {
+		Status string `json:"status"`
+		Purged int    `json:"purged"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		n, err := s.filestore.EmptyTrash(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, &response{Status: "ok", Purged: n})
+	}
+}
+
+// runTrashJanitor периодически удаляет из корзины файлы старше retention из базы и хранилища.
+func (s *Server) runTrashJanitor(retention time.Duration) {
+	ticker := time.NewTicker(trashJanitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.filestore.PurgeTrash(context.Background(), retention)
+		if err != nil {
+			s.logger.Error("trash janitor", zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			s.logger.Info("trash janitor", zap.Int("purged files", n))
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	// tusContentType — тип тела PATCH, которого требует протокол.
+	tusContentType = "application/offset+octet-stream"
+)
+
+var (
+	errTusVersion      = errors.New("unsupported tus version, server supports " + tusVersion)
+	errTusUploadLength = errors.New("Upload-Length must be a non-negative number")
+	errTusUploadOffset = errors.New("Upload-Offset must be a non-negative number")
+	errTusDeferLength  = errors.New("Upload-Defer-Length is not supported")
+	errTusMetadata     = errors.New("Upload-Metadata must be comma-separated unique keys with base64 values")
+	errTusContentType  = errors.New("Content-Type must be " + tusContentType)
+	errCustomerKeyTus  = errors.New("customer keys are not supported for tus uploads")
+)
+
+// tusResumable проверяет версию протокола, которую прислал клиент. Каждый ответ tus,
+// кроме OPTIONS, обязан нести Tus-Resumable.
+func (s *Server) tusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			s.error(w, r, http.StatusPreconditionFailed, errTusVersion)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleTusOptions сообщает клиенту версию протокола и поддерживаемые расширения.
+func (s *Server) handleTusOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleTusCreate начинает загрузку по протоколу tus (расширение creation). Размер файла
+// задается Upload-Length, имя, бакет и тип содержимого — ключами filename, bucket и filetype
+// заголовка Upload-Metadata; остальные ключи становятся пользовательскими метаданными.
+// Имя, бакет и квота проверяются сразу, а при завершении загрузки — еще раз, как при
+// обычной загрузке. Location относителен, чтобы одинаково работать и через gateway.
+func (s *Server) handleTusCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if r.Header.Get("Upload-Defer-Length") != "" {
+			s.error(w, r, http.StatusBadRequest, errTusDeferLength)
+			return
+		}
+		length, err := tusNumber(r.Header.Get("Upload-Length"), errTusUploadLength)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if hasCustomerKey(r.Header) {
+			s.error(w, r, http.StatusBadRequest, errCustomerKeyTus)
+			return
+		}
+		bucket, filename, meta, err := tusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.checkUploadTarget(r.Context(), userID, bucket, filename); err != nil {
+			s.uploadError(w, r, err)
+			return
+		}
+
+		upload, err := s.filestore.CreateTusUpload(r.Context(), userID, bucket, filename, length, meta)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", path.Base(r.URL.Path)+"/"+upload.ID)
+
+		// Пустому файлу нечего дописывать: он сохраняется сразу.
+		if length == 0 {
+			if err := s.finishTusUpload(r.Context(), userID, upload.ID); err != nil {
+				s.uploadError(w, r, err)
+				return
+			}
+		} else {
+			setUploadExpires(w.Header(), upload)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// handleTusHead возвращает, сколько байт загрузки уже получено, чтобы клиент продолжил с этого места.
+func (s *Server) handleTusHead() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		upload, err := s.filestore.FindTusUpload(r.Context(), userID, mux.Vars(r)["id"])
+		if err != nil {
+			s.tusError(w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		setUploadExpires(w.Header(), upload)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleTusPatch дописывает тело запроса к загрузке с Upload-Offset. Получив последний байт,
+// загрузка сохраняется в файл; если это не удалось (например, имя уже занято), повторный
+// PATCH без тела с Upload-Offset, равным размеру файла, пробует снова.
+func (s *Server) handleTusPatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		id := mux.Vars(r)["id"]
+
+		if r.Header.Get("Content-Type") != tusContentType {
+			s.error(w, r, http.StatusUnsupportedMediaType, errTusContentType)
+			return
+		}
+		offset, err := tusNumber(r.Header.Get("Upload-Offset"), errTusUploadOffset)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		upload, err := s.filestore.WriteTusUpload(r.Context(), userID, id, offset, r.Body)
+		if upload == nil {
+			s.tusError(w, r, err)
+			return
+		}
+		if err != nil {
+			// Клиент оборвал запрос: полученное сохранено, и загрузка продолжится с нового смещения.
+			s.logger.Info("tus patch interrupted", zap.String("upload_id", id), zap.Int64("offset", upload.Offset), zap.Error(err))
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		if upload.Offset < upload.Length {
+			setUploadExpires(w.Header(), upload)
+		} else if err := s.finishTusUpload(r.Context(), userID, id); err != nil {
+			s.uploadError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleTusDelete отменяет загрузку (расширение termination).
+func (s *Server) handleTusDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteTusUpload(r.Context(), userID, mux.Vars(r)["id"]); err != nil {
+			s.tusError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// finishTusUpload сохраняет завершенную загрузку в файл теми же проверками, что и обычная
+// загрузка, и удаляет ее. Содержимое уже получено целиком, поэтому файл дописывается,
+// даже если клиент отключился, не дождавшись ответа.
+func (s *Server) finishTusUpload(ctx context.Context, userID int, id string) error {
+	ctx = context.WithoutCancel(ctx)
+	upload, body, err := s.filestore.AssembleTusUpload(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	defer func(body io.Closer) {
+		_ = body.Close()
+	}(body)
+
+	if _, err := s.storeUpload(ctx, userID, upload.Bucket, upload.Filename, body, upload.Metadata); err != nil {
+		return err
+	}
+	if err := s.filestore.DeleteTusUpload(ctx, userID, id); err != nil {
+		s.logger.Error("error", zap.Error(err))
+	}
+	return nil
+}
+
+// checkUploadTarget заранее проверяет, что в filename можно загрузить файл: без
+// версионирования имя не должно быть занято.
+func (s *Server) checkUploadTarget(ctx context.Context, userID int, bucket, filename string) error {
+	if filename == "" {
+		return errEmptyFile
+	}
+	if !validFilename(filename) {
+		return errInvalidFilename
+	}
+
+	versioned, err := s.filestore.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return errDataBaseError
+	}
+	if versioned {
+		return nil
+	}
+	_, err = s.filestore.Head(ctx, userID, bucket, filename)
+	switch {
+	case err == nil:
+		return errFileAlreadyExist
+	case errors.Is(err, filestore.ErrObjectNotFound):
+		return nil
+	}
+	return err
+}
+
+// tusError переводит ошибки загрузок tus в HTTP-статусы.
+func (s *Server) tusError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, filestore.ErrUploadExpired):
+		s.error(w, r, http.StatusGone, err)
+	case errors.Is(err, filestore.ErrUploadOffsetMismatch):
+		s.error(w, r, http.StatusConflict, err)
+	case errors.Is(err, filestore.ErrUploadLengthExceeded):
+		s.error(w, r, http.StatusRequestEntityTooLarge, err)
+	default:
+		s.storeError(w, r, err)
+	}
+}
+
+// setUploadExpires сообщает клиенту, до какого времени загрузку можно продолжить (расширение expiration).
+func setUploadExpires(h http.Header, upload *filestore.TusUpload) {
+	if !upload.ExpiresAt.IsZero() {
+		h.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// tusNumber читает обязательное неотрицательное число из заголовка.
+func tusNumber(v string, errInvalid error) (int64, error) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errInvalid
+	}
+	return n, nil
+}
+
+// tusMetadata разбирает Upload-Metadata: пары "ключ значение-в-base64" через запятую,
+// значение может отсутствовать.
+func tusMetadata(header string) (bucket, filename string, meta filestore.Metadata, err error) {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 && strings.TrimSpace(header) == "" {
+			break
+		}
+		if len(fields) == 0 || len(fields) > 2 {
+			return "", "", meta, errTusMetadata
+		}
+		key := fields[0]
+		if _, ok := values[key]; ok {
+			return "", "", meta, errTusMetadata
+		}
+		value := ""
+		if len(fields) == 2 {
+			b, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return "", "", meta, errTusMetadata
+			}
+			value = string(b)
+		}
+		values[key] = value
+	}
+
+	filename, bucket = values["filename"], values["bucket"]
+	if filename == "" {
+		filename = values["name"]
+	}
+	meta.ContentType = values["filetype"]
+	if meta.ContentType == "" {
+		meta.ContentType = values["content_type"]
+	}
+	if meta.ContentType == "application/octet-stream" {
+		meta.ContentType = ""
+	}
+	for key, value := range values {
+		switch key {
+		case "filename", "name", "bucket", "filetype", "content_type":
+			continue
+		}
+		if meta.User == nil {
+			meta.User = make(map[string]string)
+		}
+		meta.User[strings.ToLower(key)] = value
+	}
+	return bucket, filename, meta, nil
+}
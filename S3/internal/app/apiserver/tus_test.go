@@ -0,0 +1,177 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestTusMetadata(t *testing.T) {
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name        string
+		header      string
+		wantBucket  string
+		wantFile    string
+		wantType    string
+		wantUser    map[string]string
+		wantInvalid bool
+	}{
+		{name: "empty"},
+		{
+			name:       "file in a bucket",
+			header:     "filename " + b64("photos/cat.jpg") + ",bucket " + b64("media") + ",filetype " + b64("image/jpeg"),
+			wantBucket: "media",
+			wantFile:   "photos/cat.jpg",
+			wantType:   "image/jpeg",
+		},
+		{
+			name:     "uppy names",
+			header:   "name " + b64("a.txt") + ", content_type " + b64("text/plain"),
+			wantFile: "a.txt",
+			wantType: "text/plain",
+		},
+		{
+			name:     "generic type is detected later",
+			header:   "filename " + b64("a.bin") + ",filetype " + b64("application/octet-stream"),
+			wantFile: "a.bin",
+		},
+		{
+			name:     "user metadata and empty values",
+			header:   "filename " + b64("a.txt") + ",Author " + b64("Ann") + ",draft",
+			wantFile: "a.txt",
+			wantUser: map[string]string{"author": "Ann", "draft": ""},
+		},
+		{name: "duplicate key", header: "filename " + b64("a") + ",filename " + b64("b"), wantInvalid: true},
+		{name: "not base64", header: "filename a.txt!", wantInvalid: true},
+		{name: "empty pair", header: "filename " + b64("a") + ",,bucket " + b64("b"), wantInvalid: true},
+		{name: "three fields", header: "filename " + b64("a") + " " + b64("b"), wantInvalid: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket, filename, meta, err := tusMetadata(tc.header)
+			if tc.wantInvalid {
+				if !errors.Is(err, errTusMetadata) {
+					t.Errorf("tusMetadata() error = %v, want errTusMetadata", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bucket != tc.wantBucket || filename != tc.wantFile || meta.ContentType != tc.wantType {
+				t.Errorf("tusMetadata() = %q, %q, %q, want %q, %q, %q",
+					bucket, filename, meta.ContentType, tc.wantBucket, tc.wantFile, tc.wantType)
+			}
+			if !maps.Equal(meta.User, tc.wantUser) {
+				t.Errorf("user metadata = %v, want %v", meta.User, tc.wantUser)
+			}
+		})
+	}
+}
+
+func TestTusNumber(t *testing.T) {
+	for v, want := range map[string]int64{"0": 0, "1048576": 1048576} {
+		if got, err := tusNumber(v, errTusUploadOffset); err != nil || got != want {
+			t.Errorf("tusNumber(%q) = %d, %v, want %d", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"", "-1", "1.5", "10 ", "0x10", "99999999999999999999"} {
+		if _, err := tusNumber(v, errTusUploadOffset); !errors.Is(err, errTusUploadOffset) {
+			t.Errorf("tusNumber(%q) error = %v, want errTusUploadOffset", v, err)
+		}
+	}
+}
+
+func TestTusError(t *testing.T) {
+	s := &Server{logger: zap.NewNop()}
+	for err, want := range map[error]int{
+		filestore.ErrUploadExpired:        http.StatusGone,
+		filestore.ErrUploadOffsetMismatch: http.StatusConflict,
+		filestore.ErrUploadLengthExceeded: http.StatusRequestEntityTooLarge,
+		filestore.ErrUploadNotFound:       http.StatusNotFound,
+		filestore.ErrQuotaExceeded:        http.StatusRequestEntityTooLarge,
+	} {
+		w := httptest.NewRecorder()
+		s.tusError(w, httptest.NewRequest(http.MethodPatch, "/tus/1", nil), err)
+		if w.Code != want {
+			t.Errorf("%v: status %d, want %d", err, w.Code, want)
+		}
+	}
+}
+
+func TestTusResumable(t *testing.T) {
+	s := &Server{logger: zap.NewNop()}
+	h := s.tusResumable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		version string
+		want    int
+	}{
+		{"supported version", http.MethodHead, tusVersion, http.StatusNoContent},
+		{"missing version", http.MethodPatch, "", http.StatusPreconditionFailed},
+		{"other version", http.MethodPost, "0.2.2", http.StatusPreconditionFailed},
+		{"options without version", http.MethodOptions, "", http.StatusNoContent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/tus", nil)
+			if tc.version != "" {
+				r.Header.Set("Tus-Resumable", tc.version)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d", w.Code, tc.want)
+			}
+			if got := w.Header().Get("Tus-Resumable"); got != tusVersion {
+				t.Errorf("Tus-Resumable = %q, want %q", got, tusVersion)
+			}
+		})
+	}
+}
+
+func TestTusPatchRejects(t *testing.T) {
+	s := &Server{logger: zap.NewNop()}
+
+	tests := []struct {
+		name        string
+		contentType string
+		offset      string
+		want        int
+	}{
+		{"wrong content type", "application/octet-stream", "0", http.StatusUnsupportedMediaType},
+		{"missing offset", tusContentType, "", http.StatusBadRequest},
+		{"negative offset", tusContentType, "-5", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/tus/1", strings.NewReader("data"))
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyUserId, 1))
+			r.Header.Set("Content-Type", tc.contentType)
+			if tc.offset != "" {
+				r.Header.Set("Upload-Offset", tc.offset)
+			}
+			w := httptest.NewRecorder()
+			s.handleTusPatch()(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
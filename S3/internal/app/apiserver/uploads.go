@@ -0,0 +1,200 @@
+package apiserver
+
+import (
+	"S3_project/S3/internal/app/store/filestore"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// uploadJanitorInterval — как часто удаляются брошенные составные загрузки.
+const uploadJanitorInterval = time.Hour
+
+var errInvalidPartNumber = errors.New("part number must be between 1 and 10000")
+
+// handleCreateUpload начинает составную загрузку: части файла затем загружаются
+// отдельными запросами и собираются в файл в handleCompleteUpload. Тип содержимого
+// и пользовательские метаданные задаются здесь и применяются к собранному файлу.
+func (s *Server) handleCreateUpload() http.HandlerFunc {
+	type request struct {
+		Bucket      string            `json:"bucket"`
+		Filename    string            `json:"filename"`
+		ContentType string            `json:"content_type"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if !validFilename(req.Filename) {
+			s.error(w, r, http.StatusBadRequest, errInvalidFilename)
+			return
+		}
+		if hasCustomerKey(r.Header) {
+			s.error(w, r, http.StatusBadRequest, errCustomerKeyMultipart)
+			return
+		}
+
+		upload, err := s.filestore.CreateUpload(r.Context(), userID, req.Bucket, req.Filename, filestore.Metadata{
+			ContentType: req.ContentType,
+			User:        req.Metadata,
+		})
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusCreated, upload)
+	}
+}
+
+func (s *Server) handleUploads() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		uploads, err := s.filestore.ListUploads(r.Context(), userID, r.URL.Query().Get("bucket"))
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		if uploads == nil {
+			uploads = []filestore.Upload{}
+		}
+		s.respond(w, r, http.StatusOK, uploads)
+	}
+}
+
+// handleUploadInfo возвращает сессию загрузки и уже загруженные части,
+// чтобы клиент мог докачать недостающие после обрыва.
+func (s *Server) handleUploadInfo() http.HandlerFunc {
+	type response struct {
+		*filestore.Upload
+		Parts []filestore.Part `json:"parts"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		uploadID := mux.Vars(r)["upload_id"]
+
+		upload, err := s.filestore.FindUpload(r.Context(), userID, uploadID)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		parts, err := s.filestore.ListParts(r.Context(), userID, uploadID)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		if parts == nil {
+			parts = []filestore.Part{}
+		}
+		s.respond(w, r, http.StatusOK, &response{Upload: upload, Parts: parts})
+	}
+}
+
+// handleUploadPart принимает тело запроса как часть с номером из пути.
+func (s *Server) handleUploadPart() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		vars := mux.Vars(r)
+
+		partNumber, err := strconv.Atoi(vars["part_number"])
+		if err != nil || partNumber < 1 || partNumber > filestore.MaxPartNumber {
+			s.error(w, r, http.StatusBadRequest, errInvalidPartNumber)
+			return
+		}
+
+		part, err := s.filestore.UploadPart(r.Context(), userID, vars["upload_id"], partNumber, r.Body)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, part)
+	}
+}
+
+// handleCompleteUpload собирает части в файл. Без списка parts берутся все загруженные части.
+func (s *Server) handleCompleteUpload() http.HandlerFunc {
+	type request struct {
+		Parts []filestore.Part `json:"parts"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		uploadID := mux.Vars(r)["upload_id"]
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		upload, body, err := s.filestore.AssembleUpload(r.Context(), userID, uploadID, req.Parts)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		defer func(body io.Closer) {
+			_ = body.Close()
+		}(body)
+
+		versionID, err := s.storeUpload(r.Context(), userID, upload.Bucket, upload.Filename, body, upload.Metadata)
+		if err != nil {
+			s.uploadError(w, r, err)
+			return
+		}
+
+		if err := s.filestore.DeleteUpload(r.Context(), userID, uploadID); err != nil {
+			s.logger.Error("error", zap.Error(err))
+		}
+
+		resp := map[string]string{"status": "ok"}
+		if versionID != "" {
+			resp["version_id"] = versionID
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *Server) handleAbortUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		if err := s.filestore.DeleteUpload(r.Context(), userID, mux.Vars(r)["upload_id"]); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// runUploadJanitor периодически удаляет составные загрузки старше ttl вместе с частями.
+func (s *Server) runUploadJanitor(ttl time.Duration) {
+	ticker := time.NewTicker(uploadJanitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.filestore.DeleteStaleUploads(context.Background(), ttl)
+		if err != nil {
+			s.logger.Error("upload janitor", zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			s.logger.Info("upload janitor", zap.Int("aborted uploads", n))
+		}
+	}
+}
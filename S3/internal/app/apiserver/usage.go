@@ -0,0 +1,17 @@
+package apiserver
+
+import "net/http"
+
+// handleUsage возвращает занятое пользователем место, его квоту и остаток.
+func (s *Server) handleUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		usage, err := s.filestore.Usage(r.Context(), userID)
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		s.respond(w, r, http.StatusOK, usage)
+	}
+}
@@ -0,0 +1,144 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var errEmptyVersionID = errors.New("version_id is empty")
+
+func (s *Server) handleVersioning() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		enabled, err := s.filestore.VersioningEnabled(r.Context(), userID, r.URL.Query().Get("bucket"))
+		if err != nil {
+			s.error(w, r, http.StatusInternalServerError, errDataBaseError)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]bool{"enabled": enabled})
+	}
+}
+
+// handleSetVersioning включает или приостанавливает версионирование бакета
+// ("" — корневое пространство).
+func (s *Server) handleSetVersioning() http.HandlerFunc {
+	type request struct {
+		Bucket  string `json:"bucket"`
+		Enabled bool   `json:"enabled"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.filestore.SetVersioning(r.Context(), userID, req.Bucket, req.Enabled); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+func (s *Server) handleVersions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+		query := r.URL.Query()
+
+		filename := query.Get("filename")
+		if filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+
+		versions, err := s.filestore.ListVersions(r.Context(), userID, query.Get("bucket"), filename)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		if len(versions) == 0 {
+			s.error(w, r, http.StatusNotFound, errFileNotFound)
+			return
+		}
+		s.respond(w, r, http.StatusOK, versions)
+	}
+}
+
+// handleDeleteVersion безвозвратно удаляет одну версию файла.
+func (s *Server) handleDeleteVersion() http.HandlerFunc {
+	type request struct {
+		Bucket    string `json:"bucket"`
+		Filename  string `json:"filename"`
+		VersionID string `json:"version_id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if req.VersionID == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyVersionID)
+			return
+		}
+
+		if err := s.filestore.DeleteVersion(r.Context(), userID, req.Bucket, req.Filename, req.VersionID); err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handleRestore делает старую версию файла текущей. Восстановление создает новую версию
+// с тем же содержимым, поэтому история не теряется.
+func (s *Server) handleRestore() http.HandlerFunc {
+	type request struct {
+		Bucket    string `json:"bucket"`
+		Filename  string `json:"filename"`
+		VersionID string `json:"version_id"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(ctxKeyUserId).(int)
+
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if req.Filename == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyFile)
+			return
+		}
+		if req.VersionID == "" {
+			s.error(w, r, http.StatusBadRequest, errEmptyVersionID)
+			return
+		}
+
+		key, err := customerKeyFromHeader(r.Header)
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		versionID, err := s.filestore.RestoreVersion(r.Context(), userID, req.Bucket, req.Filename, req.VersionID, key)
+		if err != nil {
+			s.storeError(w, r, err)
+			return
+		}
+		s.respond(w, r, http.StatusOK, map[string]string{"status": "ok", "version_id": versionID})
+	}
+}
@@ -0,0 +1,171 @@
+package imaging
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache хранит готовые изображения в каталоге на диске и удаляет давно не запрошенные,
+// когда их суммарный размер превышает предел. Порядок запросов переживает перезапуск
+// через время изменения файлов.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // *cacheEntry, от давно запрошенных к недавним
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+// OpenCache открывает кэш в каталоге dir, создавая его, и сразу приводит к пределу maxBytes.
+func OpenCache(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type stored struct {
+		cacheEntry
+		modTime time.Time
+	}
+	var existing []stored
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		// Недописанные файлы остались от прерванной записи.
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			_ = os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, stored{cacheEntry{file.Name(), info.Size()}, info.ModTime()})
+	}
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].modTime.Before(existing[j].modTime)
+	})
+
+	c := &Cache{dir: dir, maxBytes: maxBytes, lru: list.New(), entries: make(map[string]*list.Element)}
+	for _, e := range existing {
+		entry := e.cacheEntry
+		c.entries[entry.name] = c.lru.PushBack(&entry)
+		c.size += entry.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Key — имя записи кэша для изображения source, преобразованного по o.
+func Key(source string, o Options) string {
+	sum := sha256.Sum256([]byte(source + "|" + o.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get возвращает изображение по ключу или false, если его нет.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToBack(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.forget(key)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Put сохраняет изображение под ключом. Изображение больше всего кэша не сохраняется.
+func (c *Cache) Put(key string, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, key)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushBack(&cacheEntry{key, size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+// evict удаляет давно не запрошенные изображения, пока кэш больше предела. Вызывается под c.mu.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		el := c.lru.Front()
+		if el == nil {
+			return
+		}
+		entry := el.Value.(*cacheEntry)
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		c.lru.Remove(el)
+		delete(c.entries, entry.name)
+		c.size -= entry.size
+	}
+}
+
+func (c *Cache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,181 @@
+package imaging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, c, "a", 4)
+	put(t, c, "b", 4)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+	// Теперь давно не запрошенный — b.
+	put(t, c, "c", 4)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b")); !os.IsNotExist(err) {
+		t.Errorf("evicted file is still on disk: %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+}
+
+func TestCachePut(t *testing.T) {
+	c, err := OpenCache(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, c, "a", 4)
+	put(t, c, "a", 6)
+	data, ok := c.Get("a")
+	if !ok || len(data) != 6 {
+		t.Fatalf("Get(a) = %d bytes, %v; want the replaced 6 bytes", len(data), ok)
+	}
+	if c.size != 6 {
+		t.Errorf("size after replace = %d, want 6", c.size)
+	}
+
+	// Больше всего кэша — не сохраняется и ничего не вытесняет.
+	put(t, c, "huge", 11)
+	if _, ok := c.Get("huge"); ok {
+		t.Error("image larger than the cache was stored")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a was evicted by an image that was not stored")
+	}
+}
+
+func TestCacheForgetsRemovedFiles(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, c, "a", 4)
+	if err := os.Remove(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("Get returned a removed file")
+	}
+	if c.size != 0 || c.lru.Len() != 0 {
+		t.Errorf("removed file is still accounted: size %d, %d entries", c.size, c.lru.Len())
+	}
+}
+
+func TestOpenCacheRecoversOrder(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for key, age := range map[string]time.Duration{"newest": time.Minute, "middle": time.Hour, "oldest": 2 * time.Hour} {
+		path := filepath.Join(dir, key)
+		if err := os.WriteFile(path, bytes.Repeat([]byte{'x'}, 4), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Недописанный файл прерванной записи.
+	if err := os.WriteFile(filepath.Join(dir, "123.tmp"), []byte("partial"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// Предел меньше сохраненного: при открытии уходит самый старый файл.
+	c, err := OpenCache(dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "oldest")); !os.IsNotExist(err) {
+		t.Errorf("oldest file was not evicted on open: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "123.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed: %v", err)
+	}
+	if c.size != 8 {
+		t.Errorf("size = %d, want 8", c.size)
+	}
+
+	// Следующим вытесняется middle: порядок восстановлен по времени изменения.
+	put(t, c, "new", 4)
+	if _, ok := c.Get("middle"); ok {
+		t.Error("middle was not evicted")
+	}
+	if _, ok := c.Get("newest"); !ok {
+		t.Error("newest was evicted before middle")
+	}
+}
+
+func TestOpenCacheKeepsAccessOrderAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	c, err := OpenCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, c, "a", 4)
+	put(t, c, "b", 4)
+	// Время изменения файла — время последнего запроса; разнесем их явно, чтобы не зависеть
+	// от точности часов файловой системы.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "b"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+
+	c, err = OpenCache(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("b should be evicted: a was requested later")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a was evicted")
+	}
+}
+
+func TestKey(t *testing.T) {
+	o := defaultOptions(t)
+	if Key("source", o) != Key("source", o) {
+		t.Error("Key is not deterministic")
+	}
+	if Key("source", o) == Key("other", o) {
+		t.Error("different sources have the same key")
+	}
+	o.Quality = 50
+	if Key("source", o) == Key("source", defaultOptions(t)) {
+		t.Error("different options have the same key")
+	}
+}
+
+func put(t *testing.T, c *Cache, key string, size int) {
+	t.Helper()
+	if err := c.Put(key, bytes.Repeat([]byte{'x'}, size)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+const (
+	// ThumbnailSize — сторона квадрата, в который вписываются миниатюры по умолчанию.
+	ThumbnailSize = 256
+	// MaxSize — наибольшая ширина и высота результата.
+	MaxSize = 4096
+	// MaxPixels — наибольшее число пикселей исходного изображения: больше не декодируется,
+	// чтобы маленький файл не занял гигабайты памяти.
+	MaxPixels = 64 << 20
+
+	defaultQuality = 85
+)
+
+var (
+	ErrUnsupportedImage = errors.New("file is not a JPEG, PNG, GIF or WebP image")
+	ErrImageTooLarge    = errors.New("image has too many pixels")
+	ErrInvalidOptions   = errors.New("invalid image transformation")
+)
+
+// contentTypes — типы содержимого, которые умеет читать Transform.
+var contentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Supported сообщает, можно ли построить миниатюру файла с типом содержимого contentType.
+func Supported(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && contentTypes[mediaType]
+}
+
+// Options — преобразование изображения: сначала вырезается Crop (в пикселях исходного
+// изображения, пустой — все изображение), затем результат приводится к Width x Height
+// по правилу Fit и кодируется в Format.
+type Options struct {
+	// Width и Height — размеры результата; если задан только один, второй следует
+	// из пропорций, если ни один — изображение вписывается в ThumbnailSize x ThumbnailSize.
+	Width  int
+	Height int
+	// Fit — contain (по умолчанию) вписывает изображение в размеры, cover заполняет их
+	// целиком, обрезая лишнее по краям, fill растягивает без сохранения пропорций.
+	// contain и cover не увеличивают изображение.
+	Fit  string
+	Crop image.Rectangle
+	// Format — jpeg, png или gif; "" — формат исходного изображения, а для WebP — png.
+	Format string
+	// Quality — качество JPEG от 1 до 100.
+	Quality int
+}
+
+// Normalize проверяет параметры и подставляет значения по умолчанию.
+func (o Options) Normalize() (Options, error) {
+	if o.Width < 0 || o.Height < 0 || o.Width > MaxSize || o.Height > MaxSize {
+		return o, fmt.Errorf("%w: width and height must be between 1 and %d", ErrInvalidOptions, MaxSize)
+	}
+	if o.Width == 0 && o.Height == 0 {
+		o.Width, o.Height = ThumbnailSize, ThumbnailSize
+	}
+	switch o.Fit {
+	case "":
+		o.Fit = FitContain
+	case FitContain, FitCover, FitFill:
+	default:
+		return o, fmt.Errorf("%w: fit must be contain, cover or fill", ErrInvalidOptions)
+	}
+	if o.Fit != FitContain && (o.Width == 0 || o.Height == 0) {
+		return o, fmt.Errorf("%w: fit %s requires both width and height", ErrInvalidOptions, o.Fit)
+	}
+	if o.Crop != (image.Rectangle{}) && (o.Crop.Min.X < 0 || o.Crop.Min.Y < 0 || o.Crop.Empty()) {
+		return o, fmt.Errorf("%w: crop must be a non-empty rectangle inside the image", ErrInvalidOptions)
+	}
+	switch o.Format {
+	case "", FormatJPEG, FormatPNG, FormatGIF:
+	default:
+		return o, fmt.Errorf("%w: format must be jpeg, png or gif", ErrInvalidOptions)
+	}
+	if o.Quality == 0 {
+		o.Quality = defaultQuality
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return o, fmt.Errorf("%w: quality must be between 1 and 100", ErrInvalidOptions)
+	}
+	return o, nil
+}
+
+// String — каноническая запись нормализованных параметров, часть ключа кэша.
+func (o Options) String() string {
+	return fmt.Sprintf("w=%d,h=%d,fit=%s,crop=%d:%d:%d:%d,format=%s,q=%d",
+		o.Width, o.Height, o.Fit, o.Crop.Min.X, o.Crop.Min.Y, o.Crop.Dx(), o.Crop.Dy(), o.Format, o.Quality)
+}
+
+// Transform декодирует изображение из r, преобразует его по нормализованным параметрам o
+// и возвращает результат с типом содержимого. У анимированных GIF берется первый кадр.
+func Transform(r io.ReadSeeker, o Options) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, "", ErrImageTooLarge
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+
+	bounds := src.Bounds()
+	if o.Crop != (image.Rectangle{}) {
+		bounds = o.Crop.Add(src.Bounds().Min).Intersect(src.Bounds())
+		if bounds.Empty() {
+			return nil, "", fmt.Errorf("%w: crop is outside the image", ErrInvalidOptions)
+		}
+	}
+
+	if o.Format == "" {
+		o.Format = format
+		if format != FormatJPEG && format != FormatGIF {
+			o.Format = FormatPNG
+		}
+	}
+
+	size, from := layout(bounds, o)
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	op := draw.Src
+	if o.Format == FormatJPEG {
+		// В JPEG нет прозрачности: прозрачные области становятся белыми, а не черными.
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		op = draw.Over
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, from, op, nil)
+
+	var buf bytes.Buffer
+	switch o.Format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: o.Quality})
+	case FormatGIF:
+		err = gif.Encode(&buf, dst, &gif.Options{NumColors: 256, Drawer: draw.FloydSteinberg})
+	default:
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/" + o.Format, nil
+}
+
+// layout возвращает размер результата и часть src, которая в него масштабируется.
+func layout(src image.Rectangle, o Options) (image.Point, image.Rectangle) {
+	w, h := src.Dx(), src.Dy()
+	switch o.Fit {
+	case FitFill:
+		return image.Pt(o.Width, o.Height), src
+	case FitCover:
+		// Из src вырезается середина с пропорциями результата.
+		cw, ch := w, w*o.Height/o.Width
+		if ch > h {
+			cw, ch = h*o.Width/o.Height, h
+		}
+		cw, ch = max(cw, 1), max(ch, 1)
+		from := image.Rect(0, 0, cw, ch).Add(src.Min).Add(image.Pt((w-cw)/2, (h-ch)/2))
+		if cw <= o.Width {
+			return image.Pt(cw, ch), from
+		}
+		return image.Pt(o.Width, o.Height), from
+	}
+
+	// contain: коэффициент — наименьший из заданных сторон, но не больше 1.
+	scale := 1.0
+	if o.Width > 0 {
+		scale = min(scale, float64(o.Width)/float64(w))
+	}
+	if o.Height > 0 {
+		scale = min(scale, float64(o.Height)/float64(h))
+	}
+	return image.Pt(max(int(float64(w)*scale+0.5), 1), max(int(float64(h)*scale+0.5), 1)), src
+}
@@ -0,0 +1,284 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	o, err := Options{}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Options{Width: ThumbnailSize, Height: ThumbnailSize, Fit: FitContain, Quality: defaultQuality}
+	if o != want {
+		t.Errorf("Normalize() = %+v, want %+v", o, want)
+	}
+
+	invalid := []struct {
+		name string
+		opts Options
+	}{
+		{"negative width", Options{Width: -1}},
+		{"too high", Options{Height: MaxSize + 1}},
+		{"unknown fit", Options{Fit: "stretch"}},
+		{"cover without height", Options{Width: 100, Fit: FitCover}},
+		{"fill without width", Options{Height: 100, Fit: FitFill}},
+		{"empty crop", Options{Crop: image.Rect(10, 10, 10, 20)}},
+		{"negative crop", Options{Crop: image.Rect(-1, 0, 10, 10)}},
+		{"unknown format", Options{Format: "bmp"}},
+		{"quality", Options{Quality: 101}},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.opts.Normalize(); !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Normalize() error = %v, want ErrInvalidOptions", err)
+			}
+		})
+	}
+}
+
+func TestOptionsString(t *testing.T) {
+	a, _ := Options{Width: 100}.Normalize()
+	b, _ := Options{Width: 100, Fit: FitContain, Quality: defaultQuality}.Normalize()
+	if a.String() != b.String() {
+		t.Errorf("equal options have different keys: %q and %q", a, b)
+	}
+	c, _ := Options{Width: 100, Format: FormatPNG}.Normalize()
+	if a.String() == c.String() {
+		t.Errorf("different options have the same key %q", a)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      image.Rectangle
+		opts     Options
+		wantSize image.Point
+		wantFrom image.Rectangle
+	}{
+		{
+			name:     "contain landscape",
+			src:      image.Rect(0, 0, 400, 200),
+			opts:     Options{Width: 256, Height: 256, Fit: FitContain},
+			wantSize: image.Pt(256, 128),
+			wantFrom: image.Rect(0, 0, 400, 200),
+		},
+		{
+			name:     "contain portrait",
+			src:      image.Rect(0, 0, 300, 900),
+			opts:     Options{Width: 256, Height: 256, Fit: FitContain},
+			wantSize: image.Pt(85, 256),
+			wantFrom: image.Rect(0, 0, 300, 900),
+		},
+		{
+			name:     "contain does not upscale",
+			src:      image.Rect(0, 0, 100, 50),
+			opts:     Options{Width: 256, Height: 256, Fit: FitContain},
+			wantSize: image.Pt(100, 50),
+			wantFrom: image.Rect(0, 0, 100, 50),
+		},
+		{
+			name:     "contain width only",
+			src:      image.Rect(0, 0, 400, 200),
+			opts:     Options{Width: 50, Fit: FitContain},
+			wantSize: image.Pt(50, 25),
+			wantFrom: image.Rect(0, 0, 400, 200),
+		},
+		{
+			name:     "contain keeps one pixel",
+			src:      image.Rect(0, 0, 4000, 1),
+			opts:     Options{Width: 100, Height: 100, Fit: FitContain},
+			wantSize: image.Pt(100, 1),
+			wantFrom: image.Rect(0, 0, 4000, 1),
+		},
+		{
+			name:     "cover crops the middle",
+			src:      image.Rect(0, 0, 400, 200),
+			opts:     Options{Width: 100, Height: 100, Fit: FitCover},
+			wantSize: image.Pt(100, 100),
+			wantFrom: image.Rect(100, 0, 300, 200),
+		},
+		{
+			name:     "cover of a tall image",
+			src:      image.Rect(0, 0, 200, 600),
+			opts:     Options{Width: 100, Height: 50, Fit: FitCover},
+			wantSize: image.Pt(100, 50),
+			wantFrom: image.Rect(0, 250, 200, 350),
+		},
+		{
+			name:     "cover with offset bounds",
+			src:      image.Rect(10, 20, 410, 220),
+			opts:     Options{Width: 100, Height: 100, Fit: FitCover},
+			wantSize: image.Pt(100, 100),
+			wantFrom: image.Rect(110, 20, 310, 220),
+		},
+		{
+			name:     "cover does not upscale",
+			src:      image.Rect(0, 0, 50, 40),
+			opts:     Options{Width: 100, Height: 100, Fit: FitCover},
+			wantSize: image.Pt(40, 40),
+			wantFrom: image.Rect(5, 0, 45, 40),
+		},
+		{
+			name:     "fill",
+			src:      image.Rect(0, 0, 400, 200),
+			opts:     Options{Width: 30, Height: 70, Fit: FitFill},
+			wantSize: image.Pt(30, 70),
+			wantFrom: image.Rect(0, 0, 400, 200),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			size, from := layout(tc.src, tc.opts)
+			if size != tc.wantSize || from != tc.wantFrom {
+				t.Errorf("layout() = %v, %v, want %v, %v", size, from, tc.wantSize, tc.wantFrom)
+			}
+		})
+	}
+}
+
+func TestTransform(t *testing.T) {
+	src := encodePNG(t, 400, 200)
+
+	tests := []struct {
+		name     string
+		opts     Options
+		wantType string
+		wantSize image.Point
+	}{
+		{"default thumbnail", Options{}, "image/png", image.Pt(256, 128)},
+		{"to jpeg", Options{Width: 40, Format: FormatJPEG}, "image/jpeg", image.Pt(40, 20)},
+		{"to gif", Options{Width: 40, Height: 40, Fit: FitFill, Format: FormatGIF}, "image/gif", image.Pt(40, 40)},
+		{"crop", Options{Crop: image.Rect(50, 50, 150, 100)}, "image/png", image.Pt(100, 50)},
+		{"crop clipped to the image", Options{Crop: image.Rect(300, 100, 500, 300)}, "image/png", image.Pt(100, 100)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := tc.opts.Normalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, contentType, err := Transform(bytes.NewReader(src), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tc.wantType {
+				t.Errorf("content type = %q, want %q", contentType, tc.wantType)
+			}
+			config, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if "image/"+format != tc.wantType {
+				t.Errorf("encoded as %s, want %s", format, tc.wantType)
+			}
+			if got := image.Pt(config.Width, config.Height); got != tc.wantSize {
+				t.Errorf("size = %v, want %v", got, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestTransformKeepsGIF(t *testing.T) {
+	var buf bytes.Buffer
+	img := image.NewPaletted(image.Rect(0, 0, 20, 10), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, contentType, err := Transform(bytes.NewReader(buf.Bytes()), defaultOptions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "image/gif" {
+		t.Errorf("content type = %q, want image/gif", contentType)
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		opts Options
+		want error
+	}{
+		{"not an image", []byte("plain text, not an image"), Options{}, ErrUnsupportedImage},
+		{"too many pixels", withPNGSize(t, encodePNG(t, 1, 1), 10000, 10000), Options{}, ErrImageTooLarge},
+		{"crop outside the image", encodePNG(t, 40, 40), Options{Crop: image.Rect(100, 100, 200, 200)}, ErrInvalidOptions},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := tc.opts.Normalize()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := Transform(bytes.NewReader(tc.data), opts); !errors.Is(err, tc.want) {
+				t.Errorf("Transform() error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"image/jpeg":               true,
+		"image/png":                true,
+		"image/webp":               true,
+		"image/gif; charset=x":     true,
+		"image/svg+xml":            false,
+		"text/plain":               false,
+		"":                         false,
+		"application/octet-stream": false,
+	} {
+		if got := Supported(contentType); got != want {
+			t.Errorf("Supported(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func defaultOptions(t *testing.T) Options {
+	t.Helper()
+	o, err := Options{}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// encodePNG возвращает PNG width x height с горизонтальным градиентом.
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withPNGSize переписывает размеры в заголовке PNG, не трогая само изображение.
+func withPNGSize(t *testing.T, data []byte, width, height uint32) []byte {
+	t.Helper()
+	data = bytes.Clone(data)
+	// Сигнатура (8 байт), длина и тип IHDR (8 байт), 13 байт данных и CRC типа и данных.
+	if string(data[12:16]) != "IHDR" {
+		t.Fatal("IHDR is not the first chunk")
+	}
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
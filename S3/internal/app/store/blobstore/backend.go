@@ -0,0 +1,66 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	BackendDisk   = "disk"
+	BackendMemory = "memory"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobBackend хранит содержимое файлов по ключу. Метаданные (владелец, имя,
+// публичность) живут в базе, backend отвечает только за байты.
+type BlobBackend interface {
+	// Put записывает содержимое r под ключом key, перезаписывая существующее,
+	// и возвращает число записанных байт.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get открывает содержимое для чтения. Закрыть его должен вызывающий.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Rename переносит содержимое с ключа src на dst, перезаписывая существующее.
+	Rename(ctx context.Context, src, dst string) error
+	Stat(ctx context.Context, key string) (Info, error)
+	// List возвращает все ключи, начинающиеся с prefix.
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
+
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// New создает backend по имени из конфигурации.
+func New(kind string, storePath string) (BlobBackend, error) {
+	switch kind {
+	case BackendDisk, "":
+		return NewDiskBackend(storePath), nil
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", kind)
+	}
+}
+
+// ctxReader прерывает копирование, как только контекст запроса отменен.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,143 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Деление на фрагменты по содержимому (FastCDC): граница ставится там, где отпечаток
+// последних байт удовлетворяет маске, поэтому вставка или удаление байт сдвигает только
+// соседние границы, а остальные фрагменты измененного файла совпадают с прежними.
+//
+// Отпечаток считается как fp = fp<<1 + gear[b] по байтам фрагмента, начиная с байта
+// с номером minSize. До среднего размера граница ставится, когда нулевые старшие
+// log2(avg)+2 бит fp, после — старшие log2(avg)-2 бит. gear[i] — первые 8 байт
+// SHA-256 от байта i (big endian). Фрагмент не короче avg/4 и не длиннее avg*4 байт,
+// кроме последнего. Клиент, делящий файл так же, получает те же фрагменты, что и сервер.
+
+const (
+	MinChunkSize = 4 << 10
+	MaxChunkSize = 64 << 20
+)
+
+var ErrInvalidChunkSize = errors.New("chunk size must be a power of two between 4 KiB and 64 MiB")
+
+var gear = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// ValidChunkSize сообщает, годится ли avg как средний размер фрагмента.
+func ValidChunkSize(avg int64) bool {
+	return avg >= MinChunkSize && avg <= MaxChunkSize && avg&(avg-1) == 0
+}
+
+// ChunkBounds возвращает наименьший и наибольший размер фрагмента при среднем avg.
+func ChunkBounds(avg int) (minSize, maxSize int) {
+	return avg / 4, avg * 4
+}
+
+// Chunker делит поток на фрагменты со средним размером avg.
+type Chunker struct {
+	r       io.Reader
+	buf     []byte
+	start   int
+	end     int
+	eof     bool
+	minSize int
+	avg     int
+	maxSize int
+	maskS   uint64
+	maskL   uint64
+}
+
+// NewChunker создает Chunker; avg должен удовлетворять ValidChunkSize.
+func NewChunker(r io.Reader, avg int) *Chunker {
+	minSize, maxSize := ChunkBounds(avg)
+	n := bits.TrailingZeros(uint(avg))
+	return &Chunker{
+		r:       r,
+		buf:     make([]byte, 2*maxSize),
+		minSize: minSize,
+		avg:     avg,
+		maxSize: maxSize,
+		maskS:   ^uint64(0) << (64 - (n + 2)),
+		maskL:   ^uint64(0) << (64 - (n - 2)),
+	}
+}
+
+// Next возвращает следующий фрагмент или io.EOF, когда поток кончился. Пустой поток
+// дает один пустой фрагмент. Фрагмент действителен до следующего вызова Next.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.maxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end && c.eof {
+		if c.buf == nil {
+			return nil, io.EOF
+		}
+		// Пустой поток — один пустой фрагмент.
+		c.buf = nil
+		return []byte{}, nil
+	}
+
+	data := c.buf[c.start:c.end]
+	n := c.cut(data)
+	c.start += n
+	if c.start == c.end && c.eof {
+		c.buf = nil
+	}
+	return data[:n], nil
+}
+
+// fill дочитывает буфер, сдвигая непрочитанное в начало.
+func (c *Chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut возвращает длину фрагмента в начале data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	n = min(n, c.maxSize)
+	normal := min(n, c.avg)
+
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,187 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkerEmptyStream(t *testing.T) {
+	c := NewChunker(bytes.NewReader(nil), MinChunkSize)
+	chunk, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunk == nil || len(chunk) != 0 {
+		t.Errorf("first chunk = %v, want an empty chunk", chunk)
+	}
+	for range 2 {
+		if _, err := c.Next(); err != io.EOF {
+			t.Errorf("Next() after the end: error = %v, want EOF", err)
+		}
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	for _, avg := range []int{MinChunkSize, 16 << 10} {
+		minSize, maxSize := ChunkBounds(avg)
+		for _, size := range []int{1, minSize, minSize + 1, maxSize, 50 * avg} {
+			t.Run(fmt.Sprintf("%d/%d", avg, size), func(t *testing.T) {
+				data := testData(size)
+				cuts := chunkOffsets(t, bytes.NewReader(data), avg)
+
+				if want := referenceCuts(data, avg); !slices.Equal(cuts, want) {
+					t.Fatalf("cuts = %v, want %v", cuts, want)
+				}
+				// Границы не зависят от того, какими порциями приходит поток.
+				if got := chunkOffsets(t, iotest.OneByteReader(bytes.NewReader(data)), avg); !slices.Equal(got, cuts) {
+					t.Errorf("one byte reads give cuts %v, want %v", got, cuts)
+				}
+
+				prev := 0
+				for i, cut := range cuts {
+					n := cut - prev
+					last := i == len(cuts)-1
+					if n > maxSize || n == 0 || (n < minSize && !last) {
+						t.Errorf("chunk %d has %d bytes, bounds [%d, %d]", i, n, minSize, maxSize)
+					}
+					prev = cut
+				}
+				if prev != size {
+					t.Errorf("chunks cover %d bytes, want %d", prev, size)
+				}
+			})
+		}
+	}
+}
+
+func TestChunkerGolden(t *testing.T) {
+	// Клиенты делят файлы сами, поэтому границы при том же содержимом не должны меняться.
+	want := []int{4438, 8565, 13064, 16156, 21092, 22375, 23507, 27713, 31342, 32768}
+	if got := chunkOffsets(t, bytes.NewReader(testData(32<<10)), MinChunkSize); !slices.Equal(got, want) {
+		t.Errorf("cuts = %v, want %v", got, want)
+	}
+}
+
+func TestChunkerResynchronizes(t *testing.T) {
+	avg := MinChunkSize
+	data := testData(100 * avg)
+	edited := slices.Insert(bytes.Clone(data), 10*avg, 'x')
+
+	before := chunkHashes(t, data, avg)
+	after := chunkHashes(t, edited, avg)
+	shared := 0
+	for hash := range after {
+		if before[hash] {
+			shared++
+		}
+	}
+	// Вставка затрагивает один-два фрагмента, остальные совпадают.
+	if shared < len(after)-3 {
+		t.Errorf("only %d of %d chunks survived a one byte insert", shared, len(after))
+	}
+}
+
+func TestChunkerReadError(t *testing.T) {
+	errRead := errors.New("read failed")
+	c := NewChunker(io.MultiReader(bytes.NewReader(testData(100)), iotest.ErrReader(errRead)), MinChunkSize)
+	if _, err := c.Next(); !errors.Is(err, errRead) {
+		t.Errorf("Next() error = %v, want %v", err, errRead)
+	}
+}
+
+func TestValidChunkSize(t *testing.T) {
+	for avg, want := range map[int64]bool{
+		0:                false,
+		MinChunkSize / 2: false,
+		MinChunkSize:     true,
+		1 << 20:          true,
+		1<<20 + 1:        false,
+		3 << 20:          false,
+		MaxChunkSize:     true,
+		MaxChunkSize * 2: false,
+		-MinChunkSize:    false,
+	} {
+		if got := ValidChunkSize(avg); got != want {
+			t.Errorf("ValidChunkSize(%d) = %v, want %v", avg, got, want)
+		}
+	}
+}
+
+// referenceCuts делит data целиком по описанию алгоритма в chunker.go.
+func referenceCuts(data []byte, avg int) []int {
+	bitsAvg := 0
+	for 1<<bitsAvg < avg {
+		bitsAvg++
+	}
+	maskS := ^uint64(0) << (64 - (bitsAvg + 2))
+	maskL := ^uint64(0) << (64 - (bitsAvg - 2))
+
+	var cuts []int
+	for start := 0; start < len(data); {
+		end := min(start+avg*4, len(data))
+		cut := end
+		var fp uint64
+		for i := start + avg/4; i < end; i++ {
+			sum := sha256.Sum256([]byte{data[i]})
+			fp = fp<<1 + binary.BigEndian.Uint64(sum[:8])
+			mask := maskL
+			if i-start < avg {
+				mask = maskS
+			}
+			if fp&mask == 0 {
+				cut = i + 1
+				break
+			}
+		}
+		cuts = append(cuts, cut)
+		start = cut
+	}
+	return cuts
+}
+
+// chunkOffsets возвращает концы фрагментов потока.
+func chunkOffsets(t *testing.T, r io.Reader, avg int) []int {
+	t.Helper()
+	var cuts []int
+	offset := 0
+	c := NewChunker(r, avg)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return cuts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset += len(chunk)
+		cuts = append(cuts, offset)
+	}
+}
+
+func chunkHashes(t *testing.T, data []byte, avg int) map[[32]byte]bool {
+	t.Helper()
+	hashes := make(map[[32]byte]bool)
+	prev := 0
+	for _, cut := range chunkOffsets(t, bytes.NewReader(data), avg) {
+		hashes[sha256.Sum256(data[prev:cut])] = true
+		prev = cut
+	}
+	return hashes
+}
+
+// testData возвращает n детерминированных байт, похожих на случайные.
+func testData(n int) []byte {
+	data := make([]byte, 0, n+sha256.Size)
+	for i := uint64(0); len(data) < n; i++ {
+		sum := sha256.Sum256(binary.BigEndian.AppendUint64(nil, i))
+		data = append(data, sum[:]...)
+	}
+	return data[:n]
+}
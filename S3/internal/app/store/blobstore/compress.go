@@ -0,0 +1,343 @@
+package blobstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Формат сжатого содержимого:
+//
+//	magic | алгоритм | блоки | концы блоков (uint64) | число блоков | размер | размер блока
+//
+// Каждый блок по compressBlockSize байт сжимается отдельно, а индекс в конце позволяет
+// начать чтение с любого блока, так что запросы с Range не распаковывают файл целиком.
+const (
+	compressMagic     = "GSCZ\x00v1\n"
+	compressBlockSize = 256 << 10
+	compressHeader    = len(compressMagic) + 1
+	compressTrailer   = 24
+)
+
+var ErrUnknownCompression = errors.New("compression must be gzip or zstd")
+
+var compressionIDs = map[string]byte{
+	CompressionGzip: 1,
+	CompressionZstd: 2,
+}
+
+// ValidCompression сообщает, поддерживается ли алгоритм сжатия.
+func ValidCompression(algorithm string) bool {
+	_, ok := compressionIDs[algorithm]
+	return ok
+}
+
+// Compress возвращает reader, отдающий содержимое r, сжатое алгоритмом algorithm.
+func Compress(r io.Reader, algorithm string) (io.Reader, error) {
+	id, ok := compressionIDs[algorithm]
+	if !ok {
+		return nil, ErrUnknownCompression
+	}
+	c, err := codecOf(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compressReader{
+		src:     r,
+		codec:   c,
+		plain:   make([]byte, compressBlockSize),
+		pending: append([]byte(compressMagic), id),
+	}, nil
+}
+
+// Decompress открывает содержимое, сжатое Compress, с поддержкой Seek. Содержимое без
+// заголовка сжатия возвращается как есть. При ошибке raw не закрывается.
+func Decompress(raw io.ReadSeekCloser) (io.ReadSeekCloser, error) {
+	header := make([]byte, compressHeader)
+	if _, err := io.ReadFull(raw, header); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if string(header[:len(compressMagic)]) != compressMagic {
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+	c, err := codecOf(header[len(compressMagic)])
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := raw.Seek(-compressTrailer, io.SeekEnd)
+	if err != nil {
+		return nil, ErrCorruptedBlob
+	}
+	trailer := make([]byte, compressTrailer)
+	if _, err := io.ReadFull(raw, trailer); err != nil {
+		return nil, corrupted(err)
+	}
+	var (
+		blocks    = int64(binary.BigEndian.Uint64(trailer[0:]))
+		size      = int64(binary.BigEndian.Uint64(trailer[8:]))
+		blockSize = int64(binary.BigEndian.Uint64(trailer[16:]))
+	)
+	if blockSize <= 0 || blockSize > 64<<20 || blocks < 0 || blocks > end/8 ||
+		(blocks-1)*blockSize >= size && size > 0 || size > blocks*blockSize {
+		return nil, ErrCorruptedBlob
+	}
+
+	index := end - blocks*8
+	if index < int64(compressHeader) {
+		return nil, ErrCorruptedBlob
+	}
+	if _, err := raw.Seek(index, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, blocks*8)
+	if _, err := io.ReadFull(raw, buf); err != nil {
+		return nil, corrupted(err)
+	}
+	ends := make([]int64, blocks)
+	prev := int64(compressHeader)
+	for i := range ends {
+		ends[i] = int64(compressHeader) + int64(binary.BigEndian.Uint64(buf[i*8:]))
+		if ends[i] < prev || ends[i] > index {
+			return nil, ErrCorruptedBlob
+		}
+		prev = ends[i]
+	}
+
+	return &decompressReader{
+		raw:       raw,
+		codec:     c,
+		ends:      ends,
+		size:      size,
+		blockSize: blockSize,
+		block:     -1,
+	}, nil
+}
+
+// codec сжимает и распаковывает отдельные блоки.
+type codec interface {
+	encode(dst, src []byte) ([]byte, error)
+	decode(dst, src []byte, size int) ([]byte, error)
+}
+
+func codecOf(id byte) (codec, error) {
+	switch id {
+	case compressionIDs[CompressionGzip]:
+		return gzipCodec{}, nil
+	case compressionIDs[CompressionZstd]:
+		return newZstdCodec()
+	default:
+		return nil, fmt.Errorf("%w: unknown algorithm %d", ErrCorruptedBlob, id)
+	}
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) decode(dst, src []byte, size int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, ErrCorruptedBlob
+	}
+	buf := bytes.NewBuffer(dst)
+	if _, err := io.Copy(buf, io.LimitReader(r, int64(size)+1)); err != nil {
+		return nil, ErrCorruptedBlob
+	}
+	return buf.Bytes(), nil
+}
+
+// zstd-кодировщик и декодировщик безопасны для параллельных EncodeAll и DecodeAll,
+// поэтому создаются один раз на процесс.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+type zstdCodec struct{}
+
+func newZstdCodec() (codec, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(64<<20))
+	})
+	return zstdCodec{}, zstdErr
+}
+
+func (zstdCodec) encode(dst, src []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(src, dst), nil
+}
+
+func (zstdCodec) decode(dst, src []byte, size int) ([]byte, error) {
+	plain, err := zstdDecoder.DecodeAll(src, dst)
+	if err != nil {
+		return nil, ErrCorruptedBlob
+	}
+	return plain, nil
+}
+
+// compressReader отдает заголовок, блоки, сжатые по мере чтения src, и индекс.
+type compressReader struct {
+	src     io.Reader
+	codec   codec
+	plain   []byte
+	out     []byte
+	pending []byte
+	ends    []uint64
+	written uint64
+	size    uint64
+	done    bool
+}
+
+func (r *compressReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *compressReader) next() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if errors.Is(err, io.EOF) {
+		r.pending = r.trailer()
+		r.done = true
+		return nil
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	if r.out, err = r.codec.encode(r.out[:0], r.plain[:n]); err != nil {
+		return err
+	}
+	r.written += uint64(len(r.out))
+	r.size += uint64(n)
+	r.ends = append(r.ends, r.written)
+	r.pending = r.out
+	return nil
+}
+
+func (r *compressReader) trailer() []byte {
+	b := make([]byte, 0, len(r.ends)*8+compressTrailer)
+	for _, end := range r.ends {
+		b = binary.BigEndian.AppendUint64(b, end)
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(len(r.ends)))
+	b = binary.BigEndian.AppendUint64(b, r.size)
+	return binary.BigEndian.AppendUint64(b, compressBlockSize)
+}
+
+// decompressReader распаковывает блоки по требованию и поддерживает Seek.
+type decompressReader struct {
+	raw       io.ReadSeekCloser
+	codec     codec
+	ends      []int64
+	size      int64
+	blockSize int64
+	pos       int64
+	// block — номер блока, распакованного в plain, или -1.
+	block  int64
+	plain  []byte
+	packed []byte
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	block := r.pos / r.blockSize
+	if block != r.block {
+		if err := r.load(block); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos-block*r.blockSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decompressReader) load(block int64) error {
+	start := int64(compressHeader)
+	if block > 0 {
+		start = r.ends[block-1]
+	}
+	if _, err := r.raw.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	if n := r.ends[block] - start; int64(cap(r.packed)) < n {
+		r.packed = make([]byte, n)
+	} else {
+		r.packed = r.packed[:n]
+	}
+	if _, err := io.ReadFull(r.raw, r.packed); err != nil {
+		return corrupted(err)
+	}
+
+	want := min(r.blockSize, r.size-block*r.blockSize)
+	plain, err := r.codec.decode(r.plain[:0], r.packed, int(want))
+	if err != nil || int64(len(plain)) != want {
+		r.block = -1
+		return ErrCorruptedBlob
+	}
+	r.plain = plain
+	r.block = block
+	return nil
+}
+
+func (r *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *decompressReader) Close() error {
+	return r.raw.Close()
+}
@@ -0,0 +1,212 @@
+package blobstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, 1000, compressBlockSize - 1, compressBlockSize, compressBlockSize + 1, 3*compressBlockSize + 500}
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		for _, size := range sizes {
+			t.Run(fmt.Sprintf("%s/%d", algorithm, size), func(t *testing.T) {
+				data := compressible(size)
+				packed := compress(t, data, algorithm)
+				if size >= compressBlockSize && len(packed) >= size {
+					t.Errorf("compressed %d bytes into %d", size, len(packed))
+				}
+
+				r := decompress(t, packed)
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("round trip returned %d bytes, want %d", len(got), len(data))
+				}
+			})
+		}
+	}
+}
+
+func TestCompressUnknownAlgorithm(t *testing.T) {
+	if _, err := Compress(bytes.NewReader(nil), "lz4"); !errors.Is(err, ErrUnknownCompression) {
+		t.Errorf("Compress() error = %v, want ErrUnknownCompression", err)
+	}
+}
+
+func TestDecompressSeek(t *testing.T) {
+	data := compressible(3*compressBlockSize + 500)
+	r := decompress(t, compress(t, data, CompressionZstd))
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		n      int
+		want   int64
+	}{
+		{"middle of a block", compressBlockSize + compressBlockSize/2, io.SeekStart, 100, compressBlockSize + compressBlockSize/2},
+		{"across a block boundary", 2*compressBlockSize - 10, io.SeekStart, 30, 2*compressBlockSize - 10},
+		{"back into the first block", 7, io.SeekStart, 10, 7},
+		{"from the current position", 1000, io.SeekCurrent, 10, 1017},
+		{"from the end", -20, io.SeekEnd, 20, int64(len(data)) - 20},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pos, err := r.Seek(tc.offset, tc.whence)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pos != tc.want {
+				t.Fatalf("Seek() = %d, want %d", pos, tc.want)
+			}
+			got := make([]byte, tc.n)
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data[pos:pos+int64(tc.n)]) {
+				t.Errorf("read at %d returned wrong bytes", pos)
+			}
+		})
+	}
+
+	if _, err := r.Seek(int64(len(data))+10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Read past the end = %d, %v, want 0, EOF", n, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative position succeeded")
+	}
+}
+
+func TestDecompressPlain(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("abc"), []byte("plain content longer than the compression header")} {
+		r := decompress(t, data)
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("uncompressed content %q came back as %q", data, got)
+		}
+	}
+}
+
+func TestDecompressCorruptTrailer(t *testing.T) {
+	data := compressible(2*compressBlockSize + 100)
+	packed := compress(t, data, CompressionGzip)
+	trailer := len(packed) - compressTrailer
+	index := trailer - 3*8
+
+	tests := []struct {
+		name    string
+		corrupt func(b []byte) []byte
+	}{
+		{"shorter than the trailer", func(b []byte) []byte {
+			return b[:compressHeader+10]
+		}},
+		{"cut trailer", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}},
+		{"too many blocks", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[trailer:], 1<<40)
+			return b
+		}},
+		{"size larger than the blocks", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[trailer+8:], 3*compressBlockSize+1)
+			return b
+		}},
+		{"size smaller than the blocks", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[trailer+8:], compressBlockSize)
+			return b
+		}},
+		{"zero block size", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[trailer+16:], 0)
+			return b
+		}},
+		{"huge block size", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[trailer+16:], 1<<40)
+			return b
+		}},
+		{"block ends out of order", func(b []byte) []byte {
+			first := binary.BigEndian.Uint64(b[index:])
+			second := binary.BigEndian.Uint64(b[index+8:])
+			binary.BigEndian.PutUint64(b[index:], second)
+			binary.BigEndian.PutUint64(b[index+8:], first)
+			return b
+		}},
+		{"block end inside the index", func(b []byte) []byte {
+			binary.BigEndian.PutUint64(b[index+16:], uint64(len(b)))
+			return b
+		}},
+		{"unknown algorithm", func(b []byte) []byte {
+			b[len(compressMagic)] = 99
+			return b
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := nopCloser{bytes.NewReader(tc.corrupt(bytes.Clone(packed)))}
+			if _, err := Decompress(raw); !errors.Is(err, ErrCorruptedBlob) {
+				t.Errorf("Decompress() error = %v, want ErrCorruptedBlob", err)
+			}
+		})
+	}
+}
+
+func TestDecompressCorruptBlock(t *testing.T) {
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			packed := compress(t, compressible(2*compressBlockSize), algorithm)
+			// Портим середину второго блока: первый читается, второй — нет.
+			first := compressHeader + int(binary.BigEndian.Uint64(packed[len(packed)-compressTrailer-2*8:]))
+			packed[first+20] ^= 0xff
+
+			r := decompress(t, packed)
+			if _, err := io.ReadFull(r, make([]byte, compressBlockSize)); err != nil {
+				t.Fatalf("intact block: %v", err)
+			}
+			if _, err := io.ReadAll(r); !errors.Is(err, ErrCorruptedBlob) {
+				t.Errorf("corrupted block: error = %v, want ErrCorruptedBlob", err)
+			}
+		})
+	}
+}
+
+func compress(t *testing.T, data []byte, algorithm string) []byte {
+	t.Helper()
+	r, err := Compress(bytes.NewReader(data), algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func decompress(t *testing.T, packed []byte) io.ReadSeekCloser {
+	t.Helper()
+	r, err := Decompress(nopCloser{bytes.NewReader(packed)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// compressible возвращает n байт текста, который хорошо сжимается, но не повторяется целиком.
+func compressible(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "line %d of the test file\n", i)
+	}
+	return b.Bytes()[:n]
+}
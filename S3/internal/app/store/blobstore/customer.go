@@ -0,0 +1,62 @@
+package blobstore
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+// Содержимое, зашифрованное ключом клиента (SSE-C), хранится в формате EncryptedBackend
+// с пустым идентификатором ключа: ключ данных зашифрован самим ключом клиента,
+// который сервер не сохраняет.
+const customerKeyID = ""
+
+var ErrInvalidCustomerKey = errors.New("customer key must be 32 bytes")
+
+// EncryptWithKey возвращает reader, отдающий содержимое r, зашифрованное ключом клиента.
+func EncryptWithKey(r io.Reader, key []byte) (io.Reader, error) {
+	kek, err := customerKEK(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dek := make([]byte, encKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	header, err := sealHeader(kek, customerKeyID, "", dek)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptReader(r, header, dek)
+}
+
+// DecryptWithKey открывает содержимое, зашифрованное EncryptWithKey. Поддерживает Seek,
+// как и чтение из EncryptedBackend. При ошибке raw не закрывается.
+func DecryptWithKey(raw io.ReadSeekCloser, key []byte) (io.ReadSeekCloser, error) {
+	kek, err := customerKEK(key)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := readHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil || h.keyID != customerKeyID {
+		return nil, ErrCorruptedBlob
+	}
+	dek, err := h.open(kek)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(raw, h, dek)
+}
+
+func customerKEK(key []byte) (cipher.AEAD, error) {
+	if len(key) != encKeySize {
+		return nil, ErrInvalidCustomerKey
+	}
+	return newGCM(key)
+}
@@ -0,0 +1,185 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tempPrefix = ".upload-"
+
+// DiskBackend хранит блобы в файлах StorePath/<key>.
+type DiskBackend struct {
+	root string
+}
+
+func NewDiskBackend(root string) *DiskBackend {
+	return &DiskBackend{
+		root: root,
+	}
+}
+
+func (b *DiskBackend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	dirPath := filepath.Dir(path)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return 0, err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы читатели
+	// никогда не видели недописанный блоб.
+	tmp, err := os.CreateTemp(dirPath, tempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+	defer func(name string) {
+		_ = os.Remove(name)
+	}(tmp.Name())
+
+	n, err := io.Copy(tmp, ctxReader{ctx, r})
+	if err != nil {
+		_ = tmp.Close()
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(tmp.Name(), path)
+}
+
+func (b *DiskBackend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (b *DiskBackend) Delete(ctx context.Context, key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Rename переименовывает файл на диске, поэтому читатели видят либо старое, либо новое
+// расположение. Ключи должны лежать в одном хранилище, как и все ключи backend.
+func (b *DiskBackend) Rename(ctx context.Context, src, dst string) error {
+	srcPath, err := b.path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := b.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(srcPath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	return os.Rename(srcPath, dstPath)
+}
+
+func (b *DiskBackend) Stat(ctx context.Context, key string) (Info, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, err
+	}
+	if fi.IsDir() {
+		return Info{}, ErrNotFound
+	}
+
+	return Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (b *DiskBackend) List(ctx context.Context, prefix string) ([]Info, error) {
+	// Обходим только каталог, в котором может лежать prefix, а не все хранилище.
+	dir := b.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		if dir, err = b.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var infos []Info
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		infos = append(infos, Info{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return infos, nil
+}
+
+// path переводит ключ в путь на диске и не дает выйти за пределы корня хранилища.
+func (b *DiskBackend) path(key string) (string, error) {
+	if key == "" || !fs.ValidPath(key) || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(b.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,593 @@
+package blobstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Формат зашифрованного блоба:
+//
+//	magic | len(keyID) keyID | len(scope) scope | nonce | wrapped DEK | блоки
+//
+// Каждый блоб шифруется своим случайным ключом данных (DEK) в AES-256-GCM блоками
+// по encChunkSize байт. Номер блока — nonce, признак последнего блока — дополнительные
+// данные, так что блоки нельзя переставить или отрезать. DEK зашифрован ключом
+// keyID из Keyring, а при заданном scope — ключом, выведенным из него для владельца.
+const (
+	encMagic     = "GSSE\x00v1\n"
+	encChunkSize = 64 << 10
+	encKeySize   = 32
+	encNonceSize = 12
+	encTagSize   = 16
+	encSealed    = encChunkSize + encTagSize
+	encWrapped   = encNonceSize + encKeySize + encTagSize
+)
+
+var (
+	ErrUnknownKey       = errors.New("blob is encrypted with an unknown key")
+	ErrCorruptedBlob    = errors.New("encrypted blob is corrupted")
+	ErrInvalidMasterKey = errors.New("encryption key must be 32 bytes encoded in base64")
+)
+
+// Keyring — мастер-ключи шифрования по идентификаторам. Новые блобы шифруются
+// ключом ActiveID, остальные нужны, чтобы читать блобы, еще не перешифрованные после ротации.
+type Keyring struct {
+	ActiveID string
+	Keys     map[string][]byte
+	// Scope возвращает владельца блоба по ключу. Для непустого владельца ключ данных
+	// шифруется ключом, выведенным из мастер-ключа для него. nil — у всех блобов общий ключ.
+	Scope func(key string) string
+}
+
+// NewKeyring разбирает мастер-ключи из конфигурации: 32 байта в base64.
+func NewKeyring(activeID string, keys map[string]string) (*Keyring, error) {
+	k := &Keyring{ActiveID: activeID, Keys: make(map[string][]byte, len(keys))}
+	for id, s := range keys {
+		key, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(key) != encKeySize {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidMasterKey, id)
+		}
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid encryption key id %q", id)
+		}
+		k.Keys[id] = key
+	}
+	if _, ok := k.Keys[activeID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not configured", activeID)
+	}
+	return k, nil
+}
+
+// kek возвращает ключ, которым шифруется ключ данных блоба владельца scope.
+func (k *Keyring) kek(id, scope string) (cipher.AEAD, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if scope != "" {
+		var err error
+		if key, err = hkdf.Key(sha256.New, key, nil, "blob key/"+scope, encKeySize); err != nil {
+			return nil, err
+		}
+	}
+	return newGCM(key)
+}
+
+// EncryptedBackend шифрует содержимое блобов перед записью в другой backend.
+// Блобы, записанные до включения шифрования, читаются как есть.
+type EncryptedBackend struct {
+	inner BlobBackend
+	keys  *Keyring
+}
+
+func NewEncryptedBackend(inner BlobBackend, keys *Keyring) *EncryptedBackend {
+	return &EncryptedBackend{
+		inner: inner,
+		keys:  keys,
+	}
+}
+
+// Put шифрует содержимое по мере чтения и возвращает число байт открытого текста.
+func (b *EncryptedBackend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	header, dek, err := b.newHeader(key)
+	if err != nil {
+		return 0, err
+	}
+	enc, err := newEncryptReader(r, header, dek)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = b.inner.Put(ctx, key, enc)
+	return enc.n, err
+}
+
+func (b *EncryptedBackend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	raw, err := b.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.open(raw)
+	if err != nil {
+		_ = raw.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (b *EncryptedBackend) Delete(ctx context.Context, key string) error {
+	return b.inner.Delete(ctx, key)
+}
+
+// Rename не трогает содержимое: владелец записан в заголовке блоба.
+func (b *EncryptedBackend) Rename(ctx context.Context, src, dst string) error {
+	return b.inner.Rename(ctx, src, dst)
+}
+
+// Stat возвращает размер открытого текста, для чего читает заголовок блоба.
+func (b *EncryptedBackend) Stat(ctx context.Context, key string) (Info, error) {
+	info, err := b.inner.Stat(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	return b.plainInfo(ctx, info)
+}
+
+func (b *EncryptedBackend) List(ctx context.Context, prefix string) ([]Info, error) {
+	infos, err := b.inner.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range infos {
+		if infos[i], err = b.plainInfo(ctx, info); err != nil {
+			return nil, err
+		}
+	}
+	return infos, nil
+}
+
+// Rewrap перешифровывает ключи данных всех блобов активным мастер-ключом, не трогая
+// само содержимое, а блобы без шифрования шифрует целиком. После этого старые
+// мастер-ключи можно убрать из конфигурации. Запись, идущая параллельно, может
+// потеряться, поэтому Rewrap запускается при остановленном сервере.
+// Возвращает число переписанных блобов.
+func (b *EncryptedBackend) Rewrap(ctx context.Context) (int, error) {
+	infos, err := b.inner.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, info := range infos {
+		rewrapped, err := b.rewrap(ctx, info.Key)
+		if err != nil {
+			return done, fmt.Errorf("%s: %w", info.Key, err)
+		}
+		if rewrapped {
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (b *EncryptedBackend) rewrap(ctx context.Context, key string) (bool, error) {
+	raw, err := b.inner.Get(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer func(raw io.Closer) {
+		_ = raw.Close()
+	}(raw)
+
+	h, err := serverHeader(raw)
+	if err != nil {
+		return false, err
+	}
+	if h == nil {
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		_, err := b.Put(ctx, key, raw)
+		return err == nil, err
+	}
+
+	scope := b.scope(key)
+	if h.keyID == b.keys.ActiveID && h.scope == scope {
+		return false, nil
+	}
+	dek, err := b.unwrap(h)
+	if err != nil {
+		return false, err
+	}
+	header, err := b.wrap(dek, scope)
+	if err != nil {
+		return false, err
+	}
+
+	// Блоки остаются прежними: меняется только заголовок.
+	if _, err := raw.Seek(h.size, io.SeekStart); err != nil {
+		return false, err
+	}
+	_, err = b.inner.Put(ctx, key, io.MultiReader(bytes.NewReader(header), raw))
+	return err == nil, err
+}
+
+// open возвращает расшифровывающий reader или сам raw, если блоб не зашифрован.
+func (b *EncryptedBackend) open(raw io.ReadSeekCloser) (io.ReadSeekCloser, error) {
+	h, err := serverHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		if _, err := raw.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+
+	dek, err := b.unwrap(h)
+	if err != nil {
+		return nil, err
+	}
+	return newDecryptReader(raw, h, dek)
+}
+
+func (b *EncryptedBackend) plainInfo(ctx context.Context, info Info) (Info, error) {
+	raw, err := b.inner.Get(ctx, info.Key)
+	if err != nil {
+		return Info{}, err
+	}
+	defer func(raw io.Closer) {
+		_ = raw.Close()
+	}(raw)
+
+	h, err := serverHeader(raw)
+	if err != nil || h == nil {
+		return info, err
+	}
+	if info.Size, _, err = plainSize(info.Size - h.size); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+func (b *EncryptedBackend) scope(key string) string {
+	if b.keys.Scope == nil {
+		return ""
+	}
+	return b.keys.Scope(key)
+}
+
+// newHeader создает ключ данных для нового блоба и заголовок с ним.
+func (b *EncryptedBackend) newHeader(key string) ([]byte, []byte, error) {
+	dek := make([]byte, encKeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, err
+	}
+	header, err := b.wrap(dek, b.scope(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, dek, nil
+}
+
+func (b *EncryptedBackend) wrap(dek []byte, scope string) ([]byte, error) {
+	if len(scope) > 255 {
+		return nil, fmt.Errorf("key scope %q is too long", scope)
+	}
+	kek, err := b.keys.kek(b.keys.ActiveID, scope)
+	if err != nil {
+		return nil, err
+	}
+	return sealHeader(kek, b.keys.ActiveID, scope, dek)
+}
+
+func (b *EncryptedBackend) unwrap(h *header) ([]byte, error) {
+	kek, err := b.keys.kek(h.keyID, h.scope)
+	if err != nil {
+		return nil, err
+	}
+	return h.open(kek)
+}
+
+// sealHeader строит заголовок блоба с ключом данных dek, зашифрованным ключом kek.
+func sealHeader(kek cipher.AEAD, keyID, scope string, dek []byte) ([]byte, error) {
+	header := []byte(encMagic)
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = append(header, byte(len(scope)))
+	header = append(header, scope...)
+
+	nonce := make([]byte, encNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// Заголовок до ключа — дополнительные данные: идентификатор ключа и владельца нельзя подменить.
+	return kek.Seal(append(header, nonce...), nonce, dek, header), nil
+}
+
+type header struct {
+	keyID   string
+	scope   string
+	aad     []byte
+	wrapped []byte
+	// size — длина заголовка, с нее начинаются блоки.
+	size int64
+}
+
+// open расшифровывает ключ данных ключом kek.
+func (h *header) open(kek cipher.AEAD) ([]byte, error) {
+	dek, err := kek.Open(nil, h.wrapped[:encNonceSize], h.wrapped[encNonceSize:], h.aad)
+	if err != nil {
+		return nil, ErrCorruptedBlob
+	}
+	return dek, nil
+}
+
+// serverHeader читает заголовок блоба, зашифрованного EncryptedBackend. Содержимое,
+// зашифрованное ключом клиента без серверного шифрования, для backend — открытый текст.
+func serverHeader(r io.Reader) (*header, error) {
+	h, err := readHeader(r)
+	if err != nil || h == nil || h.keyID == customerKeyID {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readHeader читает заголовок зашифрованного блоба. Для блоба без шифрования
+// возвращает nil без ошибки.
+func readHeader(r io.Reader) (*header, error) {
+	magic := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(magic) != encMagic {
+		return nil, nil
+	}
+
+	aad := magic
+	field := func() (string, error) {
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", err
+		}
+		s := make([]byte, n[0])
+		if _, err := io.ReadFull(r, s); err != nil {
+			return "", err
+		}
+		aad = append(append(aad, n[0]), s...)
+		return string(s), nil
+	}
+
+	h := &header{wrapped: make([]byte, encWrapped)}
+	var err error
+	if h.keyID, err = field(); err != nil {
+		return nil, corrupted(err)
+	}
+	if h.scope, err = field(); err != nil {
+		return nil, corrupted(err)
+	}
+	if _, err := io.ReadFull(r, h.wrapped); err != nil {
+		return nil, corrupted(err)
+	}
+	h.aad = aad
+	h.size = int64(len(aad) + encWrapped)
+	return h, nil
+}
+
+// plainSize возвращает размер открытого текста и число блоков по размеру зашифрованных блоков.
+// Даже пустой блоб состоит из одного блока.
+func plainSize(sealed int64) (int64, int64, error) {
+	chunks := (sealed + encSealed - 1) / encSealed
+	if chunks == 0 || sealed-(chunks-1)*encSealed < encTagSize {
+		return 0, 0, ErrCorruptedBlob
+	}
+	return sealed - chunks*encTagSize, chunks, nil
+}
+
+// encryptReader отдает заголовок, а за ним блоки, зашифрованные по мере чтения src.
+type encryptReader struct {
+	aead    cipher.AEAD
+	src     *bufio.Reader
+	plain   []byte
+	sealed  []byte
+	pending []byte
+	chunk   uint64
+	done    bool
+	// n — сколько байт открытого текста прочитано.
+	n int64
+}
+
+func newEncryptReader(r io.Reader, header, dek []byte) (*encryptReader, error) {
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		aead:    aead,
+		src:     bufio.NewReaderSize(r, encChunkSize),
+		plain:   make([]byte, encChunkSize),
+		pending: header,
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *encryptReader) next() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		r.done = true
+	case err != nil:
+		return err
+	default:
+		// Блок последний, если за ним ничего нет: иначе его не отличить от обрезанного блоба.
+		if _, err := r.src.Peek(1); errors.Is(err, io.EOF) {
+			r.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	r.n += int64(n)
+	r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.chunk), r.plain[:n], chunkAAD(r.done))
+	r.pending = r.sealed
+	r.chunk++
+	return nil
+}
+
+// decryptReader расшифровывает блоки по требованию, поэтому поддерживает Seek
+// и запросы с Range без чтения блоба целиком.
+type decryptReader struct {
+	raw    io.ReadSeekCloser
+	aead   cipher.AEAD
+	offset int64
+	end    int64
+	size   int64
+	chunks int64
+	pos    int64
+	// chunk — номер блока, расшифрованного в plain, или -1.
+	chunk  int64
+	plain  []byte
+	sealed []byte
+}
+
+// newDecryptReader читает блоб raw, заголовок h которого уже прочитан.
+func newDecryptReader(raw io.ReadSeekCloser, h *header, dek []byte) (*decryptReader, error) {
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	end, err := raw.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	size, chunks, err := plainSize(end - h.size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		raw:    raw,
+		aead:   aead,
+		offset: h.size,
+		end:    end,
+		size:   size,
+		chunks: chunks,
+		chunk:  -1,
+		plain:  make([]byte, 0, encChunkSize),
+		sealed: make([]byte, encSealed),
+	}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	chunk := r.pos / encChunkSize
+	if chunk != r.chunk {
+		if err := r.load(chunk); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain[r.pos-chunk*encChunkSize:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *decryptReader) load(chunk int64) error {
+	start := r.offset + chunk*encSealed
+	if _, err := r.raw.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	sealed := r.sealed[:min(encSealed, r.end-start)]
+	if _, err := io.ReadFull(r.raw, sealed); err != nil {
+		return corrupted(err)
+	}
+
+	plain, err := r.aead.Open(r.plain[:0], chunkNonce(uint64(chunk)), sealed, chunkAAD(chunk == r.chunks-1))
+	if err != nil {
+		r.chunk = -1
+		return ErrCorruptedBlob
+	}
+	r.plain = plain
+	r.chunk = chunk
+	return nil
+}
+
+func (r *decryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.raw.Close()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(chunk uint64) []byte {
+	nonce := make([]byte, encNonceSize)
+	binary.BigEndian.PutUint64(nonce[encNonceSize-8:], chunk)
+	return nonce
+}
+
+func chunkAAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func corrupted(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCorruptedBlob
+	}
+	return err
+}
@@ -0,0 +1,446 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryBackend()
+	b := NewEncryptedBackend(mem, testKeyring(t, "k1", "k1"))
+
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 3*encChunkSize + 7} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := randomBytes(t, size)
+			key := fmt.Sprintf("blob/%d", size)
+			n, err := b.Put(ctx, key, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(size) {
+				t.Errorf("Put() = %d, want %d", n, size)
+			}
+
+			raw := rawBlob(t, mem, key)
+			if size >= 16 && bytes.Contains(raw, data) {
+				t.Error("content is stored in the clear")
+			}
+			got, err := readBlob(b, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip returned %d bytes, want %d", len(got), size)
+			}
+
+			info, err := b.Stat(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size != int64(size) {
+				t.Errorf("Stat().Size = %d, want %d", info.Size, size)
+			}
+		})
+	}
+
+	infos, err := b.List(ctx, "blob/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if want := strings.TrimPrefix(info.Key, "blob/"); fmt.Sprint(info.Size) != want {
+			t.Errorf("List() size of %s = %d", info.Key, info.Size)
+		}
+	}
+}
+
+func TestEncryptedSeek(t *testing.T) {
+	ctx := context.Background()
+	b := NewEncryptedBackend(NewMemoryBackend(), testKeyring(t, "k1", "k1"))
+	data := randomBytes(t, 3*encChunkSize+100)
+	if _, err := b.Put(ctx, "blob", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.Get(ctx, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r io.Closer) {
+		_ = r.Close()
+	}(r)
+
+	for _, offset := range []int64{encChunkSize + 10, 2*encChunkSize - 5, 3, int64(len(data)) - 50} {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, 40)
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data[offset:offset+40]) {
+			t.Errorf("read at %d returned wrong bytes", offset)
+		}
+	}
+
+	if pos, err := r.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(data))-10 {
+		t.Errorf("Seek(-10, SeekEnd) = %d, %v", pos, err)
+	}
+	if _, err := r.Seek(int64(len(data)), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read at the end: error = %v, want EOF", err)
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	ctx := context.Background()
+	data := randomBytes(t, 3*encChunkSize+7)
+	headerSize := int(encryptedHeaderSize(t, testKeyring(t, "k1", "k1"), "blob"))
+	chunk := func(i int) int { return headerSize + i*encSealed }
+
+	tests := []struct {
+		name    string
+		corrupt func(raw []byte) []byte
+		want    error
+	}{
+		{"flipped block byte", func(raw []byte) []byte {
+			raw[chunk(1)+100] ^= 1
+			return raw
+		}, ErrCorruptedBlob},
+		{"flipped tag byte", func(raw []byte) []byte {
+			raw[len(raw)-1] ^= 1
+			return raw
+		}, ErrCorruptedBlob},
+		{"flipped wrapped key", func(raw []byte) []byte {
+			raw[headerSize-1] ^= 1
+			return raw
+		}, ErrCorruptedBlob},
+		{"changed scope", func(raw []byte) []byte {
+			// Пустой scope — один байт длины; подменяем его на однобайтовый scope, съедая байт nonce.
+			i := len(encMagic) + 1 + len("k1")
+			raw[i] = 1
+			return raw
+		}, ErrCorruptedBlob},
+		{"unknown key id", func(raw []byte) []byte {
+			raw[len(encMagic)+2] = 'x'
+			return raw
+		}, ErrUnknownKey},
+		{"swapped blocks", func(raw []byte) []byte {
+			first := bytes.Clone(raw[chunk(0):chunk(1)])
+			copy(raw[chunk(0):], raw[chunk(1):chunk(2)])
+			copy(raw[chunk(1):], first)
+			return raw
+		}, ErrCorruptedBlob},
+		{"truncated at a block boundary", func(raw []byte) []byte {
+			// Без последнего блока предпоследний выглядит последним, но зашифрован не как последний.
+			return raw[:chunk(3)]
+		}, ErrCorruptedBlob},
+		{"truncated inside a block", func(raw []byte) []byte {
+			return raw[:chunk(2)+100]
+		}, ErrCorruptedBlob},
+		{"truncated inside a tag", func(raw []byte) []byte {
+			return raw[:chunk(3)+encTagSize-1]
+		}, ErrCorruptedBlob},
+		{"truncated header", func(raw []byte) []byte {
+			return raw[:headerSize-1]
+		}, ErrCorruptedBlob},
+		{"extended with a block", func(raw []byte) []byte {
+			return append(raw, raw[chunk(1):chunk(2)]...)
+		}, ErrCorruptedBlob},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := NewMemoryBackend()
+			b := NewEncryptedBackend(mem, testKeyring(t, "k1", "k1"))
+			if _, err := b.Put(ctx, "blob", bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+			putRaw(t, mem, "blob", tc.corrupt(rawBlob(t, mem, "blob")))
+
+			if _, err := readBlob(b, "blob"); !errors.Is(err, tc.want) {
+				t.Errorf("read error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestEncryptedReadsPlainBlobs(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryBackend()
+	b := NewEncryptedBackend(mem, testKeyring(t, "k1", "k1"))
+
+	for _, data := range [][]byte{nil, []byte("GSSE"), []byte("written before encryption was enabled")} {
+		putRaw(t, mem, "plain", data)
+		got, err := readBlob(b, "plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("plain blob %q came back as %q", data, got)
+		}
+		info, err := b.Stat(ctx, "plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != int64(len(data)) {
+			t.Errorf("Stat().Size = %d, want %d", info.Size, len(data))
+		}
+	}
+}
+
+func TestEncryptedScope(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryBackend()
+	keys := testKeyring(t, "k1", "k1")
+	keys.Scope = func(key string) string {
+		return strings.Split(key, "/")[1]
+	}
+	b := NewEncryptedBackend(mem, keys)
+
+	data := []byte("owned by user 1")
+	if _, err := b.Put(ctx, "buckets/1/file", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if h := blobHeader(t, mem, "buckets/1/file"); h.scope != "1" {
+		t.Errorf("scope = %q, want 1", h.scope)
+	}
+
+	// Владелец записан в заголовке, поэтому перенос под другой ключ блоб не ломает.
+	if err := b.Rename(ctx, "buckets/1/file", "trash/1/file"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readBlob(b, "trash/1/file"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("renamed blob = %q, %v", got, err)
+	}
+
+	// Ключ владельца выведен из мастер-ключа: без scope ключ данных не открыть.
+	h := blobHeader(t, mem, "trash/1/file")
+	kek, err := keys.kek("k1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.open(kek); !errors.Is(err, ErrCorruptedBlob) {
+		t.Errorf("opened a scoped key with the master key: %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryBackend()
+	old := NewEncryptedBackend(mem, testKeyring(t, "k1", "k1"))
+
+	blobs := map[string][]byte{
+		"buckets/1/a": randomBytes(t, 2*encChunkSize+3),
+		"buckets/2/b": randomBytes(t, 10),
+	}
+	for key, data := range blobs {
+		if _, err := old.Put(ctx, key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blobs["buckets/1/plain"] = []byte("not encrypted yet")
+	putRaw(t, mem, "buckets/1/plain", blobs["buckets/1/plain"])
+	blocks := rawBlob(t, mem, "buckets/1/a")[encryptedHeaderSize(t, old.keys, "buckets/1/a"):]
+
+	keys := testKeyring(t, "k2", "k1", "k2")
+	keys.Scope = func(key string) string {
+		return strings.Split(key, "/")[1]
+	}
+	n, err := NewEncryptedBackend(mem, keys).Rewrap(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(blobs) {
+		t.Errorf("Rewrap() = %d, want %d", n, len(blobs))
+	}
+	if n, err := NewEncryptedBackend(mem, keys).Rewrap(ctx); err != nil || n != 0 {
+		t.Errorf("second Rewrap() = %d, %v, want 0", n, err)
+	}
+
+	raw := rawBlob(t, mem, "buckets/1/a")
+	if !bytes.HasSuffix(raw, blocks) {
+		t.Error("Rewrap re-encrypted the blocks instead of the header")
+	}
+
+	// Старый ключ больше не нужен.
+	keys.Keys = map[string][]byte{"k2": keys.Keys["k2"]}
+	b := NewEncryptedBackend(mem, keys)
+	for key, data := range blobs {
+		h := blobHeader(t, mem, key)
+		if h.keyID != "k2" || h.scope != keys.Scope(key) {
+			t.Errorf("%s: header key %q scope %q", key, h.keyID, h.scope)
+		}
+		got, err := readBlob(b, key)
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: content changed after Rewrap", key)
+		}
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	valid := testKey(1)
+	tests := []struct {
+		name   string
+		active string
+		keys   map[string]string
+	}{
+		{"not base64", "k1", map[string]string{"k1": "not base64!"}},
+		{"short key", "k1", map[string]string{"k1": base64.StdEncoding.EncodeToString(make([]byte, 16))}},
+		{"empty id", "", map[string]string{"": valid}},
+		{"long id", strings.Repeat("k", 256), map[string]string{strings.Repeat("k", 256): valid}},
+		{"missing active key", "k2", map[string]string{"k1": valid}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewKeyring(tc.active, tc.keys); err == nil {
+				t.Error("NewKeyring() succeeded")
+			}
+		})
+	}
+}
+
+func TestCustomerKey(t *testing.T) {
+	ctx := context.Background()
+	key := randomBytes(t, encKeySize)
+	data := randomBytes(t, 2*encChunkSize+50)
+
+	r, err := EncryptWithKey(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(key []byte) (io.ReadSeekCloser, error) {
+		return DecryptWithKey(nopCloser{bytes.NewReader(sealed)}, key)
+	}
+	plain, err := open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.Seek(encChunkSize+1, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(plain); err != nil || !bytes.Equal(got, data[encChunkSize+1:]) {
+		t.Errorf("read after Seek = %d bytes, %v", len(got), err)
+	}
+
+	if _, err := open(randomBytes(t, encKeySize)); !errors.Is(err, ErrCorruptedBlob) {
+		t.Errorf("wrong key: error = %v, want ErrCorruptedBlob", err)
+	}
+	if _, err := open(key[:16]); !errors.Is(err, ErrInvalidCustomerKey) {
+		t.Errorf("short key: error = %v, want ErrInvalidCustomerKey", err)
+	}
+	if _, err := EncryptWithKey(bytes.NewReader(data), nil); !errors.Is(err, ErrInvalidCustomerKey) {
+		t.Errorf("EncryptWithKey without a key: error = %v, want ErrInvalidCustomerKey", err)
+	}
+
+	// Серверное шифрование оборачивает содержимое клиента, а без него отдает его как есть.
+	mem := NewMemoryBackend()
+	b := NewEncryptedBackend(mem, testKeyring(t, "k1", "k1"))
+	if _, err := b.Put(ctx, "wrapped", bytes.NewReader(sealed)); err != nil {
+		t.Fatal(err)
+	}
+	putRaw(t, mem, "customer", sealed)
+	for _, name := range []string{"wrapped", "customer"} {
+		got, err := readBlob(b, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, sealed) {
+			t.Errorf("%s: backend changed the customer-encrypted content", name)
+		}
+	}
+
+	// Блоб серверного шифрования не открывается ключом клиента.
+	if _, err := DecryptWithKey(nopCloser{bytes.NewReader(rawBlob(t, mem, "wrapped"))}, key); !errors.Is(err, ErrCorruptedBlob) {
+		t.Errorf("server-encrypted blob: error = %v, want ErrCorruptedBlob", err)
+	}
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, encKeySize))
+}
+
+// testKeyring возвращает Keyring с ключами ids, разными для каждого идентификатора.
+func testKeyring(t *testing.T, active string, ids ...string) *Keyring {
+	t.Helper()
+	keys := make(map[string]string, len(ids))
+	for i, id := range ids {
+		keys[id] = testKey(byte(i + 1))
+	}
+	k, err := NewKeyring(active, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// encryptedHeaderSize возвращает длину заголовка блоба key, зашифрованного ключами keys.
+func encryptedHeaderSize(t *testing.T, keys *Keyring, key string) int64 {
+	t.Helper()
+	mem := NewMemoryBackend()
+	if _, err := NewEncryptedBackend(mem, keys).Put(context.Background(), key, bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+	return blobHeader(t, mem, key).size
+}
+
+func blobHeader(t *testing.T, mem *MemoryBackend, key string) *header {
+	t.Helper()
+	h, err := readHeader(bytes.NewReader(rawBlob(t, mem, key)))
+	if err != nil || h == nil {
+		t.Fatalf("%s: header %v, %v", key, h, err)
+	}
+	return h
+}
+
+func readBlob(b BlobBackend, key string) ([]byte, error) {
+	r, err := b.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer func(r io.Closer) {
+		_ = r.Close()
+	}(r)
+	return io.ReadAll(r)
+}
+
+func rawBlob(t *testing.T, mem *MemoryBackend, key string) []byte {
+	t.Helper()
+	data, err := readBlob(mem, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Clone(data)
+}
+
+func putRaw(t *testing.T, mem *MemoryBackend, key string, data []byte) {
+	t.Helper()
+	if _, err := mem.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
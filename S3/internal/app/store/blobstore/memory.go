@@ -0,0 +1,126 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBackend хранит блобы в памяти процесса. Подходит для тестов
+// и локального запуска без диска; содержимое теряется при перезапуске.
+type MemoryBackend struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		blobs: make(map[string]memoryBlob),
+	}
+}
+
+func (b *MemoryBackend) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if key == "" {
+		return 0, ErrInvalidKey
+	}
+
+	data, err := io.ReadAll(ctxReader{ctx, r})
+	if err != nil {
+		return int64(len(data)), err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.blobs[key] = memoryBlob{data: data, modTime: time.Now()}
+
+	return int64(len(data)), nil
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	blob, ok := b.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	// Put всегда кладет новый срез, поэтому читать data без копирования безопасно.
+	return nopCloser{bytes.NewReader(blob.data)}, nil
+}
+
+func (b *MemoryBackend) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.blobs[key]; !ok {
+		return ErrNotFound
+	}
+	delete(b.blobs, key)
+
+	return nil
+}
+
+func (b *MemoryBackend) Rename(ctx context.Context, src, dst string) error {
+	if dst == "" {
+		return ErrInvalidKey
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blob, ok := b.blobs[src]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(b.blobs, src)
+	b.blobs[dst] = blob
+
+	return nil
+}
+
+func (b *MemoryBackend) Stat(ctx context.Context, key string) (Info, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	blob, ok := b.blobs[key]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+
+	return Info{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime}, nil
+}
+
+func (b *MemoryBackend) List(ctx context.Context, prefix string) ([]Info, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var infos []Info
+	for key, blob := range b.blobs {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, Info{Key: key, Size: int64(len(blob.data)), ModTime: blob.modTime})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
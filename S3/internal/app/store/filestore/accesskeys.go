@@ -0,0 +1,109 @@
+package filestore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+var ErrAccessKeyNotFound = errors.New("access key not found")
+
+// AccessKey — пара ключей для подписи запросов к S3-совместимому API (AWS Signature V4).
+type AccessKey struct {
+	AccessKey string    `json:"access_key"`
+	SecretKey string    `json:"secret_key,omitempty"`
+	UserID    int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (f *FileStore) CreateAccessKey(ctx context.Context, userID int) (*AccessKey, error) {
+	id := make([]byte, 12)
+	secret := make([]byte, 30)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	k := &AccessKey{
+		// Как и у AWS: 20 символов в верхнем регистре и 40-символьный секрет.
+		AccessKey: "GS" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(id)[:18],
+		SecretKey: base64.StdEncoding.EncodeToString(secret),
+		UserID:    userID,
+	}
+
+	if err := f.Files.QueryRowContext(ctx,
+		"INSERT INTO access_keys (access_key, secret_key, userid) VALUES ($1, $2, $3) RETURNING created_at",
+		k.AccessKey,
+		k.SecretKey,
+		k.UserID,
+	).Scan(&k.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (f *FileStore) FindAccessKey(ctx context.Context, accessKey string) (*AccessKey, error) {
+	k := &AccessKey{}
+	if err := f.Files.QueryRowContext(ctx,
+		"SELECT access_key, secret_key, userid, created_at FROM access_keys WHERE access_key = $1",
+		accessKey,
+	).Scan(&k.AccessKey, &k.SecretKey, &k.UserID, &k.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccessKeyNotFound
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// ListAccessKeys возвращает ключи пользователя без секретов.
+func (f *FileStore) ListAccessKeys(ctx context.Context, userID int) ([]AccessKey, error) {
+	rows, err := f.Files.QueryContext(ctx,
+		"SELECT access_key, created_at FROM access_keys WHERE userid = $1 ORDER BY created_at;",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	keys := []AccessKey{}
+	for rows.Next() {
+		k := AccessKey{UserID: userID}
+		if err := rows.Scan(&k.AccessKey, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (f *FileStore) DeleteAccessKey(ctx context.Context, userID int, accessKey string) error {
+	res, err := f.Files.ExecContext(ctx,
+		"DELETE FROM access_keys WHERE userid = $1 AND access_key = $2",
+		userID,
+		accessKey,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAccessKeyNotFound
+	}
+	return nil
+}
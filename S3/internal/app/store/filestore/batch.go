@@ -0,0 +1,310 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const (
+	BatchDelete  = "delete"
+	BatchShare   = "share"
+	BatchUnshare = "unshare"
+	BatchMove    = "move"
+	BatchTag     = "tag"
+)
+
+// MaxBatchOps — наибольшее число операций в одном пакете.
+const MaxBatchOps = 1000
+
+var (
+	ErrTooManyOps = errors.New("too many operations in batch")
+	ErrUnknownOp  = errors.New("op must be delete, share, unshare, move or tag")
+)
+
+// errNeedsBlobs — операцию нельзя выполнить в транзакции пакета: вместе со строкой файла
+// в хранилище переезжает его содержимое.
+var errNeedsBlobs = errors.New("operation moves content in blob storage")
+
+// BatchOp — операция пакета над файлом Filename бакета Bucket.
+type BatchOp struct {
+	Op       string
+	Bucket   string
+	Filename string
+	// Permanent — delete удаляет файл насовсем, минуя корзину.
+	Permanent bool
+	// DstBucket, DstFilename и Overwrite — куда move переносит файл.
+	DstBucket   string
+	DstFilename string
+	Overwrite   bool
+	// Share — ограничения ссылки, которую создает share.
+	Share ShareOptions
+	// ShareID — ссылка, которую отзывает unshare; без него отзываются все действующие
+	// ссылки на файл (или на папку, если Share.Folder).
+	ShareID string
+	// Tags — новый набор тегов для tag; пустой удаляет теги.
+	Tags map[string]string
+}
+
+// BatchResult — итог операции пакета. Если Err не nil, остальные поля пусты.
+type BatchResult struct {
+	// Trash — запись корзины, куда delete перенес файл.
+	Trash *TrashItem
+	// VersionID — маркер удаления, который delete добавил в бакете с версионированием.
+	VersionID string
+	Share     *Share
+	// Revoked — сколько ссылок отозвал unshare.
+	Revoked int
+	Err     error
+}
+
+// Batch выполняет операции по порядку и возвращает итог каждой. Операции, которым хватает
+// базы, — теги, ссылки, удаление и перенос файлов, хранящихся по хешу, в бакетах без
+// версионирования — идут в одной транзакции, каждая под своей точкой сохранения, так что
+// ошибка одной не отменяет остальные. Операция, которой нужно переносить содержимое
+// в хранилище, фиксирует накопленную транзакцию и выполняется отдельно, как одиночный вызов.
+// Ошибка возвращается, если пакет не принят целиком, или вместе с итогами, если не удалось
+// убрать освободившееся содержимое.
+func (f *FileStore) Batch(ctx context.Context, userID int, ops []BatchOp) ([]BatchResult, error) {
+	if len(ops) > MaxBatchOps {
+		return nil, ErrTooManyOps
+	}
+
+	b := &batch{f: f, userID: userID, versioned: make(map[string]bool), results: make([]BatchResult, len(ops))}
+	defer b.rollback()
+	for i := range ops {
+		inTx, err := b.transactional(ctx, &ops[i])
+		if err != nil {
+			b.results[i].Err = err
+			continue
+		}
+		if inTx {
+			if err := b.runInTx(ctx, i, &ops[i]); !errors.Is(err, errNeedsBlobs) {
+				if err != nil {
+					b.results[i] = BatchResult{Err: err}
+				}
+				continue
+			}
+		}
+
+		// Порядок операций сохраняется: все предыдущие фиксируются раньше этой.
+		b.commit(ctx)
+		b.results[i] = f.runBatchOp(ctx, userID, &ops[i])
+	}
+	b.commit(ctx)
+
+	if !b.freed {
+		return b.results, nil
+	}
+	return b.results, f.freeBlobs(ctx)
+}
+
+type batch struct {
+	f         *FileStore
+	userID    int
+	versioned map[string]bool
+	results   []BatchResult
+
+	tx *sql.Tx
+	// done — операции, выполненные в текущей транзакции: если она не зафиксируется,
+	// их итог меняется на ошибку.
+	done []int
+	// blobKeys — содержимое файлов, удаленных насовсем, которое убирается после фиксации.
+	blobKeys []string
+	freed    bool
+}
+
+// transactional сообщает, может ли операция выполниться в транзакции пакета.
+func (b *batch) transactional(ctx context.Context, op *BatchOp) (bool, error) {
+	switch op.Op {
+	case BatchShare, BatchUnshare, BatchTag:
+		return true, nil
+	case BatchDelete:
+		// В бакете с версионированием текущее содержимое сначала копируется в версии.
+		versioned, err := b.bucketVersioned(ctx, op.Bucket)
+		return !versioned, err
+	case BatchMove:
+		if op.Overwrite {
+			return false, nil
+		}
+		versioned, err := b.bucketVersioned(ctx, op.Bucket)
+		if err != nil || versioned {
+			return false, err
+		}
+		versioned, err = b.bucketVersioned(ctx, op.DstBucket)
+		return !versioned, err
+	}
+	return false, ErrUnknownOp
+}
+
+func (b *batch) bucketVersioned(ctx context.Context, bucket string) (bool, error) {
+	if v, ok := b.versioned[bucket]; ok {
+		return v, nil
+	}
+	v, err := b.f.VersioningEnabled(ctx, b.userID, bucket)
+	if err != nil {
+		return false, err
+	}
+	b.versioned[bucket] = v
+	return v, nil
+}
+
+// runInTx выполняет операцию i под точкой сохранения и при ошибке откатывает только ее.
+func (b *batch) runInTx(ctx context.Context, i int, op *BatchOp) error {
+	if b.tx == nil {
+		tx, err := b.f.Files.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		b.tx = tx
+	}
+
+	if _, err := b.tx.ExecContext(ctx, "SAVEPOINT batch_op"); err != nil {
+		return err
+	}
+	res, err := b.apply(ctx, op)
+	if err != nil {
+		if _, rbErr := b.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_op"); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	if _, err := b.tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_op"); err != nil {
+		return err
+	}
+	b.results[i] = res
+	b.done = append(b.done, i)
+	return nil
+}
+
+// apply выполняет операцию в транзакции пакета.
+func (b *batch) apply(ctx context.Context, op *BatchOp) (BatchResult, error) {
+	f, tx := b.f, b.tx
+	switch op.Op {
+	case BatchShare:
+		s, err := f.share(ctx, tx, b.userID, op.Bucket, op.Filename, op.Share)
+		return BatchResult{Share: s}, err
+
+	case BatchUnshare:
+		if op.ShareID != "" {
+			return BatchResult{Revoked: 1}, f.revokeShare(ctx, tx, b.userID, op.ShareID)
+		}
+		filename := op.Filename
+		if op.Share.Folder {
+			filename = strings.TrimSuffix(filename, "/") + "/"
+		}
+		n, err := f.revokeFileShares(ctx, tx, b.userID, op.Bucket, filename)
+		if err == nil && n == 0 {
+			err = ErrShareNotFound
+		}
+		return BatchResult{Revoked: n}, err
+
+	case BatchTag:
+		return BatchResult{}, f.setTags(ctx, tx, b.userID, op.Bucket, op.Filename, op.Tags)
+
+	case BatchDelete:
+		if op.Permanent {
+			found, err := f.deleteFile(ctx, tx, b.userID, op.Bucket, op.Filename)
+			if err != nil {
+				return BatchResult{}, err
+			}
+			if !found {
+				return BatchResult{}, f.notFound(ctx, b.userID, op.Bucket)
+			}
+			b.blobKeys = append(b.blobKeys, blobKey(b.userID, op.Bucket, op.Filename))
+			return BatchResult{}, nil
+		}
+		row, err := f.lockFile(ctx, tx, b.userID, op.Bucket, op.Filename)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		if !row.hash.Valid {
+			return BatchResult{}, errNeedsBlobs
+		}
+		item, err := f.moveToTrash(ctx, tx, b.userID, op.Bucket, op.Filename, row.versionID)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		f.setTrashExpiry(item)
+		return BatchResult{Trash: item}, nil
+
+	case BatchMove:
+		if op.Bucket == op.DstBucket && op.Filename == op.DstFilename {
+			return BatchResult{}, ErrSameObject
+		}
+		if err := f.checkDestination(ctx, b.userID, op.DstBucket, op.DstFilename, false); err != nil {
+			return BatchResult{}, err
+		}
+		hash, err := f.moveFile(ctx, tx, b.userID, op.Bucket, op.Filename, op.DstBucket, op.DstFilename, false)
+		if err == nil && !hash.Valid {
+			err = errNeedsBlobs
+		}
+		return BatchResult{}, err
+	}
+	return BatchResult{}, ErrUnknownOp
+}
+
+// commit фиксирует накопленную транзакцию и убирает содержимое удаленных в ней файлов.
+func (b *batch) commit(ctx context.Context) {
+	if b.tx == nil {
+		return
+	}
+	err := b.tx.Commit()
+	b.tx = nil
+	if err != nil {
+		for _, i := range b.done {
+			b.results[i] = BatchResult{Err: err}
+		}
+	} else {
+		for _, key := range b.blobKeys {
+			// Не удаленное здесь содержимое без строки файла уже недоступно и только занимает место.
+			_ = b.f.Blobs.Delete(ctx, key)
+		}
+		b.freed = b.freed || len(b.done) > 0
+	}
+	b.done, b.blobKeys = nil, nil
+}
+
+func (b *batch) rollback() {
+	if b.tx != nil {
+		_ = b.tx.Rollback()
+	}
+}
+
+// runBatchOp выполняет операцию отдельно от транзакции пакета теми же методами,
+// что и одиночные запросы.
+func (f *FileStore) runBatchOp(ctx context.Context, userID int, op *BatchOp) BatchResult {
+	switch op.Op {
+	case BatchDelete:
+		versioned, err := f.VersioningEnabled(ctx, userID, op.Bucket)
+		if err != nil {
+			return BatchResult{Err: err}
+		}
+		if !versioned {
+			item, err := f.Trash(ctx, userID, op.Bucket, op.Filename)
+			return BatchResult{Trash: item, Err: err}
+		}
+		if _, exists, err := f.currentVersion(ctx, userID, op.Bucket, op.Filename); err != nil || !exists {
+			if err == nil {
+				err = f.notFound(ctx, userID, op.Bucket)
+			}
+			return BatchResult{Err: err}
+		}
+		versionID, err := f.DeleteVersioned(ctx, userID, op.Bucket, op.Filename)
+		return BatchResult{VersionID: versionID, Err: err}
+
+	case BatchMove:
+		_, err := f.Move(ctx, userID, op.Bucket, op.Filename, op.DstBucket, op.DstFilename, op.Overwrite)
+		return BatchResult{Err: err}
+	}
+	return BatchResult{Err: ErrUnknownOp}
+}
+
+// notFound возвращает ErrBucketNotFound, если нет бакета, иначе ErrObjectNotFound.
+func (f *FileStore) notFound(ctx context.Context, userID int, bucket string) error {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return err
+	}
+	return ErrObjectNotFound
+}
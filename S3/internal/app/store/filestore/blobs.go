@@ -0,0 +1,286 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// При FileStore.Dedup содержимое файлов хранится по SHA-256 под ключом cas/<hash>: одинаковое содержимое
+// файлов, версий и корзины разных пользователей записывается один раз. Таблица blobs
+// считает ссылающиеся на него строки (триггерами на files, file_versions, trash и таблицы
+// фрагментов из chunks.go), а freeBlobs удаляет содержимое, на которое ссылок не осталось.
+// Содержимое, зашифрованное ключом клиента, записанное без Dedup и файлы, записанные раньше,
+// лежат под ключами самих файлов, а их blob_hash — NULL.
+
+// commitObject фиксирует содержимое, записанное putBlob, и в той же транзакции вызывает
+// insert, добавляющий строки со ссылкой на него. Если такое содержимое уже хранится,
+// записанная копия удаляется, а объект получает сжатие и размер хранимой. Содержимое,
+// записанное по фрагментам, регистрируется из них, и фрагменты перестают удерживаться
+// за пользователем. Содержимое не по хешу переносится под ключ файла o.dest только
+// после фиксации: если запись не удалась, прежнее содержимое файла остается на месте.
+// Квота владельца файла, записанного putBlob, перепроверяется в той же транзакции.
+func (f *FileStore) commitObject(ctx context.Context, o *Object, insert func(tx *sql.Tx) error) error {
+	if o.staged != "" {
+		defer func() {
+			_ = f.Blobs.Delete(context.Background(), o.staged)
+		}()
+	}
+	committed := false
+	if o.chunks != nil {
+		// Фрагменты несостоявшейся записи больше не нужны.
+		defer func() {
+			if !committed {
+				_ = f.releaseChunks(context.Background(), o.uploader, o.chunks)
+			}
+		}()
+	}
+
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	quota, err := f.lockQuota(ctx, tx, o)
+	if err != nil {
+		return err
+	}
+	created := false
+	switch {
+	case o.dest != "":
+	case o.staged != "":
+		if created, err = f.claimBlob(ctx, tx, o); err != nil {
+			return err
+		}
+	case len(o.chunks) > 1:
+		if err := f.claimManifest(ctx, tx, o); err != nil {
+			return err
+		}
+	}
+	err = insert(tx)
+	if err == nil && quota != nil {
+		err = f.checkQuota(ctx, tx, quota)
+	}
+	if err != nil {
+		if created {
+			// Строка blobs еще заблокирована, поэтому параллельная загрузка того же содержимого
+			// не успеет записать его заново до удаления.
+			_ = f.Blobs.Delete(context.Background(), casKey(o.BlobHash))
+		}
+		return err
+	}
+	if o.chunks == nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if o.dest == "" {
+			return nil
+		}
+		// Строка уже описывает новое содержимое, поэтому перенос не должен прерваться вместе с запросом.
+		return f.Blobs.Rename(context.WithoutCancel(ctx), o.staged, o.dest)
+	}
+
+	// Теперь на фрагменты ссылается сам объект.
+	if err := releaseChunksTx(ctx, tx, o.uploader, o.chunks); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return f.freeBlobs(ctx)
+}
+
+// claimBlob регистрирует содержимое объекта в blobs и переносит его под ключ хеша,
+// если такого содержимого еще нет. Возвращает true, если содержимое добавлено.
+func (f *FileStore) claimBlob(ctx context.Context, tx *sql.Tx, o *Object) (bool, error) {
+	for {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO blobs (hash, size, stored_size, compression) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (hash) DO NOTHING`,
+			o.BlobHash,
+			o.Size,
+			o.StoredSize,
+			o.compression(),
+		)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		if n == 1 {
+			return true, f.Blobs.Rename(ctx, o.staged, casKey(o.BlobHash))
+		}
+
+		var (
+			stored      int64
+			compression sql.NullString
+		)
+		err = tx.QueryRowContext(ctx,
+			"SELECT stored_size, compression FROM blobs WHERE hash = $1 FOR UPDATE;",
+			o.BlobHash,
+		).Scan(&stored, &compression)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Содержимое без ссылок только что удалили — добавляем его заново.
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+
+		// Если удаление содержимого прервалось после удаления из хранилища, его заменяет новая копия.
+		_, err = f.Blobs.Stat(ctx, casKey(o.BlobHash))
+		if errors.Is(err, blobstore.ErrNotFound) {
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE blobs SET stored_size = $2, compression = $3 WHERE hash = $1",
+				o.BlobHash,
+				o.StoredSize,
+				o.compression(),
+			); err != nil {
+				return false, err
+			}
+			return false, f.Blobs.Rename(ctx, o.staged, casKey(o.BlobHash))
+		}
+		if err != nil {
+			return false, err
+		}
+
+		o.StoredSize, o.Compression = stored, compression.String
+		return false, nil
+	}
+}
+
+// freeBlobs удаляет содержимое, на которое не ссылается ни один файл, версия, файл в корзине
+// или другое содержимое. Удаление содержимого из фрагментов освобождает и фрагменты,
+// поэтому проходы повторяются, пока что-то удаляется.
+func (f *FileStore) freeBlobs(ctx context.Context) error {
+	for {
+		freed, err := f.freeUnreferenced(ctx)
+		if err != nil || !freed {
+			return err
+		}
+	}
+}
+
+func (f *FileStore) freeUnreferenced(ctx context.Context) (bool, error) {
+	rows, err := f.Files.QueryContext(ctx, "SELECT hash FROM blobs WHERE refs = 0")
+	if err != nil {
+		return false, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	freed := false
+	for _, hash := range hashes {
+		ok, err := f.freeBlob(ctx, hash)
+		if err != nil {
+			return false, err
+		}
+		freed = freed || ok
+	}
+	return freed, nil
+}
+
+// freeBlob удаляет содержимое hash, если ссылок на него так и нет, и сообщает, удалено ли оно.
+func (f *FileStore) freeBlob(ctx context.Context, hash string) (bool, error) {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var chunked bool
+	err = tx.QueryRowContext(ctx, "DELETE FROM blobs WHERE hash = $1 AND refs = 0 RETURNING chunked", hash).Scan(&chunked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// Содержимое удаляется до фиксации, пока строка заблокирована: иначе его могла бы
+	// успеть заново зарегистрировать параллельная загрузка того же содержимого.
+	// У содержимого из фрагментов своего нет: его фрагменты освобождаются каскадно.
+	if !chunked {
+		if err := f.Blobs.Delete(ctx, casKey(hash)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// contentKey — ключ, под которым хранится содержимое объекта: ключ хеша или,
+// для содержимого вне хранения по хешу, key.
+func (o *Object) contentKey(key string) string {
+	if o.BlobHash != "" {
+		return casKey(o.BlobHash)
+	}
+	return key
+}
+
+// blobHash готовит хеш содержимого для колонки; содержимое под ключом файла хранится с NULL.
+func (o *Object) blobHash() sql.NullString {
+	return sql.NullString{String: o.BlobHash, Valid: o.BlobHash != ""}
+}
+
+// casKey — ключ содержимого с SHA-256 hash. Первые два символа хеша разносят
+// содержимое по каталогам.
+func casKey(hash string) string {
+	return fmt.Sprintf("cas/%s/%s", hash[:2], hash)
+}
+
+// deleteStaleStaging удаляет содержимое под временными ключами старше ttl: его оставили
+// записи, прерванные вместе с сервером.
+func (f *FileStore) deleteStaleStaging(ctx context.Context, ttl time.Duration) error {
+	staged, err := f.Blobs.List(ctx, "staging/")
+	if err != nil {
+		return err
+	}
+	for _, info := range staged {
+		if time.Since(info.ModTime) < ttl {
+			continue
+		}
+		if err := f.Blobs.Delete(ctx, info.Key); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagingKey — временный ключ, под которым содержимое пользователя userID пишется,
+// пока его хеш неизвестен или пока не зафиксирована запись файла.
+func stagingKey(userID int) string {
+	return fmt.Sprintf("staging/%d/%s", userID, rand.Text())
+}
+
+// dropReplaced убирает то, что осталось от перезаписанного содержимого файла после записи o:
+// содержимое под ключом файла, если новое хранится по хешу (старое к этому времени уже
+// скопировано в версии, если нужно), и содержимое по хешу, на которое не осталось ссылок.
+func (f *FileStore) dropReplaced(ctx context.Context, userID int, bucket, filename string, o *Object) error {
+	if o.BlobHash != "" {
+		if err := f.Blobs.Delete(ctx, blobKey(userID, bucket, filename)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return err
+		}
+	}
+	return f.freeBlobs(ctx)
+}
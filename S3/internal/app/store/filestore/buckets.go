@@ -0,0 +1,392 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrBucketAlreadyExists = errors.New("bucket already exists")
+	ErrBucketNotFound      = errors.New("bucket not found")
+	ErrBucketNotEmpty      = errors.New("bucket is not empty")
+	ErrObjectNotFound      = errors.New("object not found")
+	ErrObjectAlreadyExists = errors.New("object already exists")
+	ErrPathConflict        = errors.New("path conflicts with an existing file or folder")
+)
+
+type Bucket struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Object struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	SHA256       string
+	// ETag — ETag объекта в формате S3 без кавычек или "", если он не сохранен.
+	ETag         string
+	Metadata     map[string]string
+	LastModified time.Time
+	UploadedAt   time.Time
+	// KeyFingerprint — отпечаток ключа клиента, которым зашифровано содержимое, или "".
+	KeyFingerprint string
+	// Compression — алгоритм, которым сжато содержимое в хранилище, или "".
+	Compression string
+	// StoredSize — сколько содержимое занимает в хранилище; Size — размер самого файла.
+	StoredSize int64
+	// BlobHash — SHA-256, под которым хранится содержимое, или "", если оно лежит под ключом файла.
+	BlobHash string
+
+	// staged — временный ключ содержимого, записанного putBlob, до commitObject.
+	staged string
+	// dest — ключ файла, под который commitObject переносит staged после фиксации,
+	// если содержимое хранится не по хешу.
+	dest string
+	// chunks — фрагменты содержимого, записанного putBlob по частям; до commitObject
+	// их удерживает за собой пользователь uploader.
+	chunks   []chunk
+	uploader int
+	// owner и bucket — чей и в каком бакете файл записан putBlob; 0 — не файл.
+	owner  int
+	bucket string
+}
+
+// listPageSize — сколько строк ListObjects читает за запрос, если размер страницы не ограничен.
+const listPageSize = 1000
+
+// ObjectList — страница листинга. Ключи, содержащие delimiter после prefix,
+// сворачиваются в CommonPrefixes, как в S3.
+type ObjectList struct {
+	Objects        []Object
+	CommonPrefixes []string
+	IsTruncated    bool
+	// NextStartAfter передается в следующий вызов ListObjects как startAfter.
+	NextStartAfter string
+}
+
+func (f *FileStore) CreateBucket(ctx context.Context, userID int, name string) error {
+	_, err := f.Files.ExecContext(ctx, "INSERT INTO buckets (userid, name) VALUES ($1, $2)", userID, name)
+	if isUniqueViolation(err) {
+		return ErrBucketAlreadyExists
+	}
+	return err
+}
+
+func (f *FileStore) ListBuckets(ctx context.Context, userID int) ([]Bucket, error) {
+	rows, err := f.Files.QueryContext(ctx, "SELECT name, created_at FROM buckets WHERE userid = $1 ORDER BY name;", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var buckets []Bucket
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Name, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, rows.Err()
+}
+
+// DeleteBucket удаляет пустой бакет. Бакет со старыми версиями файлов пустым не считается.
+func (f *FileStore) DeleteBucket(ctx context.Context, userID int, name string) error {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var notEmpty bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2)
+		OR EXISTS (SELECT 1 FROM file_versions WHERE userid = $1 AND bucket = $2);`,
+		userID,
+		name,
+	).Scan(&notEmpty); err != nil {
+		return err
+	}
+	if notEmpty {
+		return ErrBucketNotEmpty
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM buckets WHERE userid = $1 AND name = $2", userID, name)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrBucketNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM bucket_versioning WHERE userid = $1 AND bucket = $2", userID, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (f *FileStore) BucketExists(ctx context.Context, userID int, name string) (bool, error) {
+	var exists bool
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM buckets WHERE userid = $1 AND name = $2);",
+		userID,
+		name,
+	).Scan(&exists)
+	return exists, err
+}
+
+// PutObject записывает объект в бакет, перезаписывая существующий с тем же ключом,
+// и возвращает его метаданные. В бакете с версионированием перезапись создает новую версию.
+func (f *FileStore) PutObject(ctx context.Context, userID int, bucket, key string, r io.Reader, meta Metadata) (*Object, error) {
+	if err := f.requireBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+	return f.writeObject(ctx, userID, bucket, key, r, meta)
+}
+
+// writeObject записывает файл с перезаписью по правилам версионирования бакета.
+// Существование бакета проверяет вызывающий.
+func (f *FileStore) writeObject(ctx context.Context, userID int, bucket, key string, r io.Reader, meta Metadata) (*Object, error) {
+	versioned, err := f.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if versioned {
+		if _, err := f.SaveVersion(ctx, userID, bucket, key, r, meta); err != nil {
+			return nil, err
+		}
+		return f.Head(ctx, userID, bucket, key)
+	}
+
+	// Версионирование приостановлено: перезаписываемая версия остается в истории.
+	if err := f.archiveCurrent(ctx, userID, bucket, key, false); err != nil {
+		return nil, err
+	}
+	o, err := f.putBlob(ctx, userID, bucket, key, r, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := metadataJSON(o.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	err = f.commitObject(ctx, o, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO files (userid, bucket, filename, size, content_type, sha256, metadata,
+				key_fingerprint, compression, stored_size, blob_hash, etag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (userid, bucket, filename) DO UPDATE SET uploaded_at = now(), version_id = NULL,
+			size = excluded.size, content_type = excluded.content_type, sha256 = excluded.sha256, metadata = excluded.metadata,
+			key_fingerprint = excluded.key_fingerprint, compression = excluded.compression, stored_size = excluded.stored_size,
+			blob_hash = excluded.blob_hash, etag = excluded.etag`,
+			userID,
+			bucket,
+			key,
+			o.Size,
+			o.ContentType,
+			o.SHA256,
+			metadata,
+			o.keyFingerprint(),
+			o.compression(),
+			o.StoredSize,
+			o.blobHash(),
+			o.etagColumn(),
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := f.dropReplaced(ctx, userID, bucket, key, o); err != nil {
+		return nil, err
+	}
+
+	return f.Head(ctx, userID, bucket, key)
+}
+
+// DeleteObject удаляет объект. Отсутствие объекта ошибкой не считается, как и в S3.
+// В бакете с версионированием вместо удаления добавляется маркер удаления.
+func (f *FileStore) DeleteObject(ctx context.Context, userID int, bucket, key string) error {
+	if err := f.requireBucket(ctx, userID, bucket); err != nil {
+		return err
+	}
+
+	versioned, err := f.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return err
+	}
+	if versioned {
+		_, err := f.DeleteVersioned(ctx, userID, bucket, key)
+		return err
+	}
+	return f.Delete(ctx, userID, bucket, key)
+}
+
+// ListObjects возвращает до limit объектов и общих префиксов бакета с ключами,
+// начинающимися с prefix и идущими после startAfter, в лексикографическом порядке.
+// limit <= 0 снимает ограничение. Файлы читаются из базы страницами; страница, которая
+// кончилась внутри общего префикса, продолжается сразу после него.
+func (f *FileStore) ListObjects(ctx context.Context, userID int, bucket, prefix, delimiter, startAfter string, limit int) (*ObjectList, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+
+	// Каждый элемент страницы занимает хотя бы одну строку, поэтому без сворачивания
+	// в общие префиксы хватает limit+1 строк.
+	pageSize := listPageSize
+	if limit > 0 {
+		pageSize = limit + 1
+	}
+
+	list := &ObjectList{}
+	sized := make(map[string]bool)
+	count := 0
+	after := startAfter
+	commonPrefixOf := func(key string) string {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				return key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		return ""
+	}
+pages:
+	for {
+		page, err := f.listPage(ctx, userID, bucket, prefix, after, pageSize, sized)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range page {
+			commonPrefix := commonPrefixOf(o.Key)
+			if commonPrefix != "" && len(list.CommonPrefixes) > 0 && list.CommonPrefixes[len(list.CommonPrefixes)-1] == commonPrefix {
+				continue
+			}
+
+			if limit > 0 && count == limit {
+				list.IsTruncated = true
+				break pages
+			}
+			count++
+
+			if commonPrefix != "" {
+				list.CommonPrefixes = append(list.CommonPrefixes, commonPrefix)
+				// Следующая страница должна пропустить все ключи внутри этого префикса.
+				list.NextStartAfter = commonPrefix + string(utf8.MaxRune)
+				continue
+			}
+			list.Objects = append(list.Objects, o)
+			list.NextStartAfter = o.Key
+		}
+		if len(page) < pageSize {
+			break
+		}
+
+		// Если страница кончилась внутри общего префикса, остальные его ключи не читаются.
+		after = page[len(page)-1].Key
+		if commonPrefix := commonPrefixOf(after); commonPrefix != "" {
+			after = commonPrefix + string(utf8.MaxRune)
+		}
+	}
+	if !list.IsTruncated {
+		list.NextStartAfter = ""
+	}
+
+	objects := list.Objects[:0]
+	for _, o := range list.Objects {
+		if err := f.statBlob(ctx, blobKey(userID, bucket, o.Key), &o, sized[o.Key]); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	list.Objects = objects
+
+	return list, nil
+}
+
+// listPage читает до n файлов бакета с ключами, начинающимися с prefix и идущими после after,
+// и отмечает в sized, записан ли в базе их размер.
+func (f *FileStore) listPage(ctx context.Context, userID int, bucket, prefix, after string, n int, sized map[string]bool) ([]Object, error) {
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT filename, uploaded_at, size, content_type, sha256, etag, metadata, blob_hash FROM files
+		WHERE userid = $1 AND bucket = $2 AND starts_with(filename, $3) AND filename COLLATE "C" > $4
+		ORDER BY filename COLLATE "C" LIMIT $5`,
+		userID,
+		bucket,
+		prefix,
+		after,
+		n,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var page []Object
+	for rows.Next() {
+		var (
+			o           Object
+			size        sql.NullInt64
+			contentType sql.NullString
+			sum         sql.NullString
+			etag        sql.NullString
+			metadata    []byte
+			hash        sql.NullString
+		)
+		if err := rows.Scan(&o.Key, &o.UploadedAt, &size, &contentType, &sum, &etag, &metadata, &hash); err != nil {
+			return nil, err
+		}
+		if err := o.fill(size, contentType, sum, etag, metadata); err != nil {
+			return nil, err
+		}
+		o.BlobHash = hash.String
+		sized[o.Key] = size.Valid
+		page = append(page, o)
+	}
+	return page, rows.Err()
+}
+
+func (f *FileStore) requireBucket(ctx context.Context, userID int, bucket string) error {
+	exists, err := f.BucketExists(ctx, userID, bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBucketNotFound
+	}
+	return nil
+}
+
+// checkBucket проверяет существование бакета; "" — корневое пространство пользователя, оно есть всегда.
+func (f *FileStore) checkBucket(ctx context.Context, userID int, bucket string) error {
+	if bucket == "" {
+		return nil
+	}
+	return f.requireBucket(ctx, userID, bucket)
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
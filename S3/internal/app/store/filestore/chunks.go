@@ -0,0 +1,545 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Файлы делятся на фрагменты по содержимому (blobstore.Chunker), и каждый фрагмент хранится
+// по своему SHA-256 так же, как содержимое целых файлов, поэтому после небольшого изменения
+// большого файла записываются только изменившиеся фрагменты. Содержимое из нескольких
+// фрагментов — строка blobs с chunked = true и списком фрагментов в blob_chunks; файл из одного
+// фрагмента хранится как обычное содержимое по хешу. Клиент может загрузить недостающие
+// фрагменты сам (PutChunk) и собрать из них файл (AssembleChunks), не передавая остальные.
+
+var (
+	ErrChunkingDisabled  = errors.New("chunked uploads are disabled")
+	ErrChunkNotFound     = errors.New("one or more of the specified chunks have not been uploaded")
+	ErrChunkTooLarge     = errors.New("chunk is larger than the maximum chunk size")
+	ErrChunkHashMismatch = errors.New("chunk content does not match its hash")
+)
+
+// ChunkAlgorithm — алгоритм деления на фрагменты, описанный в blobstore.Chunker.
+const ChunkAlgorithm = "fastcdc-gear-sha256"
+
+// Chunking — параметры деления на фрагменты: клиент делит файлы так же, чтобы его
+// фрагменты совпадали с уже хранящимися.
+type Chunking struct {
+	Algorithm string `json:"algorithm"`
+	AvgSize   int64  `json:"avg_size"`
+	MinSize   int64  `json:"min_size"`
+	MaxSize   int64  `json:"max_size"`
+}
+
+// chunk — фрагмент содержимого, start — его смещение в содержимом.
+type chunk struct {
+	hash        string
+	start       int64
+	size        int64
+	storedSize  int64
+	compression string
+}
+
+// ownedChunksQuery выбирает из хешей $2 фрагменты, доступные пользователю $1: загруженные
+// им и еще не собранные или входящие в содержимое его файлов, версий и корзины. Чужие
+// фрагменты недоступны: иначе по ответу можно было бы узнать, что кто-то хранит известный файл.
+const ownedChunksQuery = `WITH owned AS (
+	SELECT blob_hash FROM files WHERE userid = $1
+	UNION SELECT blob_hash FROM file_versions WHERE userid = $1
+	UNION SELECT blob_hash FROM trash WHERE userid = $1
+)
+SELECT b.hash, b.size, COALESCE(b.compression, '') FROM blobs b
+WHERE b.hash = ANY($2) AND NOT b.chunked AND (
+	EXISTS (SELECT 1 FROM user_chunks u WHERE u.userid = $1 AND u.blob_hash = b.hash)
+	OR b.hash IN (SELECT blob_hash FROM owned)
+	OR EXISTS (SELECT 1 FROM blob_chunks c WHERE c.blob_hash = b.hash AND c.manifest IN (SELECT blob_hash FROM owned)))`
+
+// Chunking возвращает параметры деления на фрагменты.
+func (f *FileStore) Chunking() (*Chunking, error) {
+	if f.ChunkSize <= 0 {
+		return nil, ErrChunkingDisabled
+	}
+	minSize, maxSize := blobstore.ChunkBounds(int(f.ChunkSize))
+	return &Chunking{
+		Algorithm: ChunkAlgorithm,
+		AvgSize:   f.ChunkSize,
+		MinSize:   int64(minSize),
+		MaxSize:   int64(maxSize),
+	}, nil
+}
+
+// MissingChunks возвращает хеши из hashes, фрагментов с которыми у пользователя нет:
+// их нужно загрузить через PutChunk перед AssembleChunks.
+func (f *FileStore) MissingChunks(ctx context.Context, userID int, hashes []string) ([]string, error) {
+	if f.ChunkSize <= 0 {
+		return nil, ErrChunkingDisabled
+	}
+	owned, err := f.ownedChunks(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	seen := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		if _, ok := owned[hash]; !ok && !seen[hash] {
+			missing = append(missing, hash)
+		}
+		seen[hash] = true
+	}
+	return missing, nil
+}
+
+// PutChunk записывает фрагмент с SHA-256 hash и возвращает его размер. Фрагмент остается
+// за пользователем, пока не войдет в файл, но не дольше срока незавершенных загрузок.
+// Как и части составных загрузок, такие фрагменты учитываются в квоте только при
+// загрузке новых фрагментов.
+func (f *FileStore) PutChunk(ctx context.Context, userID int, hash string, r io.Reader) (int64, error) {
+	if f.ChunkSize <= 0 {
+		return 0, ErrChunkingDisabled
+	}
+
+	var pending int64
+	if err := f.Files.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(b.size), 0) FROM user_chunks u JOIN blobs b ON b.hash = u.blob_hash
+		WHERE u.userid = $1 AND u.blob_hash <> $2`,
+		userID,
+		hash,
+	).Scan(&pending); err != nil {
+		return 0, err
+	}
+	r, err := f.limitToQuota(ctx, userID, "", "", pending, r)
+	if err != nil {
+		return 0, err
+	}
+
+	_, maxSize := blobstore.ChunkBounds(int(f.ChunkSize))
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > maxSize {
+		return 0, ErrChunkTooLarge
+	}
+	sum := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(sum[:]), hash) {
+		return 0, ErrChunkHashMismatch
+	}
+
+	c, err := f.putChunk(ctx, userID, data, f.compressionFor("", Metadata{}, data))
+	if err != nil {
+		return 0, err
+	}
+	return c.size, nil
+}
+
+// AssembleChunks проверяет, что все фрагменты hashes доступны пользователю, и возвращает
+// reader, последовательно читающий их содержимое. Файл из него записывается как обычно,
+// и уже хранящиеся фрагменты при этом не записываются заново.
+func (f *FileStore) AssembleChunks(ctx context.Context, userID int, hashes []string) (io.ReadCloser, error) {
+	if f.ChunkSize <= 0 {
+		return nil, ErrChunkingDisabled
+	}
+	owned, err := f.ownedChunks(ctx, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]chunk, len(hashes))
+	var start int64
+	for i, hash := range hashes {
+		c, ok := owned[hash]
+		if !ok {
+			return nil, ErrChunkNotFound
+		}
+		c.start = start
+		start += c.size
+		chunks[i] = c
+	}
+	return &chunkReader{ctx: ctx, blobs: f.Blobs, chunks: chunks, size: start}, nil
+}
+
+func (f *FileStore) ownedChunks(ctx context.Context, userID int, hashes []string) (map[string]chunk, error) {
+	rows, err := f.Files.QueryContext(ctx, ownedChunksQuery, userID, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	owned := make(map[string]chunk)
+	for rows.Next() {
+		var c chunk
+		if err := rows.Scan(&c.hash, &c.size, &c.compression); err != nil {
+			return nil, err
+		}
+		owned[c.hash] = c
+	}
+	return owned, rows.Err()
+}
+
+// putChunks делит содержимое in на фрагменты, записывает их и возвращает метаданные файла.
+// Фрагменты удерживаются за пользователем до commitObject, а если запись не удалась — отпускаются.
+func (f *FileStore) putChunks(ctx context.Context, userID int, filename string, in *inspector, meta Metadata) (*Object, error) {
+	var (
+		chunks      []chunk
+		compression string
+		start       int64
+		stored      int64
+	)
+	seen := make(map[string]bool)
+	chunker := blobstore.NewChunker(in, int(f.ChunkSize))
+	for {
+		data, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil && chunks == nil {
+			compression = f.compressionFor(filename, meta, data)
+		}
+		var c chunk
+		if err == nil {
+			c, err = f.putChunk(ctx, userID, data, compression)
+		}
+		if err != nil {
+			_ = f.releaseChunks(context.Background(), userID, chunks)
+			return nil, err
+		}
+
+		c.start = start
+		start += c.size
+		if !seen[c.hash] {
+			stored += c.storedSize
+			seen[c.hash] = true
+		}
+		chunks = append(chunks, c)
+	}
+
+	o := in.object(filename, meta)
+	o.BlobHash, o.StoredSize = o.SHA256, stored
+	o.chunks, o.uploader = chunks, userID
+	if len(chunks) == 1 {
+		// Файл из одного фрагмента — сам этот фрагмент.
+		o.Compression = chunks[0].compression
+	}
+	return o, nil
+}
+
+// putChunk записывает фрагмент data, если такого еще нет, и удерживает его за пользователем.
+// compression — алгоритм сжатия файла, к которому относится фрагмент.
+func (f *FileStore) putChunk(ctx context.Context, userID int, data []byte, compression string) (chunk, error) {
+	sum := sha256.Sum256(data)
+	o := &Object{Size: int64(len(data)), BlobHash: hex.EncodeToString(sum[:])}
+
+	held, err := f.holdChunk(ctx, userID, o)
+	if err != nil {
+		return chunk{}, err
+	}
+	if !held {
+		var body io.Reader = bytes.NewReader(data)
+		if compression != "" && o.Size >= f.Compression.MinSize {
+			if body, err = blobstore.Compress(body, compression); err != nil {
+				return chunk{}, err
+			}
+			o.Compression = compression
+		}
+		o.staged = stagingKey(userID)
+		if o.StoredSize, err = f.Blobs.Put(ctx, o.staged, body); err != nil {
+			return chunk{}, err
+		}
+		if err := f.commitObject(ctx, o, func(tx *sql.Tx) error {
+			return holdChunkTx(ctx, tx, userID, o.BlobHash)
+		}); err != nil {
+			return chunk{}, err
+		}
+	}
+
+	return chunk{hash: o.BlobHash, size: o.Size, storedSize: o.StoredSize, compression: o.Compression}, nil
+}
+
+// holdChunk удерживает за пользователем уже хранящийся фрагмент o и дополняет o сжатием
+// и размером хранимого. Возвращает false, если такого фрагмента нет и его надо записать.
+func (f *FileStore) holdChunk(ctx context.Context, userID int, o *Object) (bool, error) {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var compression sql.NullString
+	err = tx.QueryRowContext(ctx,
+		"SELECT stored_size, compression FROM blobs WHERE hash = $1 AND NOT chunked FOR SHARE;",
+		o.BlobHash,
+	).Scan(&o.StoredSize, &compression)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// Содержимое, удаление которого прервалось, записывается заново и восстанавливается в claimBlob.
+	if _, err := f.Blobs.Stat(ctx, casKey(o.BlobHash)); errors.Is(err, blobstore.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := holdChunkTx(ctx, tx, userID, o.BlobHash); err != nil {
+		return false, err
+	}
+	o.Compression = compression.String
+	return true, tx.Commit()
+}
+
+func holdChunkTx(ctx context.Context, tx *sql.Tx, userID int, hash string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO user_chunks (userid, blob_hash) VALUES ($1, $2)
+		ON CONFLICT (userid, blob_hash) DO UPDATE SET uploaded_at = now()`,
+		userID,
+		hash,
+	)
+	return err
+}
+
+// releaseChunks отпускает фрагменты, удерживаемые за пользователем, и удаляет те,
+// на которые больше ничего не ссылается.
+func (f *FileStore) releaseChunks(ctx context.Context, userID int, chunks []chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if err := releaseChunksTx(ctx, f.Files, userID, chunks); err != nil {
+		return err
+	}
+	return f.freeBlobs(ctx)
+}
+
+func releaseChunksTx(ctx context.Context, db execer, userID int, chunks []chunk) error {
+	hashes := make([]string, len(chunks))
+	for i, c := range chunks {
+		hashes[i] = c.hash
+	}
+	_, err := db.ExecContext(ctx,
+		"DELETE FROM user_chunks WHERE userid = $1 AND blob_hash = ANY($2)",
+		userID,
+		pq.Array(hashes),
+	)
+	return err
+}
+
+// claimManifest регистрирует содержимое o из нескольких фрагментов. Если такое содержимое
+// уже хранится, объект получает сжатие и размер хранимого.
+func (f *FileStore) claimManifest(ctx context.Context, tx *sql.Tx, o *Object) error {
+	for {
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO blobs (hash, size, stored_size, chunked) VALUES ($1, $2, $3, true)
+			ON CONFLICT (hash) DO NOTHING`,
+			o.BlobHash,
+			o.Size,
+			o.StoredSize,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			return insertChunks(ctx, tx, o)
+		}
+
+		var (
+			stored      int64
+			compression sql.NullString
+			chunked     bool
+		)
+		err = tx.QueryRowContext(ctx,
+			"SELECT stored_size, compression, chunked FROM blobs WHERE hash = $1 FOR UPDATE;",
+			o.BlobHash,
+		).Scan(&stored, &compression, &chunked)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !chunked {
+			// Целое содержимое, удаление которого прервалось, заменяется фрагментами.
+			_, err := f.Blobs.Stat(ctx, casKey(o.BlobHash))
+			if errors.Is(err, blobstore.ErrNotFound) {
+				if _, err := tx.ExecContext(ctx,
+					"UPDATE blobs SET stored_size = $2, compression = NULL, chunked = true WHERE hash = $1",
+					o.BlobHash,
+					o.StoredSize,
+				); err != nil {
+					return err
+				}
+				return insertChunks(ctx, tx, o)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		o.StoredSize, o.Compression = stored, compression.String
+		return nil
+	}
+}
+
+func insertChunks(ctx context.Context, tx *sql.Tx, o *Object) error {
+	var (
+		hashes = make([]string, len(o.chunks))
+		starts = make([]int64, len(o.chunks))
+		sizes  = make([]int64, len(o.chunks))
+	)
+	for i, c := range o.chunks {
+		hashes[i], starts[i], sizes[i] = c.hash, c.start, c.size
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO blob_chunks (manifest, seq, blob_hash, start, size)
+		SELECT $1, c.seq, c.hash, c.start, c.size
+		FROM unnest($2::text[], $3::bigint[], $4::bigint[]) WITH ORDINALITY AS c (hash, start, size, seq)`,
+		o.BlobHash,
+		pq.Array(hashes),
+		pq.Array(starts),
+		pq.Array(sizes),
+	)
+	return err
+}
+
+// manifest возвращает фрагменты содержимого с хешем hash по порядку или nil,
+// если оно хранится целиком.
+func (f *FileStore) manifest(ctx context.Context, hash string) ([]chunk, error) {
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT c.blob_hash, c.start, c.size, COALESCE(b.compression, '') FROM blob_chunks c
+		JOIN blobs b ON b.hash = c.blob_hash WHERE c.manifest = $1 ORDER BY c.seq;`,
+		hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var chunks []chunk
+	for rows.Next() {
+		var c chunk
+		if err := rows.Scan(&c.hash, &c.start, &c.size, &c.compression); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// deleteStaleChunks отпускает фрагменты, загруженные раньше ttl и так и не собранные в файл.
+func (f *FileStore) deleteStaleChunks(ctx context.Context, ttl time.Duration) error {
+	// Время сравнивается на стороне базы: uploaded_at хранится без часового пояса.
+	if _, err := f.Files.ExecContext(ctx,
+		"DELETE FROM user_chunks WHERE uploaded_at < now() - make_interval(secs => $1)",
+		ttl.Seconds(),
+	); err != nil {
+		return err
+	}
+	return f.freeBlobs(ctx)
+}
+
+// chunkReader читает содержимое из фрагментов и поддерживает Seek, открывая только
+// фрагменты, которые действительно читаются.
+type chunkReader struct {
+	ctx    context.Context
+	blobs  blobstore.BlobBackend
+	chunks []chunk
+	size   int64
+	pos    int64
+
+	cur    io.ReadSeekCloser
+	curIdx int
+	curPos int64
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(r.chunks), func(i int) bool {
+		return r.chunks[i].start+r.chunks[i].size > r.pos
+	})
+	c := r.chunks[i]
+
+	if r.cur != nil && r.curIdx != i {
+		_ = r.cur.Close()
+		r.cur = nil
+	}
+	if r.cur == nil {
+		raw, err := r.blobs.Get(r.ctx, casKey(c.hash))
+		if err != nil {
+			return 0, err
+		}
+		r.cur = raw
+		if c.compression != "" {
+			if r.cur, err = blobstore.Decompress(raw); err != nil {
+				_ = raw.Close()
+				return 0, err
+			}
+		}
+		r.curIdx, r.curPos = i, c.start
+	}
+	if r.curPos != r.pos {
+		if _, err := r.cur.Seek(r.pos-c.start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.curPos = r.pos
+	}
+
+	n, err := r.cur.Read(p[:min(int64(len(p)), c.start+c.size-r.pos)])
+	r.pos += int64(n)
+	r.curPos = r.pos
+	if errors.Is(err, io.EOF) {
+		if r.pos < c.start+c.size {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"bufio"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+)
+
+// sniffSize — сколько первых байт содержимого нужно для определения его типа.
+const sniffSize = 512
+
+// CompressionPolicy — какие файлы сжимаются при записи.
+type CompressionPolicy struct {
+	// Algorithm — blobstore.CompressionGzip или blobstore.CompressionZstd; "" — не сжимать.
+	Algorithm string
+	// MinSize — файлы меньше этого размера не сжимаются. Столько байт содержимого
+	// читается в память до начала записи, чтобы проверить размер.
+	MinSize int64
+	// ContentTypes — сжимаемые типы содержимого. Значение с "/" на конце, например
+	// "text/", задает все типы с этим префиксом; пустой список — все типы.
+	ContentTypes []string
+}
+
+// applies сообщает, что содержимое типа contentType надо сжимать.
+func (p CompressionPolicy) applies(contentType string) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	for _, t := range p.ContentTypes {
+		if contentType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// compress решает по началу содержимого r, сжимать ли его, и возвращает reader
+// для записи в хранилище вместе с выбранным алгоритмом или "".
+func (f *FileStore) compress(filename string, meta Metadata, r io.Reader) (io.Reader, string, error) {
+	p := f.Compression
+	if p.Algorithm == "" {
+		return r, "", nil
+	}
+
+	br := bufio.NewReaderSize(r, int(max(p.MinSize, sniffSize)))
+	head, err := br.Peek(int(max(p.MinSize, sniffSize)))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, "", err
+	}
+	if int64(len(head)) < p.MinSize || f.compressionFor(filename, meta, head) == "" {
+		return br, "", nil
+	}
+
+	body, err := blobstore.Compress(br, p.Algorithm)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, p.Algorithm, nil
+}
+
+// compressionFor выбирает алгоритм сжатия для файла по его типу содержимого, определенному
+// в том числе по началу содержимого head, или "", если файл сжимать не нужно. Размер не проверяется.
+func (f *FileStore) compressionFor(filename string, meta Metadata, head []byte) string {
+	if f.Compression.Algorithm == "" || !f.Compression.applies(detectContentType(filename, meta, head[:min(len(head), sniffSize)])) {
+		return ""
+	}
+	return f.Compression.Algorithm
+}
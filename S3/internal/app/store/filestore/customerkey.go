@@ -0,0 +1,76 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+var (
+	ErrCustomerKeyRequired = errors.New("object is encrypted with a customer key, provide the key to read it")
+	ErrCustomerKeyMismatch = errors.New("provided customer key does not match the object")
+)
+
+// KeyFingerprint — отпечаток ключа клиента (SSE-C). В базе хранится только он:
+// по нему ключ проверяется при скачивании, но расшифровать содержимое без ключа нельзя.
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// openBlob открывает содержимое объекта o под ключом blobKey, расшифровывая его ключом
+// клиента, если объект им зашифрован, и распаковывая, если оно сжато. Содержимое,
+// хранящееся по хешу, читается из-под ключа хеша или собирается из фрагментов.
+// key должен совпадать с ключом загрузки, а для объектов без ключа клиента — быть nil.
+func (f *FileStore) openBlob(ctx context.Context, blobKey string, o *Object, key []byte) (io.ReadSeekCloser, error) {
+	if err := o.checkKey(key); err != nil {
+		return nil, err
+	}
+	if o.BlobHash != "" {
+		chunks, err := f.manifest(ctx, o.BlobHash)
+		if err != nil {
+			return nil, err
+		}
+		if chunks != nil {
+			return &chunkReader{ctx: ctx, blobs: f.Blobs, chunks: chunks, size: o.Size}, nil
+		}
+	}
+
+	file, err := f.Blobs.Get(ctx, o.contentKey(blobKey))
+	if err != nil {
+		return nil, err
+	}
+	if key != nil {
+		decrypted, err := blobstore.DecryptWithKey(file, key)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		file = decrypted
+	}
+	if o.Compression != "" {
+		decompressed, err := blobstore.Decompress(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		file = decompressed
+	}
+	return file, nil
+}
+
+func (o *Object) checkKey(key []byte) error {
+	switch {
+	case o.KeyFingerprint == "" && key == nil:
+		return nil
+	case key == nil:
+		return ErrCustomerKeyRequired
+	case subtle.ConstantTimeCompare([]byte(KeyFingerprint(key)), []byte(o.KeyFingerprint)) != 1:
+		return ErrCustomerKeyMismatch
+	}
+	return nil
+}
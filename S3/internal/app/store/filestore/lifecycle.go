@@ -0,0 +1,498 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	// LifecycleExpire удаляет файл так же, как /api/delete: в корзину, а в бакете
+	// с версионированием — маркером удаления.
+	LifecycleExpire = "expire"
+	// LifecycleCompress сжимает содержимое файла алгоритмом из FileStore.Compression, как при
+	// записи, но независимо от типа содержимого. Уже сжатые, зашифрованные ключом клиента
+	// и собранные из фрагментов файлы, а также файлы меньше Compression.MinSize остаются как есть.
+	LifecycleCompress = "compress"
+
+	// LifecycleSinceUpload отсчитывает срок от загрузки файла.
+	LifecycleSinceUpload = "upload"
+	// LifecycleSinceAccess отсчитывает срок от последнего скачивания, а если файл
+	// не скачивали — от загрузки.
+	LifecycleSinceAccess = "access"
+)
+
+// lifecycleBatch — сколько файлов правило обрабатывает за один запрос к базе.
+const lifecycleBatch = 1000
+
+var (
+	ErrRuleNotFound      = errors.New("lifecycle rule not found")
+	ErrInvalidRuleAction = errors.New("lifecycle action must be expire or compress")
+	ErrRuleNoCompression = errors.New("lifecycle compress requires compression to be enabled")
+	ErrInvalidRuleSince  = errors.New("lifecycle since must be upload or access")
+	ErrInvalidRuleDays   = errors.New("lifecycle days must be positive")
+)
+
+// LifecycleRule — правило обработки старых файлов пользователя. Bucket == nil означает
+// все бакеты, включая корневое пространство.
+type LifecycleRule struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"-"`
+	Bucket    *string   `json:"bucket,omitempty"`
+	Prefix    string    `json:"prefix"`
+	Action    string    `json:"action"`
+	Days      int       `json:"days"`
+	Since     string    `json:"since"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LifecycleAction — действие правила над конкретным файлом.
+type LifecycleAction struct {
+	RuleID   string    `json:"rule_id"`
+	Action   string    `json:"action"`
+	Bucket   string    `json:"bucket"`
+	Filename string    `json:"filename"`
+	Since    time.Time `json:"since"`
+}
+
+const ruleColumns = "id, userid, bucket, prefix, action, days, since, created_at"
+
+// CreateRule проверяет и сохраняет правило.
+func (f *FileStore) CreateRule(ctx context.Context, userID int, rule LifecycleRule) (*LifecycleRule, error) {
+	if rule.Since == "" {
+		rule.Since = LifecycleSinceUpload
+	}
+	if err := rule.validate(); err != nil {
+		return nil, err
+	}
+	if rule.Action == LifecycleCompress && f.Compression.Algorithm == "" {
+		return nil, ErrRuleNoCompression
+	}
+	if rule.Bucket != nil {
+		if err := f.checkBucket(ctx, userID, *rule.Bucket); err != nil {
+			return nil, err
+		}
+	}
+
+	return scanRule(f.Files.QueryRowContext(ctx,
+		`INSERT INTO lifecycle_rules (userid, bucket, prefix, action, days, since)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+ruleColumns,
+		userID,
+		rule.Bucket,
+		rule.Prefix,
+		rule.Action,
+		rule.Days,
+		rule.Since,
+	))
+}
+
+// ListRules возвращает правила пользователя в порядке создания.
+func (f *FileStore) ListRules(ctx context.Context, userID int) ([]LifecycleRule, error) {
+	return f.queryRules(ctx, "SELECT "+ruleColumns+" FROM lifecycle_rules WHERE userid = $1 ORDER BY created_at, id", userID)
+}
+
+// DeleteRule удаляет правило.
+func (f *FileStore) DeleteRule(ctx context.Context, userID int, id string) error {
+	res, err := f.Files.ExecContext(ctx, "DELETE FROM lifecycle_rules WHERE id = $1 AND userid = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// PlanLifecycle возвращает до limit действий, которые правила пользователя выполнили бы сейчас.
+// truncated сообщает, что действий больше.
+func (f *FileStore) PlanLifecycle(ctx context.Context, userID int, limit int) (actions []LifecycleAction, truncated bool, err error) {
+	rules, err := f.ListRules(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Файл, попавший под несколько правил с одним действием, обработает первое из них.
+	seen := make(map[[3]string]bool)
+	for _, rule := range rules {
+		if !f.canApply(&rule) {
+			continue
+		}
+		matched, err := f.matchRule(ctx, &rule, limit+1)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, a := range matched {
+			key := [3]string{a.Action, a.Bucket, a.Filename}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if len(actions) == limit {
+				return actions, true, nil
+			}
+			actions = append(actions, a)
+		}
+	}
+	return actions, false, nil
+}
+
+// RunLifecycle применяет правила всех пользователей и возвращает число обработанных файлов.
+// Ошибка на одном файле не останавливает остальные правила; возвращается первая из них.
+func (f *FileStore) RunLifecycle(ctx context.Context) (int, error) {
+	rules, err := f.queryRules(ctx, "SELECT "+ruleColumns+" FROM lifecycle_rules ORDER BY userid, created_at, id")
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		done     int
+		firstErr error
+	)
+	for _, rule := range rules {
+		n, err := f.applyRule(ctx, &rule)
+		done += n
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return done, firstErr
+}
+
+// applyRule обрабатывает файлы правила пачками. Обработанный файл больше не подходит
+// под правило (удален или уже сжат), поэтому следующий запрос возвращает другие файлы.
+// Файлы, которые остались как есть (например, изменились во время сжатия), вернутся
+// в следующей пачке, поэтому пачка без единого обработанного файла завершает правило.
+func (f *FileStore) applyRule(ctx context.Context, rule *LifecycleRule) (int, error) {
+	if !f.canApply(rule) {
+		return 0, nil
+	}
+
+	done := 0
+	for {
+		actions, err := f.matchRule(ctx, rule, lifecycleBatch)
+		if err != nil {
+			return done, err
+		}
+		progressed := false
+		for _, a := range actions {
+			applied, err := f.applyAction(ctx, rule, a)
+			if err != nil {
+				return done, err
+			}
+			if applied {
+				done++
+				progressed = true
+			}
+		}
+		if len(actions) < lifecycleBatch || !progressed {
+			return done, nil
+		}
+	}
+}
+
+// canApply сообщает, может ли правило сейчас что-то сделать: правила compress, созданные
+// до отключения сжатия в конфигурации, пропускаются.
+func (f *FileStore) canApply(rule *LifecycleRule) bool {
+	return rule.Action != LifecycleCompress || f.Compression.Algorithm != ""
+}
+
+// applyAction выполняет действие правила над файлом и сообщает, изменился ли файл.
+func (f *FileStore) applyAction(ctx context.Context, rule *LifecycleRule, a LifecycleAction) (bool, error) {
+	var err error
+	applied := true
+	if rule.Action == LifecycleCompress {
+		applied, err = f.compressStored(ctx, rule.UserID, a.Bucket, a.Filename)
+	} else {
+		err = f.expire(ctx, rule.UserID, a.Bucket, a.Filename)
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		// Файл удалили раньше правила.
+		return true, nil
+	}
+	return applied, err
+}
+
+// expire удаляет файл по правилам его бакета.
+func (f *FileStore) expire(ctx context.Context, userID int, bucket, filename string) error {
+	versioned, err := f.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return err
+	}
+	if versioned {
+		_, err := f.DeleteVersioned(ctx, userID, bucket, filename)
+		return err
+	}
+	_, err = f.Trash(ctx, userID, bucket, filename)
+	return err
+}
+
+// compressStored сжимает содержимое файла, записанное без сжатия, и сообщает, сжато ли оно:
+// файл, измененный за время сжатия, остается как есть. Сжатое содержимое пишется под
+// временным ключом, после чего файл (а для содержимого по хешу — все ссылки на него)
+// помечается сжатым. Несжатое содержимое blobstore.Decompress отдает как есть, поэтому
+// до замены содержимого файл читается по-прежнему. Замена идет под блокировкой строки,
+// чтобы не затереть содержимое параллельной перезаписи.
+func (f *FileStore) compressStored(ctx context.Context, userID int, bucket, filename string) (bool, error) {
+	o, err := f.Head(ctx, userID, bucket, filename)
+	if err != nil {
+		return false, err
+	}
+	if o.Compression != "" || o.KeyFingerprint != "" {
+		return false, nil
+	}
+	if o.BlobHash != "" {
+		chunks, err := f.manifest(ctx, o.BlobHash)
+		if err != nil || chunks != nil {
+			return false, err
+		}
+	}
+
+	key := blobKey(userID, bucket, filename)
+	file, err := f.openBlob(ctx, key, o, nil)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return false, ErrObjectNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func(file io.Closer) {
+		_ = file.Close()
+	}(file)
+
+	algorithm := f.Compression.Algorithm
+	body, err := blobstore.Compress(file, algorithm)
+	if err != nil {
+		return false, err
+	}
+	staged := stagingKey(userID)
+	defer func() {
+		_ = f.Blobs.Delete(context.Background(), staged)
+	}()
+	stored, err := f.Blobs.Put(ctx, staged, body)
+	if err != nil {
+		return false, err
+	}
+
+	if o.BlobHash != "" {
+		return f.swapCompressedBlob(ctx, o.BlobHash, staged, algorithm, stored)
+	}
+	return f.swapCompressedFile(ctx, userID, bucket, filename, o.UploadedAt, staged, algorithm, stored)
+}
+
+// swapCompressedFile заменяет содержимое под ключом файла сжатым staged, если файл
+// не перезаписали после uploadedAt.
+func (f *FileStore) swapCompressedFile(ctx context.Context, userID int, bucket, filename string, uploadedAt time.Time, staged, algorithm string, stored int64) (bool, error) {
+	res, err := f.Files.ExecContext(ctx,
+		`UPDATE files SET compression = $5
+		WHERE userid = $1 AND bucket = $2 AND filename = $3 AND uploaded_at = $4
+		AND compression IS NULL AND key_fingerprint IS NULL AND blob_hash IS NULL`,
+		userID,
+		bucket,
+		filename,
+		uploadedAt,
+		algorithm,
+	)
+	if ok, err := affected(res, err); !ok {
+		return false, err
+	}
+
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// UPDATE блокирует строку: перезапись файла дождется замены содержимого.
+	res, err = tx.ExecContext(ctx,
+		`UPDATE files SET stored_size = $6
+		WHERE userid = $1 AND bucket = $2 AND filename = $3 AND uploaded_at = $4
+		AND compression = $5 AND blob_hash IS NULL`,
+		userID,
+		bucket,
+		filename,
+		uploadedAt,
+		algorithm,
+		stored,
+	)
+	if ok, err := affected(res, err); !ok {
+		return false, err
+	}
+	if err := f.Blobs.Rename(ctx, staged, blobKey(userID, bucket, filename)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// swapCompressedBlob заменяет содержимое по хешу сжатым staged и переносит сжатие и размер
+// в хранилище во все ссылающиеся на него строки.
+func (f *FileStore) swapCompressedBlob(ctx context.Context, hash, staged, algorithm string, stored int64) (bool, error) {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"UPDATE blobs SET compression = $2 WHERE hash = $1 AND compression IS NULL AND NOT chunked",
+		hash,
+		algorithm,
+	)
+	if ok, err := affected(res, err); !ok {
+		return false, err
+	}
+	if err := updateBlobRefs(ctx, tx, hash, "compression", algorithm); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	tx, err = f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Блокировка строки blobs не дает удалить содержимое или записать его заново до замены.
+	res, err = tx.ExecContext(ctx,
+		"UPDATE blobs SET stored_size = $3 WHERE hash = $1 AND compression = $2",
+		hash,
+		algorithm,
+		stored,
+	)
+	if ok, err := affected(res, err); !ok {
+		return false, err
+	}
+	if err := updateBlobRefs(ctx, tx, hash, "stored_size", stored); err != nil {
+		return false, err
+	}
+	if err := f.Blobs.Rename(ctx, staged, casKey(hash)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// updateBlobRefs записывает value в колонку column всех файлов, версий и файлов корзины
+// с содержимым hash: сжатие и размер содержимого по хешу хранятся и в них.
+func updateBlobRefs(ctx context.Context, tx *sql.Tx, hash, column string, value interface{}) error {
+	for _, table := range []string{"files", "file_versions", "trash"} {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET "+column+" = $2 WHERE blob_hash = $1", hash, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// affected сообщает, изменил ли запрос хотя бы одну строку.
+func affected(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// matchRule возвращает до limit файлов, срок которых по правилу истек, от самых старых.
+func (f *FileStore) matchRule(ctx context.Context, rule *LifecycleRule, limit int) ([]LifecycleAction, error) {
+	since := "uploaded_at"
+	if rule.Since == LifecycleSinceAccess {
+		since = "COALESCE(last_accessed_at, uploaded_at)"
+	}
+	args := []interface{}{rule.UserID, rule.Bucket, rule.Prefix, rule.Days, limit}
+	filter := ""
+	if rule.Action == LifecycleCompress {
+		filter = `AND compression IS NULL AND key_fingerprint IS NULL AND (size IS NULL OR size >= $6)
+		AND NOT EXISTS (SELECT 1 FROM blobs b WHERE b.hash = files.blob_hash AND b.chunked)`
+		args = append(args, f.Compression.MinSize)
+	}
+
+	// Время сравнивается на стороне базы: uploaded_at хранится без часового пояса.
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT bucket, filename, `+since+` AS since FROM files
+		WHERE userid = $1 AND ($2::text IS NULL OR bucket = $2) AND starts_with(filename, $3)
+		AND `+since+` < now() - make_interval(days => $4) `+filter+`
+		ORDER BY since, bucket, filename LIMIT $5`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var actions []LifecycleAction
+	for rows.Next() {
+		a := LifecycleAction{RuleID: rule.ID, Action: rule.Action}
+		if err := rows.Scan(&a.Bucket, &a.Filename, &a.Since); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// MarkAccessed запоминает время скачивания файла для правил с since = access.
+func (f *FileStore) MarkAccessed(ctx context.Context, userID int, bucket, filename string) error {
+	_, err := f.Files.ExecContext(ctx,
+		"UPDATE files SET last_accessed_at = now() WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+	)
+	return err
+}
+
+func (r *LifecycleRule) validate() error {
+	if r.Action != LifecycleExpire && r.Action != LifecycleCompress {
+		return ErrInvalidRuleAction
+	}
+	if r.Since != LifecycleSinceUpload && r.Since != LifecycleSinceAccess {
+		return ErrInvalidRuleSince
+	}
+	if r.Days <= 0 {
+		return ErrInvalidRuleDays
+	}
+	return nil
+}
+
+func (f *FileStore) queryRules(ctx context.Context, query string, args ...interface{}) ([]LifecycleRule, error) {
+	rows, err := f.Files.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var rules []LifecycleRule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+func scanRule(row rowScanner) (*LifecycleRule, error) {
+	var (
+		r      LifecycleRule
+		bucket sql.NullString
+	)
+	if err := row.Scan(&r.ID, &r.UserID, &bucket, &r.Prefix, &r.Action, &r.Days, &r.Since, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	if bucket.Valid {
+		r.Bucket = &bucket.String
+	}
+	return &r, nil
+}
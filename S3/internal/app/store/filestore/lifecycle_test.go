@@ -0,0 +1,167 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCompressStored(t *testing.T) {
+	content := []byte(strings.Repeat("line of a log file that compresses well\n", 2000))
+	hash := sha256Hex(content)
+	uploadedAt := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		hash string
+		key  string
+	}{
+		{"under the file key", "", blobKey(testUser, "", "a.log")},
+		{"by hash", hash, casKey(hash)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			f.Compression.Algorithm = blobstore.CompressionZstd
+			putBlobs(t, blobs, map[string][]byte{tc.key: content})
+
+			expectHead(mock, "a.log", uploadedAt, len(content), hash, blobHashArg(tc.hash != "", hash))
+			if tc.hash == "" {
+				mock.ExpectExec(sqlPrefix("UPDATE files SET compression")).
+					WithArgs(testUser, "", "a.log", uploadedAt, blobstore.CompressionZstd).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectBegin()
+				mock.ExpectExec(sqlPrefix("UPDATE files SET stored_size")).
+					WithArgs(testUser, "", "a.log", uploadedAt, blobstore.CompressionZstd, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				// Фрагментов нет: содержимое хранится целиком.
+				for range 2 {
+					mock.ExpectQuery(sqlPrefix("SELECT c.blob_hash")).WithArgs(hash).
+						WillReturnRows(sqlmock.NewRows([]string{"blob_hash", "start", "size", "compression"}))
+				}
+				for _, column := range []string{"compression", "stored_size"} {
+					mock.ExpectBegin()
+					mock.ExpectExec(sqlPrefix("UPDATE blobs SET " + column)).WillReturnResult(sqlmock.NewResult(0, 1))
+					for _, table := range []string{"files", "file_versions", "trash"} {
+						mock.ExpectExec(sqlPrefix("UPDATE "+table+" SET "+column)).
+							WithArgs(hash, sqlmock.AnyArg()).
+							WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+					mock.ExpectCommit()
+				}
+			}
+
+			compressed, err := f.compressStored(context.Background(), testUser, "", "a.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !compressed {
+				t.Fatal("compressStored() = false, want true")
+			}
+			checkCompressed(t, blobs, tc.key, content)
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestCompressStoredOverwritten(t *testing.T) {
+	content := []byte(strings.Repeat("old content ", 1000))
+	uploadedAt := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	key := blobKey(testUser, "", "a.log")
+
+	f, mock, blobs := newTestStore(t)
+	f.Compression.Algorithm = blobstore.CompressionGzip
+	putBlobs(t, blobs, map[string][]byte{key: content})
+
+	expectHead(mock, "a.log", uploadedAt, len(content), sha256Hex(content), nil)
+	// Файл перезаписали, пока сжималось прежнее содержимое.
+	mock.ExpectExec(sqlPrefix("UPDATE files SET compression")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	compressed, err := f.compressStored(context.Background(), testUser, "", "a.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed {
+		t.Error("compressStored() = true for an overwritten file")
+	}
+	checkBlobs(t, blobs, map[string][]byte{key: content})
+	checkExpectations(t, mock)
+}
+
+func TestCreateRuleCompressRequiresCompression(t *testing.T) {
+	f, mock, _ := newTestStore(t)
+	_, err := f.CreateRule(context.Background(), testUser, LifecycleRule{Action: LifecycleCompress, Days: 90})
+	if !errors.Is(err, ErrRuleNoCompression) {
+		t.Errorf("CreateRule() error = %v, want ErrRuleNoCompression", err)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestLifecycleRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule LifecycleRule
+		want error
+	}{
+		{LifecycleRule{Action: LifecycleExpire, Days: 30, Since: LifecycleSinceUpload}, nil},
+		{LifecycleRule{Action: LifecycleCompress, Days: 90, Since: LifecycleSinceAccess}, nil},
+		{LifecycleRule{Action: "archive", Days: 30, Since: LifecycleSinceUpload}, ErrInvalidRuleAction},
+		{LifecycleRule{Action: LifecycleExpire, Days: 0, Since: LifecycleSinceUpload}, ErrInvalidRuleDays},
+		{LifecycleRule{Action: LifecycleExpire, Days: 30, Since: "download"}, ErrInvalidRuleSince},
+	}
+	for _, tc := range tests {
+		if err := tc.rule.validate(); !errors.Is(err, tc.want) {
+			t.Errorf("validate(%+v) = %v, want %v", tc.rule, err, tc.want)
+		}
+	}
+}
+
+// expectHead ожидает чтение метаданных файла.
+func expectHead(mock sqlmock.Sqlmock, filename string, uploadedAt time.Time, size int, sum string, hash interface{}) {
+	mock.ExpectQuery(sqlPrefix("SELECT uploaded_at, size")).
+		WithArgs(testUser, "", filename).
+		WillReturnRows(sqlmock.NewRows([]string{"uploaded_at", "size", "content_type", "sha256", "etag", "metadata", "key_fingerprint", "compression", "blob_hash"}).
+			AddRow(uploadedAt, size, "text/plain", sum, nil, nil, nil, nil, hash))
+}
+
+// checkCompressed проверяет, что под key лежит сжатое content и ничего больше.
+func checkCompressed(t *testing.T, blobs blobstore.BlobBackend, key string, content []byte) {
+	t.Helper()
+	infos, err := blobs.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Key != key {
+		t.Fatalf("blobs = %v, want only %s", infos, key)
+	}
+	if infos[0].Size >= int64(len(content)) {
+		t.Errorf("stored %d bytes of %d, want them compressed", infos[0].Size, len(content))
+	}
+
+	file, err := blobs.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := blobstore.Decompress(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r io.Closer) {
+		_ = r.Close()
+	}(r)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("compressed content does not decompress to the original")
+	}
+}
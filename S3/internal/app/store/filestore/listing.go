@@ -0,0 +1,284 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SortByName = "name"
+	SortByDate = "date"
+	SortBySize = "size"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("sort must be name, date or size")
+)
+
+// ListOptions — параметры постраничного листинга файлов. Пустые фильтры не применяются.
+type ListOptions struct {
+	Prefix    string
+	Delimiter string
+	// Sort — SortByName, SortByDate или SortBySize; по умолчанию по имени.
+	Sort       string
+	Descending bool
+	// Name — подстрока имени без учета регистра.
+	Name string
+	// Extensions — расширения без точки в нижнем регистре.
+	Extensions []string
+	From       time.Time
+	To         time.Time
+	// Public отбирает файлы с действующей публичной ссылкой (true) или без нее (false).
+	Public *bool
+	// Tags — условия по тегам, все должны выполняться.
+	Tags   []TagFilter
+	Limit  int
+	Cursor string
+}
+
+// FileList — страница листинга. Папки (при заданном Delimiter) возвращаются целиком
+// на первой странице, фильтры и сортировка относятся только к файлам.
+type FileList struct {
+	Folders    []string
+	Objects    []Object
+	Public     map[string]bool
+	NextCursor string
+}
+
+// listCursor — позиция в листинге: значение ключа сортировки и имя последнего файла.
+// Сортировка и направление входят в курсор, чтобы его нельзя было применить к другому порядку.
+type listCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v,omitempty"`
+	Filename   string `json:"n"`
+}
+
+// ListFiles возвращает до opts.Limit файлов бакета в порядке opts.Sort с тай-брейком по имени,
+// так что курсор устойчив к одинаковым датам и размерам. Файлы без размера в базе
+// (загруженные до появления метаданных) сортируются по размеру как пустые.
+func (f *FileStore) ListFiles(ctx context.Context, userID int, bucket string, opts ListOptions) (*FileList, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+
+	sortBy := opts.Sort
+	if sortBy == "" {
+		sortBy = SortByName
+	}
+	var key string
+	switch sortBy {
+	case SortByName:
+	case SortByDate:
+		key = "uploaded_at"
+	case SortBySize:
+		key = "COALESCE(size, 0)"
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	var (
+		where = []string{"userid = $1", "bucket = $2", "starts_with(filename, $3)"}
+		args  = []interface{}{userID, bucket, opts.Prefix}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.Delimiter != "" {
+		where = append(where, "strpos(substr(filename, char_length($3) + 1), "+arg(opts.Delimiter)+") = 0")
+	}
+	if opts.Name != "" {
+		where = append(where, "strpos(lower(filename), lower("+arg(opts.Name)+")) > 0")
+	}
+	if len(opts.Extensions) > 0 {
+		where = append(where, `lower(substring(filename from '\.([^./]*)$')) = ANY(`+arg(pq.Array(opts.Extensions))+")")
+	}
+	if !opts.From.IsZero() {
+		where = append(where, "uploaded_at >= "+arg(opts.From))
+	}
+	if !opts.To.IsZero() {
+		where = append(where, "uploaded_at < "+arg(opts.To))
+	}
+	const public = `EXISTS (SELECT 1 FROM shares s WHERE s.userid = files.userid AND s.bucket = files.bucket
+		AND (s.filename = files.filename OR s.folder AND starts_with(files.filename, s.filename)) AND s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > now())
+		AND (s.max_downloads IS NULL OR s.downloads < s.max_downloads))`
+	if opts.Public != nil {
+		if *opts.Public {
+			where = append(where, public)
+		} else {
+			where = append(where, "NOT "+public)
+		}
+	}
+
+	for _, t := range opts.Tags {
+		where = append(where, tagCondition(t, arg))
+	}
+
+	op, dir := ">", "ASC"
+	if opts.Descending {
+		op, dir = "<", "DESC"
+	}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil || c.Sort != sortBy || c.Descending != opts.Descending {
+			return nil, ErrInvalidCursor
+		}
+		switch sortBy {
+		case SortByName:
+			where = append(where, `filename COLLATE "C" `+op+" "+arg(c.Filename))
+		case SortByDate:
+			t, err := time.Parse(time.RFC3339Nano, c.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			where = append(where, fmt.Sprintf(`(uploaded_at, filename COLLATE "C") %s (%s::timestamp, %s)`, op, arg(t), arg(c.Filename)))
+		case SortBySize:
+			where = append(where, fmt.Sprintf(`(%s, filename COLLATE "C") %s (%s::bigint, %s)`, key, op, arg(c.Value), arg(c.Filename)))
+		}
+	}
+
+	order := `filename COLLATE "C" ` + dir
+	if key != "" {
+		order = key + " " + dir + ", " + order
+	}
+	query := `SELECT filename, uploaded_at, size, content_type, sha256, etag, metadata, blob_hash, ` + public + ` FROM files
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY ` + order
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", opts.Limit+1)
+	}
+
+	rows, err := f.Files.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	list := &FileList{Public: make(map[string]bool)}
+	sized := make(map[string]bool)
+	truncated := false
+	for rows.Next() {
+		if opts.Limit > 0 && len(list.Objects) == opts.Limit {
+			truncated = true
+			break
+		}
+
+		var (
+			o           Object
+			size        sql.NullInt64
+			contentType sql.NullString
+			sum         sql.NullString
+			etag        sql.NullString
+			metadata    []byte
+			hash        sql.NullString
+			isPublic    bool
+		)
+		if err := rows.Scan(&o.Key, &o.UploadedAt, &size, &contentType, &sum, &etag, &metadata, &hash, &isPublic); err != nil {
+			return nil, err
+		}
+		if err := o.fill(size, contentType, sum, etag, metadata); err != nil {
+			return nil, err
+		}
+		o.BlobHash = hash.String
+		sized[o.Key] = size.Valid
+		list.Public[o.Key] = isPublic
+		list.Objects = append(list.Objects, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if truncated {
+		// Курсор строится по значениям из базы, до подстановки размеров из хранилища.
+		last := list.Objects[len(list.Objects)-1]
+		c := listCursor{Sort: sortBy, Descending: opts.Descending, Filename: last.Key}
+		switch sortBy {
+		case SortByDate:
+			c.Value = last.UploadedAt.Format(time.RFC3339Nano)
+		case SortBySize:
+			c.Value = fmt.Sprint(last.Size)
+		}
+		if list.NextCursor, err = encodeCursor(c); err != nil {
+			return nil, err
+		}
+	}
+
+	objects := list.Objects[:0]
+	for _, o := range list.Objects {
+		if err := f.statBlob(ctx, blobKey(userID, bucket, o.Key), &o, sized[o.Key]); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	list.Objects = objects
+
+	if opts.Delimiter != "" && opts.Cursor == "" {
+		if list.Folders, err = f.listFolders(ctx, userID, bucket, opts.Prefix, opts.Delimiter); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// listFolders возвращает папки уровня prefix: общие префиксы ключей до следующего delimiter.
+func (f *FileStore) listFolders(ctx context.Context, userID int, bucket, prefix, delimiter string) ([]string, error) {
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT DISTINCT $3 || split_part(substr(filename, char_length($3) + 1), $4, 1) || $4 COLLATE "C" AS folder
+		FROM files WHERE userid = $1 AND bucket = $2 AND starts_with(filename, $3)
+		AND strpos(substr(filename, char_length($3) + 1), $4) > 0
+		ORDER BY folder`,
+		userID,
+		bucket,
+		prefix,
+		delimiter,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var folders []string
+	for rows.Next() {
+		var folder string
+		if err := rows.Scan(&folder); err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+func encodeCursor(c listCursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal(b, &c)
+}
@@ -0,0 +1,266 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// MaxUserMetadataSize — предельный суммарный размер ключей и значений пользовательских
+// метаданных, как и в S3.
+const MaxUserMetadataSize = 2048
+
+var ErrMetadataTooLarge = errors.New("user metadata is larger than 2 KB")
+
+// Metadata — то, что клиент передает вместе с содержимым файла. Пустой ContentType
+// определяется по расширению имени или по первым байтам содержимого.
+type Metadata struct {
+	ContentType string
+	User        map[string]string
+	// CustomerKey — ключ клиента (SSE-C), которым шифруется содержимое; nil — без него.
+	CustomerKey []byte
+	// etag — ETag объекта, собранного из частей; без него ETag — MD5 содержимого.
+	etag string
+}
+
+func (m Metadata) validate() error {
+	size := 0
+	for k, v := range m.User {
+		size += len(k) + len(v)
+	}
+	if size > MaxUserMetadataSize {
+		return ErrMetadataTooLarge
+	}
+	return nil
+}
+
+// Head возвращает метаданные файла без чтения содержимого.
+func (f *FileStore) Head(ctx context.Context, userID int, bucket, filename string) (*Object, error) {
+	o := &Object{Key: filename}
+	var (
+		size        sql.NullInt64
+		contentType sql.NullString
+		sum         sql.NullString
+		etag        sql.NullString
+		metadata    []byte
+		fingerprint sql.NullString
+		compression sql.NullString
+		hash        sql.NullString
+	)
+	err := f.Files.QueryRowContext(ctx,
+		`SELECT uploaded_at, size, content_type, sha256, etag, metadata, key_fingerprint, compression, blob_hash FROM files
+		WHERE userid = $1 AND bucket = $2 AND filename = $3;`,
+		userID,
+		bucket,
+		filename,
+	).Scan(&o.UploadedAt, &size, &contentType, &sum, &etag, &metadata, &fingerprint, &compression, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return nil, err
+		}
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := o.fill(size, contentType, sum, etag, metadata); err != nil {
+		return nil, err
+	}
+	o.KeyFingerprint = fingerprint.String
+	o.Compression = compression.String
+	o.BlobHash = hash.String
+	if err := f.statBlob(ctx, blobKey(userID, bucket, filename), o, size.Valid); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// fill раскладывает метаданные из строки базы. Для файлов, загруженных до появления
+// метаданных, тип содержимого определяется по расширению.
+func (o *Object) fill(size sql.NullInt64, contentType, sum, etag sql.NullString, metadata []byte) error {
+	o.Size = size.Int64
+	o.ContentType = contentType.String
+	if o.ContentType == "" {
+		o.ContentType = mime.TypeByExtension(path.Ext(o.Key))
+	}
+	o.SHA256 = sum.String
+	o.ETag = etag.String
+	if len(metadata) == 0 {
+		return nil
+	}
+	return json.Unmarshal(metadata, &o.Metadata)
+}
+
+// statBlob дополняет объект временем изменения содержимого, а если размер в базе
+// не записан — и размером. Содержимое по хешу общее для разных файлов, поэтому
+// временем его изменения считается время загрузки.
+func (f *FileStore) statBlob(ctx context.Context, key string, o *Object, sized bool) error {
+	if o.BlobHash != "" {
+		o.LastModified = o.UploadedAt
+		return nil
+	}
+	info, err := f.Blobs.Stat(ctx, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return ErrObjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+	o.LastModified = info.ModTime
+	if !sized {
+		o.Size = info.Size
+	}
+	return nil
+}
+
+// inspector считает размер, SHA-256 и MD5 содержимого и запоминает его начало,
+// пока содержимое потоково пишется в хранилище. MD5 нужен только для ETag: SDK сверяют
+// ETag объекта, загруженного одним запросом, с MD5 отправленного.
+type inspector struct {
+	r     io.Reader
+	size  int64
+	hash  hash.Hash
+	md5   hash.Hash
+	sniff []byte
+}
+
+func newInspector(r io.Reader) *inspector {
+	return &inspector{r: r, hash: sha256.New(), md5: md5.New()}
+}
+
+func (in *inspector) Read(p []byte) (int, error) {
+	n, err := in.r.Read(p)
+	in.size += int64(n)
+	in.hash.Write(p[:n])
+	in.md5.Write(p[:n])
+	if rest := sniffSize - len(in.sniff); rest > 0 {
+		in.sniff = append(in.sniff, p[:min(n, rest)]...)
+	}
+	return n, err
+}
+
+// object собирает метаданные записанного содержимого. Для содержимого, зашифрованного
+// ключом клиента, SHA-256 и MD5 не сохраняются: по ним сервер мог бы узнать известный ему файл.
+func (in *inspector) object(filename string, meta Metadata) *Object {
+	o := &Object{
+		Key:         filename,
+		Size:        in.size,
+		ContentType: detectContentType(filename, meta, in.sniff),
+		SHA256:      hex.EncodeToString(in.hash.Sum(nil)),
+		ETag:        hex.EncodeToString(in.md5.Sum(nil)),
+		Metadata:    meta.User,
+	}
+	if meta.etag != "" {
+		o.ETag = meta.etag
+	}
+	if meta.CustomerKey != nil {
+		o.SHA256, o.ETag = "", ""
+		o.KeyFingerprint = KeyFingerprint(meta.CustomerKey)
+	}
+	return o
+}
+
+// detectContentType определяет тип содержимого: явно заданный, по расширению имени
+// или по первым 512 байтам содержимого head.
+func detectContentType(filename string, meta Metadata, head []byte) string {
+	if meta.ContentType != "" {
+		return meta.ContentType
+	}
+	if contentType := mime.TypeByExtension(path.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
+
+// putBlob потоково записывает содержимое файла в пределах квоты пользователя
+// и возвращает его метаданные. Содержимое сжимается по правилам f.Compression
+// до шифрования ключом клиента: зашифрованное содержимое уже не сжать.
+// Содержимое пишется под временным ключом и становится содержимым файла в commitObject:
+// по хешу или под ключом файла — без f.Dedup или если оно зашифровано ключом клиента
+// (одинаковые файлы с разными ключами не совпадают). До фиксации записи прежнее
+// содержимое файла не меняется. Если задан f.ChunkSize, содержимое по хешу делится
+// на фрагменты в putChunks.
+func (f *FileStore) putBlob(ctx context.Context, userID int, bucket, filename string, r io.Reader, meta Metadata) (*Object, error) {
+	if err := meta.validate(); err != nil {
+		return nil, err
+	}
+	r, err := f.limitToQuota(ctx, userID, bucket, filename, 0, r)
+	if err != nil {
+		return nil, err
+	}
+
+	in := newInspector(r)
+	dedup := f.Dedup && meta.CustomerKey == nil
+	if f.ChunkSize > 0 && dedup {
+		o, err := f.putChunks(ctx, userID, filename, in, meta)
+		if err != nil {
+			return nil, err
+		}
+		o.owner, o.bucket = userID, bucket
+		return o, nil
+	}
+	body, compression, err := f.compress(filename, meta, in)
+	if err != nil {
+		return nil, err
+	}
+	if meta.CustomerKey != nil {
+		if body, err = blobstore.EncryptWithKey(body, meta.CustomerKey); err != nil {
+			return nil, err
+		}
+	}
+	key := stagingKey(userID)
+	stored, err := f.Blobs.Put(ctx, key, body)
+	if err != nil {
+		return nil, err
+	}
+
+	o := in.object(filename, meta)
+	o.Compression = compression
+	o.StoredSize = stored
+	o.staged = key
+	o.owner, o.bucket = userID, bucket
+	if dedup {
+		o.BlobHash = o.SHA256
+	} else {
+		o.dest = blobKey(userID, bucket, filename)
+	}
+	return o, nil
+}
+
+// keyFingerprint готовит отпечаток ключа клиента для колонки; без ключа хранится NULL.
+func (o *Object) keyFingerprint() sql.NullString {
+	return sql.NullString{String: o.KeyFingerprint, Valid: o.KeyFingerprint != ""}
+}
+
+// compression готовит алгоритм сжатия для колонки; без сжатия хранится NULL.
+func (o *Object) compression() sql.NullString {
+	return sql.NullString{String: o.Compression, Valid: o.Compression != ""}
+}
+
+// etagColumn готовит ETag для колонки; без него хранится NULL.
+func (o *Object) etagColumn() sql.NullString {
+	return sql.NullString{String: o.ETag, Valid: o.ETag != ""}
+}
+
+// metadataJSON готовит пользовательские метаданные для колонки jsonb; пустые хранятся как NULL.
+func metadataJSON(m map[string]string) (interface{}, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
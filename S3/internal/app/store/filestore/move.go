@@ -0,0 +1,257 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+)
+
+var ErrSameObject = errors.New("source and destination are the same file")
+
+// Copy копирует файл на стороне сервера в dstBucket под именем dstFilename вместе
+// с типом содержимого, пользовательскими метаданными и тегами. Ссылки на файл не копируются.
+// Существующий файл перезаписывается, только если overwrite.
+func (f *FileStore) Copy(ctx context.Context, userID int, bucket, filename, dstBucket, dstFilename string, overwrite bool) (*Object, error) {
+	if bucket == dstBucket && filename == dstFilename {
+		return nil, ErrSameObject
+	}
+	if err := f.checkDestination(ctx, userID, dstBucket, dstFilename, overwrite); err != nil {
+		return nil, err
+	}
+	return f.copyObject(ctx, userID, bucket, filename, dstBucket, dstFilename, overwrite)
+}
+
+// Move переносит файл под новое имя, в другую папку или другой бакет.
+// Если ни один из бакетов не версионируется, строка файла и ссылки на него переезжают
+// в одной транзакции вместе с тегами, а содержимое — переименованием в хранилище (содержимое,
+// хранящееся по хешу, остается на месте), так что идентификаторы ссылок сохраняются. Иначе файл копируется, ссылки переносятся на копию, а исходный
+// файл удаляется по правилам своего бакета: история версий остается под прежним именем.
+// Существующий файл перезаписывается, только если overwrite.
+func (f *FileStore) Move(ctx context.Context, userID int, bucket, filename, dstBucket, dstFilename string, overwrite bool) (*Object, error) {
+	if bucket == dstBucket && filename == dstFilename {
+		return nil, ErrSameObject
+	}
+	if err := f.checkDestination(ctx, userID, dstBucket, dstFilename, overwrite); err != nil {
+		return nil, err
+	}
+
+	srcVersioned, err := f.VersioningEnabled(ctx, userID, bucket)
+	if err != nil {
+		return nil, err
+	}
+	dstVersioned, err := f.VersioningEnabled(ctx, userID, dstBucket)
+	if err != nil {
+		return nil, err
+	}
+	if srcVersioned || dstVersioned {
+		return f.moveByCopy(ctx, userID, bucket, filename, dstBucket, dstFilename, overwrite, srcVersioned)
+	}
+
+	if overwrite {
+		// Версионирование приостановлено: перезаписываемая версия остается в истории, как при загрузке.
+		if err := f.archiveCurrent(ctx, userID, dstBucket, dstFilename, false); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	hash, err := f.moveFile(ctx, tx, userID, bucket, filename, dstBucket, dstFilename, overwrite)
+	if err != nil {
+		return nil, err
+	}
+
+	src, dst := blobKey(userID, bucket, filename), blobKey(userID, dstBucket, dstFilename)
+	if hash.Valid {
+		// Содержимое по хешу не зависит от имени файла, переезжает только строка.
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if overwrite {
+			if err := f.Blobs.Delete(ctx, dst); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+				return nil, err
+			}
+		}
+	} else {
+		if err := f.Blobs.Rename(ctx, src, dst); err != nil {
+			if errors.Is(err, blobstore.ErrNotFound) {
+				return nil, ErrObjectNotFound
+			}
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			_ = f.Blobs.Rename(context.Background(), dst, src)
+			return nil, err
+		}
+	}
+	if overwrite {
+		if err := f.freeBlobs(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return f.Head(ctx, userID, dstBucket, dstFilename)
+}
+
+// moveFile переносит строку файла, его ссылки и теги под новое имя в транзакции tx и возвращает
+// хеш содержимого. Содержимое, хранящееся под ключом файла, переносит вызывающий.
+func (f *FileStore) moveFile(ctx context.Context, tx *sql.Tx, userID int, bucket, filename, dstBucket, dstFilename string, overwrite bool) (sql.NullString, error) {
+	row, err := f.lockFile(ctx, tx, userID, bucket, filename)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+
+	if overwrite {
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3",
+			userID,
+			dstBucket,
+			dstFilename,
+		); err != nil {
+			return sql.NullString{}, err
+		}
+		// Ссылки на перезаписанный файл не должны открывать перенесенный.
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM shares WHERE userid = $1 AND bucket = $2 AND filename = $3",
+			userID,
+			dstBucket,
+			dstFilename,
+		); err != nil {
+			return sql.NullString{}, err
+		}
+		if err := deleteTags(ctx, tx, userID, dstBucket, dstFilename); err != nil {
+			return sql.NullString{}, err
+		}
+	}
+	if row.versionID.Valid {
+		// Содержимое текущей версии хранится только под ключом файла и уезжает вместе с ним.
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM file_versions WHERE userid = $1 AND bucket = $2 AND filename = $3 AND version_id = $4",
+			userID,
+			bucket,
+			filename,
+			row.versionID.String,
+		); err != nil {
+			return sql.NullString{}, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE files SET bucket = $4, filename = $5, version_id = NULL
+		WHERE userid = $1 AND bucket = $2 AND filename = $3`,
+		userID,
+		bucket,
+		filename,
+		dstBucket,
+		dstFilename,
+	); err != nil {
+		if isUniqueViolation(err) {
+			return sql.NullString{}, ErrObjectAlreadyExists
+		}
+		return sql.NullString{}, err
+	}
+	if err := f.moveShares(ctx, tx, userID, bucket, filename, dstBucket, dstFilename); err != nil {
+		return sql.NullString{}, err
+	}
+	if err := moveTags(ctx, tx, userID, bucket, filename, dstBucket, dstFilename); err != nil {
+		return sql.NullString{}, err
+	}
+	return row.hash, nil
+}
+
+// moveByCopy переносит файл копированием, когда участвует бакет с версионированием.
+func (f *FileStore) moveByCopy(ctx context.Context, userID int, bucket, filename, dstBucket, dstFilename string, overwrite, srcVersioned bool) (*Object, error) {
+	o, err := f.copyObject(ctx, userID, bucket, filename, dstBucket, dstFilename, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.moveShares(ctx, f.Files, userID, bucket, filename, dstBucket, dstFilename); err != nil {
+		return nil, err
+	}
+
+	if srcVersioned {
+		if _, err := f.DeleteVersioned(ctx, userID, bucket, filename); err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+	if err := f.Delete(ctx, userID, bucket, filename); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (f *FileStore) copyObject(ctx context.Context, userID int, bucket, filename, dstBucket, dstFilename string, overwrite bool) (*Object, error) {
+	src, o, err := f.Open(ctx, userID, bucket, filename, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(src io.Closer) {
+		_ = src.Close()
+	}(src)
+
+	if overwrite {
+		if err := f.deleteShares(ctx, f.Files, userID, dstBucket, dstFilename); err != nil {
+			return nil, err
+		}
+	}
+	dst, err := f.writeObject(ctx, userID, dstBucket, dstFilename, src, Metadata{ContentType: o.ContentType, User: o.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	if err := copyTags(ctx, f.Files, userID, bucket, filename, dstBucket, dstFilename); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// checkDestination проверяет, что в dstBucket можно записать dstFilename: бакет существует,
+// путь не конфликтует с папками, а без overwrite файла с таким именем еще нет.
+func (f *FileStore) checkDestination(ctx context.Context, userID int, dstBucket, dstFilename string, overwrite bool) error {
+	if err := f.checkBucket(ctx, userID, dstBucket); err != nil {
+		return err
+	}
+	if err := f.checkPathConflict(ctx, userID, dstBucket, dstFilename); err != nil {
+		return err
+	}
+	if overwrite {
+		return nil
+	}
+
+	var exists bool
+	if err := f.Files.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3);",
+		userID,
+		dstBucket,
+		dstFilename,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrObjectAlreadyExists
+	}
+	return nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// moveShares переводит ссылки на файл на его новое имя, сохраняя их идентификаторы.
+func (f *FileStore) moveShares(ctx context.Context, db execer, userID int, bucket, filename, dstBucket, dstFilename string) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE shares SET bucket = $4, filename = $5 WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+		dstBucket,
+		dstFilename,
+	)
+	return err
+}
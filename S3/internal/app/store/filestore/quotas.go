@@ -0,0 +1,234 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+)
+
+var (
+	ErrQuotaExceeded     = errors.New("storage quota exceeded")
+	ErrFileQuotaExceeded = errors.New("file count quota exceeded")
+)
+
+// Quota — ограничения пользователя. Ноль означает "без ограничения".
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+// Usage — занятое пользователем место: текущее содержимое файлов, старые версии и корзина.
+// Части незавершенных составных загрузок временные и учитываются только при загрузке
+// новых частей. UsedBytes — размер самих файлов, по нему считается квота; StoredBytes —
+// сколько они занимают в хранилище после сжатия, причем одинаковое содержимое
+// нескольких файлов учитывается один раз. Remaining* не заданы, если ограничения нет.
+type Usage struct {
+	Quota
+	UsedBytes      int64  `json:"used_bytes"`
+	StoredBytes    int64  `json:"stored_bytes"`
+	UsedFiles      int64  `json:"used_files"`
+	RemainingBytes *int64 `json:"remaining_bytes,omitempty"`
+	RemainingFiles *int64 `json:"remaining_files,omitempty"`
+}
+
+// quotaLockClass — первый ключ advisory-блокировки квоты пользователя, второй — его id.
+const quotaLockClass = 0x71756f74
+
+// UserQuota возвращает ограничения пользователя: переопределения из user_quotas,
+// а где их нет — DefaultQuota.
+func (f *FileStore) UserQuota(ctx context.Context, userID int) (Quota, error) {
+	return f.userQuota(ctx, f.Files, userID)
+}
+
+func (f *FileStore) userQuota(ctx context.Context, db queryer, userID int) (Quota, error) {
+	q := f.DefaultQuota
+	var maxBytes, maxFiles sql.NullInt64
+	err := db.QueryRowContext(ctx,
+		"SELECT max_bytes, max_files FROM user_quotas WHERE userid = $1;",
+		userID,
+	).Scan(&maxBytes, &maxFiles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return q, nil
+	}
+	if err != nil {
+		return Quota{}, err
+	}
+
+	if maxBytes.Valid {
+		q.MaxBytes = maxBytes.Int64
+	}
+	if maxFiles.Valid {
+		q.MaxFiles = maxFiles.Int64
+	}
+	return q, nil
+}
+
+// Usage считает занятое пользователем место. Файлы, загруженные до появления
+// метаданных, не имеют размера в базе и не учитываются, а для файлов, записанных
+// до появления сжатия, место в хранилище считается равным их размеру.
+func (f *FileStore) Usage(ctx context.Context, userID int) (*Usage, error) {
+	q, err := f.UserQuota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return f.usage(ctx, f.Files, userID, q)
+}
+
+func (f *FileStore) usage(ctx context.Context, db queryer, userID int, q Quota) (*Usage, error) {
+	u := &Usage{Quota: q}
+	if err := db.QueryRowContext(ctx,
+		`WITH stored AS (
+			SELECT size, COALESCE(stored_size, size) AS stored_size, blob_hash FROM files WHERE userid = $1
+			UNION ALL
+			SELECT v.size, COALESCE(v.stored_size, v.size), v.blob_hash FROM file_versions v
+			WHERE v.userid = $1 AND NOT v.delete_marker AND NOT EXISTS (
+				SELECT 1 FROM files f WHERE f.userid = v.userid AND f.bucket = v.bucket AND f.filename = v.filename
+				AND COALESCE(f.version_id, $2) = v.version_id)
+			UNION ALL
+			SELECT size, COALESCE(stored_size, size), blob_hash FROM trash WHERE userid = $1
+		)
+		SELECT
+			(SELECT COUNT(*) FROM files WHERE userid = $1),
+			(SELECT COALESCE(SUM(size), 0) FROM stored),
+			(SELECT COALESCE(SUM(stored_size), 0) FROM stored WHERE blob_hash IS NULL) +
+			(SELECT COALESCE(SUM(stored_size), 0) FROM (
+				SELECT DISTINCT ON (blob_hash) stored_size FROM stored WHERE blob_hash IS NOT NULL) d)`,
+		userID,
+		NullVersionID,
+	).Scan(&u.UsedFiles, &u.UsedBytes, &u.StoredBytes); err != nil {
+		return nil, err
+	}
+
+	if q.MaxBytes > 0 {
+		remaining := max(q.MaxBytes-u.UsedBytes, 0)
+		u.RemainingBytes = &remaining
+	}
+	if q.MaxFiles > 0 {
+		remaining := max(q.MaxFiles-u.UsedFiles, 0)
+		u.RemainingFiles = &remaining
+	}
+	return u, nil
+}
+
+// limitToQuota проверяет, что пользователь может записать filename, и ограничивает r
+// оставшимся местом за вычетом pending байт, не учтенных в Usage. Новая версия
+// существующего файла число файлов не увеличивает, а место заменяемого содержимого
+// считается освобождаемым; пустой filename ничего из этого не проверяет. Это
+// предварительная проверка: параллельные записи ее не видят, а в бакете с версионированием
+// место заменяемой версии не освобождается, поэтому окончательно квоту проверяет
+// commitObject в транзакции записи (см. lockQuota).
+func (f *FileStore) limitToQuota(ctx context.Context, userID int, bucket, filename string, pending int64, r io.Reader) (io.Reader, error) {
+	u, err := f.Usage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.MaxBytes <= 0 && u.MaxFiles <= 0 {
+		return r, nil
+	}
+
+	exists := false
+	var replaced int64
+	if filename != "" {
+		err := f.Files.QueryRowContext(ctx,
+			"SELECT COALESCE(size, 0) FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3;",
+			userID,
+			bucket,
+			filename,
+		).Scan(&replaced)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		exists = err == nil
+	}
+	if u.MaxFiles > 0 && filename != "" && !exists && u.UsedFiles >= u.MaxFiles {
+		return nil, ErrFileQuotaExceeded
+	}
+
+	if u.MaxBytes <= 0 {
+		return r, nil
+	}
+	remaining := u.MaxBytes - u.UsedBytes - pending + replaced
+	if remaining < 0 {
+		return nil, ErrQuotaExceeded
+	}
+	return &quotaReader{r: r, remaining: remaining}, nil
+}
+
+// quotaCheck — перепроверка квоты при фиксации записи файла.
+type quotaCheck struct {
+	Quota
+	userID int
+	// newFile — запись добавляет файл, а не заменяет существующий.
+	newFile bool
+}
+
+// lockQuota блокирует квоту владельца o до конца транзакции tx: параллельные записи
+// одного пользователя фиксируются по очереди, и каждая видит файлы предыдущих.
+// Возвращает nil, если o — не файл или у пользователя нет ограничений.
+func (f *FileStore) lockQuota(ctx context.Context, tx *sql.Tx, o *Object) (*quotaCheck, error) {
+	if o.owner == 0 {
+		return nil, nil
+	}
+	q, err := f.userQuota(ctx, tx, o.owner)
+	if err != nil {
+		return nil, err
+	}
+	if q.MaxBytes <= 0 && q.MaxFiles <= 0 {
+		return nil, nil
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2);", quotaLockClass, o.owner); err != nil {
+		return nil, err
+	}
+
+	c := &quotaCheck{Quota: q, userID: o.owner}
+	if q.MaxFiles > 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3);",
+			o.owner,
+			o.bucket,
+			o.Key,
+		).Scan(&exists); err != nil {
+			return nil, err
+		}
+		c.newFile = !exists
+	}
+	return c, nil
+}
+
+// checkQuota проверяет, что с записанной в tx строкой файла пользователь укладывается
+// в квоту. Занятое место считается уже после записи, поэтому заменяемое содержимое
+// учитывается, только если оно остается в истории версий.
+func (f *FileStore) checkQuota(ctx context.Context, tx *sql.Tx, c *quotaCheck) error {
+	u, err := f.usage(ctx, tx, c.userID, c.Quota)
+	if err != nil {
+		return err
+	}
+	if c.MaxFiles > 0 && c.newFile && u.UsedFiles > c.MaxFiles {
+		return ErrFileQuotaExceeded
+	}
+	if c.MaxBytes > 0 && u.UsedBytes > c.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// quotaReader возвращает ErrQuotaExceeded, как только прочитано больше remaining байт.
+// Backend при ошибке чтения не сохраняет недописанное содержимое.
+type quotaReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > q.remaining+1 {
+		p = p[:q.remaining+1]
+	}
+	n, err := q.r.Read(p)
+	if int64(n) > q.remaining {
+		return 0, ErrQuotaExceeded
+	}
+	q.remaining -= int64(n)
+	return n, err
+}
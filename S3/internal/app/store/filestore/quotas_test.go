@@ -0,0 +1,152 @@
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSaveConcurrentQuota(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	f.Dedup = false
+	content := []byte("0123456789")
+
+	// Обе записи проходят предварительную проверку, пока ни одна не зафиксирована:
+	// вместе они превышают квоту в 15 байт.
+	first, second := newGatedReader(content), newGatedReader(content)
+	for _, filename := range []string{"a.txt", "b.txt"} {
+		expectPathCheck(mock)
+		expectLimitedQuota(mock, 15, 0, 0)
+		expectReplaced(mock, filename)
+	}
+	for i, filename := range []string{"b.txt", "a.txt"} {
+		mock.ExpectBegin()
+		expectQuotaLock(mock, 15)
+		mock.ExpectExec(sqlPrefix("INSERT INTO files")).
+			WithArgs(testUser, "", filename, len(content), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil, nil,
+				len(content), nil, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// Под блокировкой вторая запись видит файл первой.
+		mock.ExpectQuery(sqlPrefix("WITH stored AS")).
+			WillReturnRows(sqlmock.NewRows([]string{"files", "size", "stored_size"}).
+				AddRow(i+1, (i+1)*len(content), (i+1)*len(content)))
+		if i == 0 {
+			mock.ExpectCommit()
+		} else {
+			mock.ExpectRollback()
+		}
+	}
+
+	save := func(filename string, r io.Reader) <-chan error {
+		done := make(chan error, 1)
+		go func() {
+			done <- f.Save(context.Background(), testUser, "", filename, r, Metadata{})
+		}()
+		return done
+	}
+	firstDone := save("a.txt", first)
+	<-first.started
+	secondDone := save("b.txt", second)
+	<-second.started
+
+	close(second.release)
+	if err := <-secondDone; err != nil {
+		t.Fatalf("first committed Save() error = %v", err)
+	}
+	close(first.release)
+	if err := <-firstDone; !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("second committed Save() error = %v, want ErrQuotaExceeded", err)
+	}
+
+	checkBlobs(t, blobs, map[string][]byte{blobKey(testUser, "", "b.txt"): content})
+	checkExpectations(t, mock)
+}
+
+func TestLimitToQuotaReplaced(t *testing.T) {
+	content := bytes.Repeat([]byte{1}, 10)
+
+	tests := []struct {
+		name     string
+		replaced int
+		want     error
+	}{
+		{"new file", -1, ErrQuotaExceeded},
+		{"overwrite of a smaller file", 4, ErrQuotaExceeded},
+		{"overwrite of a file of the same size", 10, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, _ := newTestStore(t)
+			// Занято 95 байт из 100.
+			expectLimitedQuota(mock, 100, 3, 95)
+			rows := sqlmock.NewRows([]string{"size"})
+			if tc.replaced >= 0 {
+				rows.AddRow(tc.replaced)
+			}
+			mock.ExpectQuery(sqlPrefix("SELECT COALESCE(size, 0) FROM files")).
+				WithArgs(testUser, "", "a.txt").
+				WillReturnRows(rows)
+
+			r, err := f.limitToQuota(context.Background(), testUser, "", "a.txt", 0, bytes.NewReader(content))
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if !errors.Is(err, tc.want) {
+				t.Errorf("error = %v, want %v", err, tc.want)
+			}
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+// expectLimitedQuota ожидает проверку квоты maxBytes, когда у пользователя files файлов на used байт.
+func expectLimitedQuota(mock sqlmock.Sqlmock, maxBytes, files, used int) {
+	mock.ExpectQuery(sqlPrefix("SELECT max_bytes, max_files FROM user_quotas")).
+		WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"max_bytes", "max_files"}).AddRow(maxBytes, nil))
+	mock.ExpectQuery(sqlPrefix("WITH stored AS")).
+		WillReturnRows(sqlmock.NewRows([]string{"files", "size", "stored_size"}).AddRow(files, used, used))
+}
+
+// expectReplaced ожидает поиск заменяемого файла filename: его нет.
+func expectReplaced(mock sqlmock.Sqlmock, filename string) {
+	mock.ExpectQuery(sqlPrefix("SELECT COALESCE(size, 0) FROM files")).
+		WithArgs(testUser, "", filename).
+		WillReturnRows(sqlmock.NewRows([]string{"size"}))
+}
+
+// expectQuotaLock ожидает блокировку квоты maxBytes в транзакции записи.
+func expectQuotaLock(mock sqlmock.Sqlmock, maxBytes int) {
+	mock.ExpectQuery(sqlPrefix("SELECT max_bytes, max_files FROM user_quotas")).
+		WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"max_bytes", "max_files"}).AddRow(maxBytes, nil))
+	mock.ExpectExec(sqlPrefix("SELECT pg_advisory_xact_lock")).
+		WithArgs(quotaLockClass, testUser).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// gatedReader отдает содержимое, только когда закрыт release, а о первом чтении
+// сообщает закрытием started.
+type gatedReader struct {
+	r        io.Reader
+	started  chan struct{}
+	release  chan struct{}
+	announce sync.Once
+}
+
+func newGatedReader(content []byte) *gatedReader {
+	return &gatedReader{r: bytes.NewReader(content), started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	g.announce.Do(func() {
+		close(g.started)
+	})
+	<-g.release
+	return g.r.Read(p)
+}
@@ -0,0 +1,267 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrShareNotFound      = errors.New("share link not found")
+	ErrShareExpired       = errors.New("share link has expired or been revoked")
+	ErrSharePassword      = errors.New("share link password is missing or wrong")
+	ErrShareLimitExceeded = errors.New("share link download limit reached")
+)
+
+// Share — публичная ссылка на файл или папку. У файла может быть несколько ссылок
+// с разными сроком действия, паролем и лимитом скачиваний.
+type Share struct {
+	ID       string `json:"id"`
+	UserID   int    `json:"-"`
+	Bucket   string `json:"bucket"`
+	Filename string `json:"filename"`
+	// Folder — ссылка на папку: Filename — префикс с "/" на конце, по ссылке
+	// скачивается архив файлов под ним.
+	Folder       bool       `json:"folder"`
+	HasPassword  bool       `json:"has_password"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads *int       `json:"max_downloads,omitempty"`
+	Downloads    int        `json:"downloads"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	passwordHash string
+}
+
+// ShareOptions — ограничения новой ссылки. Нулевые значения означают "без ограничения".
+type ShareOptions struct {
+	ExpiresAt    time.Time
+	Password     string
+	MaxDownloads int
+	// Folder — поделиться папкой filename, а не файлом.
+	Folder bool
+}
+
+const shareColumns = `id, userid, bucket, filename, folder, COALESCE(password_hash, ''), expires_at,
+	max_downloads, downloads, revoked_at, created_at`
+
+// Share создает новую ссылку на существующий файл или на папку, в которой есть файлы.
+func (f *FileStore) Share(ctx context.Context, userID int, bucket, filename string, opts ShareOptions) (*Share, error) {
+	return f.share(ctx, f.Files, userID, bucket, filename, opts)
+}
+
+func (f *FileStore) share(ctx context.Context, db queryer, userID int, bucket, filename string, opts ShareOptions) (*Share, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3);"
+	if opts.Folder {
+		filename = strings.TrimSuffix(filename, "/") + "/"
+		query = "SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2 AND starts_with(filename, $3));"
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, query, userID, bucket, filename).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return nil, err
+		}
+		return nil, ErrObjectNotFound
+	}
+
+	var (
+		passwordHash sql.NullString
+		expiresAt    sql.NullTime
+		maxDownloads sql.NullInt64
+	)
+	if opts.Password != "" {
+		b, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash = sql.NullString{String: string(b), Valid: true}
+	}
+	if !opts.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: opts.ExpiresAt, Valid: true}
+	}
+	if opts.MaxDownloads > 0 {
+		maxDownloads = sql.NullInt64{Int64: int64(opts.MaxDownloads), Valid: true}
+	}
+
+	row := db.QueryRowContext(ctx,
+		`INSERT INTO shares (userid, bucket, filename, folder, password_hash, expires_at, max_downloads)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+shareColumns,
+		userID,
+		bucket,
+		filename,
+		opts.Folder,
+		passwordHash,
+		expiresAt,
+		maxDownloads,
+	)
+	return scanShare(row)
+}
+
+// ListShares возвращает ссылки пользователя на файл, а при пустом filename —
+// на все файлы бакета, включая истекшие и отозванные.
+func (f *FileStore) ListShares(ctx context.Context, userID int, bucket, filename string) ([]Share, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT `+shareColumns+` FROM shares
+		WHERE userid = $1 AND bucket = $2 AND ($3 = '' OR filename = $3)
+		ORDER BY filename, created_at`,
+		userID,
+		bucket,
+		filename,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var shares []Share
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, *s)
+	}
+
+	return shares, rows.Err()
+}
+
+// RevokeShare отзывает ссылку. Отозванная ссылка остается в списке ссылок файла.
+func (f *FileStore) RevokeShare(ctx context.Context, userID int, id string) error {
+	return f.revokeShare(ctx, f.Files, userID, id)
+}
+
+func (f *FileStore) revokeShare(ctx context.Context, db execer, userID int, id string) error {
+	res, err := db.ExecContext(ctx,
+		"UPDATE shares SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1 AND userid = $2",
+		id,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// FindByUUID возвращает действующую ссылку, проверив срок, отзыв, лимит скачиваний и пароль.
+func (f *FileStore) FindByUUID(ctx context.Context, uuid, password string) (*Share, error) {
+	s, err := scanShare(f.Files.QueryRowContext(ctx,
+		"SELECT "+shareColumns+" FROM shares WHERE id = $1;",
+		uuid,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.RevokedAt != nil || (s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now())) {
+		return nil, ErrShareExpired
+	}
+	if s.MaxDownloads != nil && s.Downloads >= *s.MaxDownloads {
+		return nil, ErrShareLimitExceeded
+	}
+	if s.HasPassword && bcrypt.CompareHashAndPassword([]byte(s.passwordHash), []byte(password)) != nil {
+		return nil, ErrSharePassword
+	}
+	return s, nil
+}
+
+// CountShareDownload учитывает скачивание по ссылке. Если лимит уже исчерпан
+// параллельными скачиваниями, возвращает ErrShareLimitExceeded.
+func (f *FileStore) CountShareDownload(ctx context.Context, id string) error {
+	res, err := f.Files.ExecContext(ctx,
+		`UPDATE shares SET downloads = downloads + 1
+		WHERE id = $1 AND (max_downloads IS NULL OR downloads < max_downloads)`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrShareLimitExceeded
+	}
+	return nil
+}
+
+// revokeFileShares отзывает все действующие ссылки на файл и возвращает их число.
+func (f *FileStore) revokeFileShares(ctx context.Context, db execer, userID int, bucket, filename string) (int, error) {
+	res, err := db.ExecContext(ctx,
+		`UPDATE shares SET revoked_at = now()
+		WHERE userid = $1 AND bucket = $2 AND filename = $3 AND revoked_at IS NULL`,
+		userID,
+		bucket,
+		filename,
+	)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// deleteShares удаляет ссылки на файл, чтобы они не открыли файл, загруженный позже под тем же именем.
+func (f *FileStore) deleteShares(ctx context.Context, db execer, userID int, bucket, filename string) error {
+	_, err := db.ExecContext(ctx,
+		"DELETE FROM shares WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+	)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanShare(row rowScanner) (*Share, error) {
+	var (
+		s            Share
+		expiresAt    sql.NullTime
+		maxDownloads sql.NullInt64
+		revokedAt    sql.NullTime
+	)
+	if err := row.Scan(&s.ID, &s.UserID, &s.Bucket, &s.Filename, &s.Folder, &s.passwordHash, &expiresAt,
+		&maxDownloads, &s.Downloads, &revokedAt, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	s.HasPassword = s.passwordHash != ""
+	if expiresAt.Valid {
+		s.ExpiresAt = &expiresAt.Time
+	}
+	if maxDownloads.Valid {
+		n := int(maxDownloads.Int64)
+		s.MaxDownloads = &n
+	}
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return &s, nil
+}
@@ -1,57 +1,50 @@
 package filestore
 
 import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type FileStore struct {
-	Files     *sql.DB
-	StorePath string
+	Files *sql.DB
+	Blobs blobstore.BlobBackend
+	// DefaultQuota действует для пользователей без записи в user_quotas.
+	DefaultQuota Quota
+	// TrashRetention — сколько файлы лежат в корзине до автоматического удаления; 0 — без срока.
+	TrashRetention time.Duration
+	// UploadTTL — через сколько после последнего запроса удаляется брошенная загрузка tus; 0 — без срока.
+	UploadTTL time.Duration
+	// Compression — какие файлы сжимаются при записи; по умолчанию не сжимаются.
+	Compression CompressionPolicy
+	// ChunkSize — средний размер фрагментов, на которые делится содержимое файлов
+	// (см. blobstore.Chunker); 0 — файлы хранятся целиком. Требует Dedup.
+	ChunkSize int64
+	// Dedup — новое содержимое хранится по хешу, одна копия на всех пользователей (см. blobs.go).
+	// Без него содержимое лежит под ключами самих файлов и у каждого блоба один владелец:
+	// так шифрование ключами пользователей (KeyOwner) покрывает все содержимое.
+	Dedup bool
 }
 
-func New(files *sql.DB, storePath string) *FileStore {
+func New(files *sql.DB, blobs blobstore.BlobBackend) *FileStore {
 	return &FileStore{
-		Files:     files,
-		StorePath: storePath,
+		Files: files,
+		Blobs: blobs,
+		Dedup: true,
 	}
 }
 
-func (f *FileStore) FindFilesWithDates(id int) ([]string, []int, error) {
-	rows, err := f.Files.Query("SELECT filename, uploaded_at FROM files WHERE userid = $1;", id)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer func(rows *sql.Rows) {
-		_ = rows.Close()
-	}(rows)
-
-	var fileNames []string
-	var fileUploadTimes []int
-
-	for rows.Next() {
-		var name string
-		var uploadedAt time.Time
-		if err := rows.Scan(&name, &uploadedAt); err != nil {
-			return nil, nil, err
-		}
-		fileNames = append(fileNames, name)
-		fileUploadTimes = append(fileUploadTimes, int(uploadedAt.Unix()-10800)) // Преобразование времени в Unix timestamp с учетом часового пояса
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Printf("Ошибка после итерации: %v", err)
-		return nil, nil, err
-	}
-
-	return fileNames, fileUploadTimes, nil
-}
-
-func (f *FileStore) FindFiles(id int) ([]string, error) {
-	rows, err := f.Files.Query("SELECT filename FROM files WHERE userid = $1;", id)
+func (f *FileStore) FindFiles(id int, bucket string) ([]string, error) {
+	rows, err := f.Files.Query("SELECT filename FROM files WHERE userid = $1 AND bucket = $2;", id, bucket)
 	if err != nil {
 		return nil, err
 	}
@@ -77,97 +70,195 @@ func (f *FileStore) FindFiles(id int) ([]string, error) {
 	return fileNames, nil
 }
 
-func (f *FileStore) Save(userID int, filename string, fileBytes []byte) error {
-	tx, err := f.Files.Begin()
-	if err != nil {
+// Save потоково записывает содержимое r в хранилище и регистрирует файл в базе
+// вместе с размером, типом содержимого, SHA-256 и пользовательскими метаданными.
+// Если метаданные записать не удалось, содержимое удаляется. Превышение квоты
+// прерывает запись с ErrQuotaExceeded или ErrFileQuotaExceeded.
+func (f *FileStore) Save(ctx context.Context, userID int, bucket, filename string, r io.Reader, meta Metadata) error {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return err
+	}
+	if err := f.checkPathConflict(ctx, userID, bucket, filename); err != nil {
 		return err
 	}
-	defer tx.Rollback()
 
-	_, err = tx.Exec(
-		"INSERT INTO files (userid, filename) VALUES ($1, $2)",
-		userID,
-		filename,
-	)
+	o, err := f.putBlob(ctx, userID, bucket, filename, r, meta)
 	if err != nil {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
+	metadata, err := metadataJSON(o.Metadata)
+	if err != nil {
 		return err
 	}
-
-	dirPath := fmt.Sprintf("%s/%d", f.StorePath, userID)
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		if err := os.MkdirAll(dirPath, 0755); err != nil {
-			return err
+	err = f.commitObject(ctx, o, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO files (userid, bucket, filename, size, content_type, sha256, metadata,
+				key_fingerprint, compression, stored_size, blob_hash, etag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			userID,
+			bucket,
+			filename,
+			o.Size,
+			o.ContentType,
+			o.SHA256,
+			metadata,
+			o.keyFingerprint(),
+			o.compression(),
+			o.StoredSize,
+			o.blobHash(),
+			o.etagColumn(),
+		)
+		return err
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrObjectAlreadyExists
 		}
-	}
-	if err := os.WriteFile(fmt.Sprintf("%s/%s", dirPath, filename), fileBytes, 0644); err != nil {
 		return err
 	}
+
 	return nil
 }
 
-func (f *FileStore) GetFullFileName(userID int, filename string) (string, error) {
-	_, err := os.ReadFile(fmt.Sprintf("%s/%d/%s", f.StorePath, userID, filename))
+func (f *FileStore) GetFileBytes(ctx context.Context, userID int, bucket, filename string, key []byte) ([]byte, error) {
+	file, _, err := f.Open(ctx, userID, bucket, filename, key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(fmt.Sprintf("%s/%d/%s", f.StorePath, userID, filename)), nil
+	defer func(file io.Closer) {
+		_ = file.Close()
+	}(file)
+
+	return io.ReadAll(file)
 }
 
-func (f *FileStore) GetFileBytes(userID int, filename string) ([]byte, error) {
-	file, err := os.ReadFile(fmt.Sprintf("%s/%d/%s", f.StorePath, userID, filename))
+// Open открывает файл пользователя для потокового чтения и возвращает его метаданные.
+// Файл, загруженный с ключом клиента, открывается только с тем же ключом key.
+// Закрыть файл должен вызывающий.
+func (f *FileStore) Open(ctx context.Context, userID int, bucket, filename string, key []byte) (io.ReadSeekCloser, *Object, error) {
+	o, err := f.Head(ctx, userID, bucket, filename)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return file, nil
-}
 
-func (f *FileStore) Delete(userID int, filename string) error {
-	tx, err := f.Files.Begin()
+	file, err := f.openBlob(ctx, blobKey(userID, bucket, filename), o, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil, nil, ErrObjectNotFound
+	}
 	if err != nil {
+		return nil, nil, err
+	}
+	return file, o, nil
+}
+
+// Delete безвозвратно удаляет текущее содержимое файла, ссылки на него и его теги. Старые версии, если они есть, остаются.
+// Содержимое, хранящееся по хешу, удаляется, только если на него больше ничего не ссылается.
+func (f *FileStore) Delete(ctx context.Context, userID int, bucket, filename string) error {
+	if _, err := f.deleteFile(ctx, f.Files, userID, bucket, filename); err != nil {
 		return err
 	}
-	defer tx.Rollback()
-	_, err = f.Files.Exec("DELETE FROM files WHERE userid = $1 AND filename = $2", userID, filename)
-	if err != nil {
-		err := tx.Rollback()
-		if err != nil {
-			return err
-		}
+
+	if err := f.Blobs.Delete(ctx, blobKey(userID, bucket, filename)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
 		return err
 	}
-	if err := tx.Commit(); err != nil {
-		if _, err := os.Stat(fmt.Sprintf("%d/%s", userID, filename)); err != nil {
-			return err
+	return f.freeBlobs(ctx)
+}
+
+type execQueryer interface {
+	execer
+	queryer
+}
+
+// deleteFile удаляет строку файла, его текущую версию, ссылки и теги, не трогая содержимое.
+// Возвращает false, если файла не было.
+func (f *FileStore) deleteFile(ctx context.Context, db execQueryer, userID int, bucket, filename string) (bool, error) {
+	var versionID sql.NullString
+	err := db.QueryRowContext(ctx,
+		"DELETE FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3 RETURNING version_id",
+		userID,
+		bucket,
+		filename,
+	).Scan(&versionID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	found := err == nil
+
+	if versionID.Valid {
+		// Содержимое текущей версии хранится только под ключом файла, поэтому версия исчезает вместе с ним.
+		if _, err := db.ExecContext(ctx,
+			"DELETE FROM file_versions WHERE userid = $1 AND bucket = $2 AND filename = $3 AND version_id = $4",
+			userID,
+			bucket,
+			filename,
+			versionID.String,
+		); err != nil {
+			return false, err
 		}
-		if err := os.Remove(fmt.Sprintf("%d/%s", userID, filename)); err != nil {
-			return err
+	}
+
+	if err := f.deleteShares(ctx, db, userID, bucket, filename); err != nil {
+		return false, err
+	}
+	if err := deleteTags(ctx, db, userID, bucket, filename); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// checkPathConflict не дает в корневом пространстве завести файл "a/b" рядом с файлом "a"
+// и наоборот: там пути с "/" раскладываются по каталогам на диске.
+func (f *FileStore) checkPathConflict(ctx context.Context, userID int, bucket, filename string) error {
+	if bucket != "" {
+		return nil
+	}
+
+	var parents []string
+	for i := range filename {
+		if filename[i] == '/' {
+			parents = append(parents, filename[:i])
 		}
 	}
+
+	var conflict bool
+	if err := f.Files.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = '' AND
+		(filename = ANY($2) OR starts_with(filename, $3)));`,
+		userID,
+		pq.Array(parents),
+		filename+"/",
+	).Scan(&conflict); err != nil {
+		return err
+	}
+	if conflict {
+		return ErrPathConflict
+	}
 	return nil
 }
 
-func (f *FileStore) FindByUUID(uuid string) (int, string, error) {
-	var (
-		userID   int
-		filename string
-	)
-	if err := f.Files.QueryRow("SELECT userid, filename FROM files WHERE uuid = $1 and public = true LIMIT 1;", uuid).
-		Scan(&userID, &filename); err != nil {
-		return 0, "", err
+// blobKey — ключ содержимого файла в BlobBackend. Файлы корневого пространства
+// лежат в <userID>/<filename>, объекты бакетов — отдельно, а "/" в ключе объекта
+// экранируется, чтобы ключи вида "a//b" или "dir/" не зависели от файловой системы.
+func blobKey(userID int, bucket, filename string) string {
+	if bucket == "" {
+		return fmt.Sprintf("%d/%s", userID, filename)
 	}
-
-	return userID, filename, nil
+	return fmt.Sprintf("buckets/%d/%s/%s", userID, bucket, url.PathEscape(filename))
 }
 
-func (f *FileStore) Share(id int, filename string) (string, error) {
-	var Uuid string
-	err := f.Files.QueryRow("UPDATE files SET public = true WHERE userid = $1 AND filename = $2 RETURNING uuid", id, filename).Scan(&Uuid)
-	if err != nil {
-		return "", err
+// KeyOwner возвращает идентификатор пользователя, которому принадлежит блоб с ключом key,
+// или "" для ключей без владельца — частей незавершенных загрузок и общего содержимого,
+// хранящегося по хешу. По нему шифрование выводит ключи отдельных пользователей.
+// Общее содержимое шифруется общим ключом, поэтому вместе с ключами пользователей
+// хранение по хешу выключают (FileStore.Dedup).
+func KeyOwner(key string) string {
+	first, rest, _ := strings.Cut(key, "/")
+	switch first {
+	case "buckets", "versions", "trash", "staging":
+		first, _, _ = strings.Cut(rest, "/")
+	case "uploads", "tus", "cas":
+		return ""
 	}
-	return Uuid, nil
+	return first
 }
@@ -0,0 +1,417 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// Тесты FileStore работают без базы и диска: запросы проверяет sqlmock, содержимое
+// хранится в MemoryBackend.
+
+const testUser = 1
+
+func TestSave(t *testing.T) {
+	content := []byte("hello, world")
+	hash := sha256Hex(content)
+
+	tests := []struct {
+		name  string
+		dedup bool
+		key   string
+	}{
+		{"by hash", true, casKey(hash)},
+		{"under the file key", false, blobKey(testUser, "", "a.txt")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			f.Dedup = tc.dedup
+
+			expectPathCheck(mock)
+			expectQuota(mock)
+			mock.ExpectBegin()
+			expectQuotaLimits(mock)
+			if tc.dedup {
+				mock.ExpectExec(sqlPrefix("INSERT INTO blobs")).
+					WithArgs(hash, len(content), len(content), nil).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectExec(sqlPrefix("INSERT INTO files")).
+				WithArgs(testUser, "", "a.txt", len(content), "text/plain; charset=utf-8", hash, nil, nil, nil, len(content), blobHashArg(tc.dedup, hash), md5Hex(content)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			if err := f.Save(context.Background(), testUser, "", "a.txt", bytes.NewReader(content), Metadata{}); err != nil {
+				t.Fatal(err)
+			}
+			checkBlobs(t, blobs, map[string][]byte{tc.key: content})
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestSaveConflict(t *testing.T) {
+	old := []byte("old content")
+	content := []byte("new content")
+	exists := &pq.Error{Code: "23505"}
+
+	tests := []struct {
+		name  string
+		dedup bool
+		// created — содержимое по хешу добавлено этой записью и должно исчезнуть вместе с ней.
+		created bool
+	}{
+		{"under the file key", false, false},
+		{"new content by hash", true, true},
+		{"known content by hash", true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			f.Dedup = tc.dedup
+			key := blobKey(testUser, "", "a.txt")
+			putBlobs(t, blobs, map[string][]byte{key: old})
+			want := map[string][]byte{key: old}
+
+			expectPathCheck(mock)
+			expectQuota(mock)
+			mock.ExpectBegin()
+			expectQuotaLimits(mock)
+			if tc.dedup {
+				hash := sha256Hex(content)
+				if tc.created {
+					mock.ExpectExec(sqlPrefix("INSERT INTO blobs")).WillReturnResult(sqlmock.NewResult(0, 1))
+				} else {
+					putBlobs(t, blobs, map[string][]byte{casKey(hash): content})
+					want[casKey(hash)] = content
+					mock.ExpectExec(sqlPrefix("INSERT INTO blobs")).WillReturnResult(sqlmock.NewResult(0, 0))
+					mock.ExpectQuery(sqlPrefix("SELECT stored_size, compression FROM blobs")).
+						WithArgs(hash).
+						WillReturnRows(sqlmock.NewRows([]string{"stored_size", "compression"}).AddRow(len(content), nil))
+				}
+			}
+			mock.ExpectExec(sqlPrefix("INSERT INTO files")).WillReturnError(exists)
+			mock.ExpectRollback()
+
+			err := f.Save(context.Background(), testUser, "", "a.txt", bytes.NewReader(content), Metadata{})
+			if !errors.Is(err, ErrObjectAlreadyExists) {
+				t.Fatalf("Save() error = %v, want ErrObjectAlreadyExists", err)
+			}
+			// Существующий файл не тронут, записанное содержимое удалено.
+			checkBlobs(t, blobs, want)
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestSaveFailedCommit(t *testing.T) {
+	old := []byte("old content")
+	errCommit := errors.New("connection lost")
+
+	tests := []struct {
+		name string
+		meta Metadata
+	}{
+		{"plain", Metadata{}},
+		{"customer key", Metadata{CustomerKey: bytes.Repeat([]byte{7}, 32)}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			f.Dedup = false
+			key := blobKey(testUser, "", "a.txt")
+			putBlobs(t, blobs, map[string][]byte{key: old})
+
+			expectPathCheck(mock)
+			expectQuota(mock)
+			mock.ExpectBegin()
+			expectQuotaLimits(mock)
+			mock.ExpectExec(sqlPrefix("INSERT INTO files")).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit().WillReturnError(errCommit)
+
+			err := f.Save(context.Background(), testUser, "", "a.txt", bytes.NewReader([]byte("new content")), tc.meta)
+			if !errors.Is(err, errCommit) {
+				t.Fatalf("Save() error = %v, want %v", err, errCommit)
+			}
+			checkBlobs(t, blobs, map[string][]byte{key: old})
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestSaveMissingBucket(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	expectBucket(mock, "photos", false)
+
+	err := f.Save(context.Background(), testUser, "photos", "a.txt", bytes.NewReader([]byte("content")), Metadata{})
+	if !errors.Is(err, ErrBucketNotFound) {
+		t.Fatalf("Save() error = %v, want ErrBucketNotFound", err)
+	}
+	checkBlobs(t, blobs, nil)
+	checkExpectations(t, mock)
+}
+
+func TestPutObjectOverwrites(t *testing.T) {
+	old := []byte("old content")
+	content := []byte("new content")
+	hash := sha256Hex(content)
+	key := blobKey(testUser, "docs", "a.txt")
+
+	tests := []struct {
+		name  string
+		dedup bool
+		want  map[string][]byte
+	}{
+		// Содержимое под ключом файла заменяется новым.
+		{"under the file key", false, map[string][]byte{key: content}},
+		// Новое содержимое хранится по хешу, а прежнее под ключом файла больше не нужно.
+		{"by hash", true, map[string][]byte{casKey(hash): content}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			f.Dedup = tc.dedup
+			putBlobs(t, blobs, map[string][]byte{key: old})
+
+			expectBucket(mock, "docs", true)
+			mock.ExpectQuery(sqlPrefix("SELECT enabled FROM bucket_versioning")).
+				WithArgs(testUser, "docs").
+				WillReturnRows(sqlmock.NewRows([]string{"enabled"}))
+			mock.ExpectQuery(sqlPrefix("SELECT version_id, blob_hash FROM files")).
+				WithArgs(testUser, "docs", "a.txt").
+				WillReturnRows(sqlmock.NewRows([]string{"version_id", "blob_hash"}).AddRow(nil, nil))
+			expectQuota(mock)
+			mock.ExpectBegin()
+			expectQuotaLimits(mock)
+			if tc.dedup {
+				mock.ExpectExec(sqlPrefix("INSERT INTO blobs")).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectExec(sqlPrefix("INSERT INTO files") + `.*ON CONFLICT \(userid, bucket, filename\) DO UPDATE`).
+				WithArgs(testUser, "docs", "a.txt", len(content), "text/plain; charset=utf-8", hash, nil, nil, nil, len(content), blobHashArg(tc.dedup, hash), md5Hex(content)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			expectFreeBlobs(mock)
+			mock.ExpectQuery(sqlPrefix("SELECT uploaded_at, size")).
+				WithArgs(testUser, "docs", "a.txt").
+				WillReturnRows(fileRow(len(content), hash, md5Hex(content), blobHashArg(tc.dedup, hash)))
+
+			o, err := f.PutObject(context.Background(), testUser, "docs", "a.txt", bytes.NewReader(content), Metadata{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.Size != int64(len(content)) || o.SHA256 != hash || o.ETag != md5Hex(content) {
+				t.Errorf("PutObject() = size %d, sha256 %s, etag %s, want %d, %s, %s",
+					o.Size, o.SHA256, o.ETag, len(content), hash, md5Hex(content))
+			}
+			checkBlobs(t, blobs, tc.want)
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	content := []byte("content")
+	hash := sha256Hex(content)
+	key := blobKey(testUser, "", "a.txt")
+
+	tests := []struct {
+		name string
+		// unreferenced — на содержимое по хешу после удаления не осталось ссылок.
+		unreferenced []string
+		want         map[string][]byte
+	}{
+		{"last reference", []string{hash}, nil},
+		{"shared content", nil, map[string][]byte{casKey(hash): content}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, blobs := newTestStore(t)
+			putBlobs(t, blobs, map[string][]byte{key: content, casKey(hash): content})
+
+			expectDeleteFile(mock, "a.txt")
+			expectFreeBlobs(mock, tc.unreferenced...)
+
+			if err := f.Delete(context.Background(), testUser, "", "a.txt"); err != nil {
+				t.Fatal(err)
+			}
+			checkBlobs(t, blobs, tc.want)
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestDeleteMissing(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	other := blobKey(testUser, "", "b.txt")
+	putBlobs(t, blobs, map[string][]byte{other: []byte("content")})
+
+	mock.ExpectQuery(sqlPrefix("DELETE FROM files")).
+		WithArgs(testUser, "", "a.txt").
+		WillReturnRows(sqlmock.NewRows([]string{"version_id"}))
+	mock.ExpectExec(sqlPrefix("DELETE FROM shares")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(sqlPrefix("DELETE FROM file_tags")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectFreeBlobs(mock)
+
+	if err := f.Delete(context.Background(), testUser, "", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	checkBlobs(t, blobs, map[string][]byte{other: []byte("content")})
+	checkExpectations(t, mock)
+}
+
+func newTestStore(t *testing.T) (*FileStore, sqlmock.Sqlmock, *blobstore.MemoryBackend) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	blobs := blobstore.NewMemoryBackend()
+	return New(db, blobs), mock, blobs
+}
+
+// sqlPrefix — выражение для sqlmock, совпадающее с запросом, который начинается с prefix.
+func sqlPrefix(prefix string) string {
+	return "^" + regexp.QuoteMeta(prefix)
+}
+
+func expectBucket(mock sqlmock.Sqlmock, bucket string, exists bool) {
+	mock.ExpectQuery(sqlPrefix("SELECT EXISTS (SELECT 1 FROM buckets")).
+		WithArgs(testUser, bucket).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+}
+
+func expectPathCheck(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(sqlPrefix("SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = ''")).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+}
+
+// expectQuota ожидает проверку квоты пользователя без ограничений.
+func expectQuota(mock sqlmock.Sqlmock) {
+	expectQuotaLimits(mock)
+	mock.ExpectQuery(sqlPrefix("WITH stored AS")).
+		WillReturnRows(sqlmock.NewRows([]string{"files", "size", "stored_size"}).AddRow(0, 0, 0))
+}
+
+// expectQuotaLimits ожидает чтение ограничений пользователя: их нет.
+func expectQuotaLimits(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(sqlPrefix("SELECT max_bytes, max_files FROM user_quotas")).
+		WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"max_bytes", "max_files"}))
+}
+
+func expectDeleteFile(mock sqlmock.Sqlmock, filename string) {
+	mock.ExpectQuery(sqlPrefix("DELETE FROM files")).
+		WithArgs(testUser, "", filename).
+		WillReturnRows(sqlmock.NewRows([]string{"version_id"}).AddRow(nil))
+	mock.ExpectExec(sqlPrefix("DELETE FROM shares")).
+		WithArgs(testUser, "", filename).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(sqlPrefix("DELETE FROM file_tags")).
+		WithArgs(testUser, "", filename).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectFreeBlobs ожидает удаление содержимого по хешу без ссылок: один проход с hashes
+// и последний, который ничего не находит.
+func expectFreeBlobs(mock sqlmock.Sqlmock, hashes ...string) {
+	if len(hashes) > 0 {
+		rows := sqlmock.NewRows([]string{"hash"})
+		for _, hash := range hashes {
+			rows.AddRow(hash)
+		}
+		mock.ExpectQuery(sqlPrefix("SELECT hash FROM blobs WHERE refs = 0")).WillReturnRows(rows)
+		for _, hash := range hashes {
+			mock.ExpectBegin()
+			mock.ExpectQuery(sqlPrefix("DELETE FROM blobs")).
+				WithArgs(hash).
+				WillReturnRows(sqlmock.NewRows([]string{"chunked"}).AddRow(false))
+			mock.ExpectCommit()
+		}
+	}
+	mock.ExpectQuery(sqlPrefix("SELECT hash FROM blobs WHERE refs = 0")).
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+}
+
+// fileRow — строка files для Head.
+func fileRow(size int, sum, etag string, hash interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"uploaded_at", "size", "content_type", "sha256", "etag", "metadata", "key_fingerprint", "compression", "blob_hash"}).
+		AddRow(time.Now(), size, "text/plain; charset=utf-8", sum, etag, nil, nil, nil, hash)
+}
+
+// blobHashArg — значение колонки blob_hash, с которым записывается файл.
+func blobHashArg(dedup bool, hash string) interface{} {
+	if dedup {
+		return hash
+	}
+	return nil
+}
+
+func putBlobs(t *testing.T, blobs blobstore.BlobBackend, content map[string][]byte) {
+	t.Helper()
+	for key, data := range content {
+		if _, err := blobs.Put(context.Background(), key, bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkBlobs проверяет, что в хранилище лежит ровно want: без временного и лишнего содержимого.
+func checkBlobs(t *testing.T, blobs blobstore.BlobBackend, want map[string][]byte) {
+	t.Helper()
+	infos, err := blobs.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if _, ok := want[info.Key]; !ok {
+			t.Errorf("unexpected blob %s", info.Key)
+		}
+	}
+	for key, data := range want {
+		r, err := blobs.Get(context.Background(), key)
+		if err != nil {
+			t.Errorf("blob %s: %v", key, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("blob %s = %q, want %q", key, got, data)
+		}
+	}
+}
+
+func checkExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,190 @@
+package filestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Ограничения тегов те же, что у тегов объектов в S3.
+const (
+	MaxTags        = 10
+	MaxTagKeyLen   = 128
+	MaxTagValueLen = 256
+)
+
+var (
+	ErrInvalidTag  = errors.New("tag key must be 1-128 characters long and tag value at most 256")
+	ErrTooManyTags = errors.New("object tags cannot be greater than 10")
+)
+
+// TagFilter — условие поиска по тегу: наличие ключа Key, а если HasValue — равенство
+// его значения Value. Negate обращает условие.
+type TagFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+	Negate   bool
+}
+
+// Tags возвращает теги файла. У файла без тегов это пустой набор.
+func (f *FileStore) Tags(ctx context.Context, userID int, bucket, filename string) (map[string]string, error) {
+	if err := f.requireFile(ctx, f.Files, userID, bucket, filename); err != nil {
+		return nil, err
+	}
+
+	rows, err := f.Files.QueryContext(ctx,
+		"SELECT key, value FROM file_tags WHERE userid = $1 AND bucket = $2 AND filename = $3;",
+		userID,
+		bucket,
+		filename,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	tags := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, rows.Err()
+}
+
+// SetTags заменяет теги файла набором tags целиком, как PutObjectTagging в S3.
+func (f *FileStore) SetTags(ctx context.Context, userID int, bucket, filename string, tags map[string]string) error {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := f.setTags(ctx, tx, userID, bucket, filename, tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setTags заменяет теги файла набором tags в транзакции tx.
+func (f *FileStore) setTags(ctx context.Context, tx *sql.Tx, userID int, bucket, filename string, tags map[string]string) error {
+	if len(tags) > MaxTags {
+		return ErrTooManyTags
+	}
+	for k, v := range tags {
+		if k == "" || utf8.RuneCountInString(k) > MaxTagKeyLen || utf8.RuneCountInString(v) > MaxTagValueLen {
+			return ErrInvalidTag
+		}
+	}
+
+	// Блокировка строки файла не дает удалить или перенести его, пока теги записываются.
+	if err := f.requireFile(ctx, tx, userID, bucket, filename); err != nil {
+		return err
+	}
+	if err := deleteTags(ctx, tx, userID, bucket, filename); err != nil {
+		return err
+	}
+	for k, v := range tags {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO file_tags (userid, bucket, filename, key, value) VALUES ($1, $2, $3, $4, $5)",
+			userID,
+			bucket,
+			filename,
+			k,
+			v,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteTags удаляет все теги файла.
+func (f *FileStore) DeleteTags(ctx context.Context, userID int, bucket, filename string) error {
+	return f.SetTags(ctx, userID, bucket, filename, nil)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// requireFile возвращает ErrObjectNotFound или ErrBucketNotFound, если файла нет,
+// и блокирует его строку до конца транзакции, если db — транзакция.
+func (f *FileStore) requireFile(ctx context.Context, db queryer, userID int, bucket, filename string) error {
+	var id int
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3 FOR SHARE;",
+		userID,
+		bucket,
+		filename,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return err
+		}
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+func deleteTags(ctx context.Context, db execer, userID int, bucket, filename string) error {
+	_, err := db.ExecContext(ctx,
+		"DELETE FROM file_tags WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+	)
+	return err
+}
+
+// moveTags переводит теги файла на его новое имя.
+func moveTags(ctx context.Context, db execer, userID int, bucket, filename, dstBucket, dstFilename string) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE file_tags SET bucket = $4, filename = $5 WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+		dstBucket,
+		dstFilename,
+	)
+	return err
+}
+
+// copyTags заменяет теги dstFilename тегами исходного файла.
+func copyTags(ctx context.Context, db execer, userID int, bucket, filename, dstBucket, dstFilename string) error {
+	if err := deleteTags(ctx, db, userID, dstBucket, dstFilename); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO file_tags (userid, bucket, filename, key, value)
+		SELECT userid, $4, $5, key, value FROM file_tags WHERE userid = $1 AND bucket = $2 AND filename = $3`,
+		userID,
+		bucket,
+		filename,
+		dstBucket,
+		dstFilename,
+	)
+	return err
+}
+
+// tagCondition строит условие WHERE для строки files по фильтру тега.
+// arg добавляет значение в параметры запроса и возвращает его плейсхолдер.
+func tagCondition(t TagFilter, arg func(interface{}) string) string {
+	cond := "t.key = " + arg(t.Key)
+	if t.HasValue {
+		cond += " AND t.value = " + arg(t.Value)
+	}
+	exists := fmt.Sprintf(`EXISTS (SELECT 1 FROM file_tags t WHERE t.userid = files.userid AND t.bucket = files.bucket
+		AND t.filename = files.filename AND %s)`, cond)
+	if t.Negate {
+		return "NOT " + exists
+	}
+	return exists
+}
@@ -0,0 +1,435 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrTrashItemNotFound = errors.New("trash item not found")
+
+// TrashItem — удаленный файл в корзине. ExpiresAt задан, если корзина очищается автоматически.
+type TrashItem struct {
+	ID          string     `json:"id"`
+	Bucket      string     `json:"bucket"`
+	Filename    string     `json:"filename"`
+	Size        int64      `json:"size"`
+	ContentType string     `json:"content_type,omitempty"`
+	UploadedAt  time.Time  `json:"uploaded_at"`
+	DeletedAt   time.Time  `json:"deleted_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Trash переносит файл в корзину: строка файла, его теги и содержимое переезжают в trash,
+// ссылки на файл удаляются. Содержимое, хранящееся по хешу, не копируется: в корзину
+// переезжает только ссылка на него. Имя файла сразу освобождается для новых загрузок.
+// Если содержимого в хранилище нет, восстанавливать нечего, и файл удаляется насовсем
+// с нулевым результатом.
+func (f *FileStore) Trash(ctx context.Context, userID int, bucket, filename string) (*TrashItem, error) {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row, err := f.lockFile(ctx, tx, userID, bucket, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var src io.ReadCloser
+	if !row.hash.Valid {
+		src, err = f.Blobs.Get(ctx, blobKey(userID, bucket, filename))
+		if errors.Is(err, blobstore.ErrNotFound) {
+			_ = tx.Rollback()
+			return nil, f.Delete(ctx, userID, bucket, filename)
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer func(src io.Closer) {
+			_ = src.Close()
+		}(src)
+	}
+
+	item, err := f.moveToTrash(ctx, tx, userID, bucket, filename, row.versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := false
+	if src != nil {
+		key := trashKey(userID, item.ID)
+		stored, err := f.Blobs.Put(ctx, key, src)
+		if err != nil {
+			return nil, err
+		}
+		// Содержимое в корзине удаляется, если перенос в базе не удался.
+		defer func() {
+			if !committed {
+				_ = f.Blobs.Delete(context.Background(), key)
+			}
+		}()
+
+		// Размер файлов, загруженных до появления метаданных, берется из хранилища.
+		if !row.sized {
+			item.Size = stored
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE trash SET size = $2, stored_size = $3 WHERE id = $1",
+			item.ID,
+			item.Size,
+			stored,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	if src != nil {
+		if err := f.Blobs.Delete(ctx, blobKey(userID, bucket, filename)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return nil, err
+		}
+	}
+	f.setTrashExpiry(item)
+	return item, nil
+}
+
+// lockedFile — то, что нужно знать о файле, чтобы удалить или перенести его строку.
+type lockedFile struct {
+	versionID sql.NullString
+	hash      sql.NullString
+	sized     bool
+}
+
+// lockFile блокирует строку файла до конца транзакции tx.
+func (f *FileStore) lockFile(ctx context.Context, tx *sql.Tx, userID int, bucket, filename string) (*lockedFile, error) {
+	row := &lockedFile{}
+	err := tx.QueryRowContext(ctx,
+		`SELECT version_id, blob_hash, size IS NOT NULL FROM files
+		WHERE userid = $1 AND bucket = $2 AND filename = $3 FOR UPDATE;`,
+		userID,
+		bucket,
+		filename,
+	).Scan(&row.versionID, &row.hash, &row.sized)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return nil, err
+		}
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// moveToTrash переносит строку заблокированного файла и его теги в trash, удаляя ссылки на файл.
+// Содержимое, хранящееся под ключом файла, переносит вызывающий.
+func (f *FileStore) moveToTrash(ctx context.Context, tx *sql.Tx, userID int, bucket, filename string, versionID sql.NullString) (*TrashItem, error) {
+	var contentType sql.NullString
+	item := &TrashItem{Bucket: bucket, Filename: filename}
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO trash (userid, bucket, filename, size, content_type, sha256, metadata, uploaded_at,
+			key_fingerprint, compression, stored_size, blob_hash, etag, tags)
+		SELECT userid, bucket, filename, COALESCE(size, 0), content_type, sha256, metadata, uploaded_at,
+		key_fingerprint, compression, stored_size, blob_hash, etag,
+		(SELECT jsonb_object_agg(t.key, t.value) FROM file_tags t
+			WHERE t.userid = files.userid AND t.bucket = files.bucket AND t.filename = files.filename)
+		FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3
+		RETURNING id, size, content_type, uploaded_at, deleted_at`,
+		userID,
+		bucket,
+		filename,
+	).Scan(&item.ID, &item.Size, &contentType, &item.UploadedAt, &item.DeletedAt); err != nil {
+		return nil, err
+	}
+	item.ContentType = contentType.String
+
+	if versionID.Valid {
+		// Как и при удалении, текущая версия хранится только под ключом файла и уходит вместе с ним.
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM file_versions WHERE userid = $1 AND bucket = $2 AND filename = $3 AND version_id = $4",
+			userID,
+			bucket,
+			filename,
+			versionID.String,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.deleteShares(ctx, tx, userID, bucket, filename); err != nil {
+		return nil, err
+	}
+	if err := deleteTags(ctx, tx, userID, bucket, filename); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+	); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ListTrash возвращает содержимое корзины пользователя, начиная с последних удаленных.
+func (f *FileStore) ListTrash(ctx context.Context, userID int) ([]TrashItem, error) {
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT id, bucket, filename, size, content_type, uploaded_at, deleted_at FROM trash
+		WHERE userid = $1 ORDER BY deleted_at DESC, id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var items []TrashItem
+	for rows.Next() {
+		var (
+			item        TrashItem
+			contentType sql.NullString
+		)
+		if err := rows.Scan(&item.ID, &item.Bucket, &item.Filename, &item.Size, &contentType,
+			&item.UploadedAt, &item.DeletedAt); err != nil {
+			return nil, err
+		}
+		item.ContentType = contentType.String
+		f.setTrashExpiry(&item)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// RestoreTrash возвращает файл из корзины на прежнее место или под именем filename,
+// если оно не пустое. Существующий файл не перезаписывается, а бакет должен существовать.
+func (f *FileStore) RestoreTrash(ctx context.Context, userID int, id, filename string) (*Object, error) {
+	var (
+		bucket string
+		name   string
+		size   int64
+		hash   sql.NullString
+	)
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT bucket, filename, size, blob_hash FROM trash WHERE id = $1 AND userid = $2;",
+		id,
+		userID,
+	).Scan(&bucket, &name, &size, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTrashItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if filename == "" {
+		filename = name
+	}
+
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+	if err := f.checkPathConflict(ctx, userID, bucket, filename); err != nil {
+		return nil, err
+	}
+	var exists bool
+	if err := f.Files.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3);",
+		userID,
+		bucket,
+		filename,
+	).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrObjectAlreadyExists
+	}
+
+	if hash.Valid {
+		// Содержимое по хешу остается на месте, проверяется только число файлов.
+		if _, err := f.limitToQuota(ctx, userID, bucket, filename, -size, nil); err != nil {
+			return nil, err
+		}
+		if err := f.moveFromTrash(ctx, userID, id, bucket, filename); err != nil {
+			if isUniqueViolation(err) {
+				return nil, ErrObjectAlreadyExists
+			}
+			return nil, err
+		}
+		return f.Head(ctx, userID, bucket, filename)
+	}
+
+	src, err := f.Blobs.Get(ctx, trashKey(userID, id))
+	if err != nil {
+		return nil, err
+	}
+	defer func(src io.Closer) {
+		_ = src.Close()
+	}(src)
+
+	// Место в корзине уже учтено в квоте, поэтому восстановление занимает только новый файл.
+	r, err := f.limitToQuota(ctx, userID, bucket, filename, -size, src)
+	if err != nil {
+		return nil, err
+	}
+	// Под ключ файла содержимое переносится только после записи строки: параллельная
+	// загрузка под тем же именем могла успеть занять его.
+	staged := stagingKey(userID)
+	if _, err := f.Blobs.Put(ctx, staged, r); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Blobs.Delete(context.Background(), staged)
+	}()
+
+	if err := f.moveFromTrash(ctx, userID, id, bucket, filename); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrObjectAlreadyExists
+		}
+		return nil, err
+	}
+	if err := f.Blobs.Rename(context.WithoutCancel(ctx), staged, blobKey(userID, bucket, filename)); err != nil {
+		return nil, err
+	}
+
+	if err := f.Blobs.Delete(ctx, trashKey(userID, id)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+		return nil, err
+	}
+	return f.Head(ctx, userID, bucket, filename)
+}
+
+// moveFromTrash переносит строку из корзины в files с прежними датой загрузки, метаданными и тегами.
+func (f *FileStore) moveFromTrash(ctx context.Context, userID int, id, bucket, filename string) error {
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO files (userid, bucket, filename, size, content_type, sha256, metadata, uploaded_at,
+			key_fingerprint, compression, stored_size, blob_hash, etag)
+		SELECT userid, bucket, $3, size, content_type, sha256, metadata, uploaded_at,
+		key_fingerprint, compression, stored_size, blob_hash, etag FROM trash
+		WHERE id = $1 AND userid = $2`,
+		id,
+		userID,
+		filename,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTrashItemNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO file_tags (userid, bucket, filename, key, value)
+		SELECT userid, bucket, $2, t.key, t.value FROM trash, jsonb_each_text(trash.tags) t
+		WHERE id = $1`,
+		id,
+		filename,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM trash WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteTrash безвозвратно удаляет файл из корзины.
+func (f *FileStore) DeleteTrash(ctx context.Context, userID int, id string) error {
+	n, err := f.purgeTrash(ctx, "DELETE FROM trash WHERE id = $1 AND userid = $2 RETURNING userid, id, blob_hash", id, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTrashItemNotFound
+	}
+	return nil
+}
+
+// EmptyTrash безвозвратно удаляет все файлы из корзины пользователя и возвращает их число.
+func (f *FileStore) EmptyTrash(ctx context.Context, userID int) (int, error) {
+	return f.purgeTrash(ctx, "DELETE FROM trash WHERE userid = $1 RETURNING userid, id, blob_hash", userID)
+}
+
+// PurgeTrash безвозвратно удаляет файлы, пролежавшие в корзине дольше retention.
+// Возвращает число удаленных файлов.
+func (f *FileStore) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	return f.purgeTrash(ctx,
+		"DELETE FROM trash WHERE deleted_at < now() - make_interval(secs => $1) RETURNING userid, id, blob_hash",
+		retention.Seconds(),
+	)
+}
+
+// purgeTrash выполняет query, удаляющий строки корзины с RETURNING userid, id, blob_hash,
+// и удаляет содержимое удаленных файлов из хранилища. Содержимое по хешу удаляется,
+// только если на него больше ничего не ссылается.
+func (f *FileStore) purgeTrash(ctx context.Context, query string, args ...interface{}) (int, error) {
+	rows, err := f.Files.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var (
+		n    int
+		keys []string
+	)
+	for rows.Next() {
+		var (
+			userID int
+			id     string
+			hash   sql.NullString
+		)
+		if err := rows.Scan(&userID, &id, &hash); err != nil {
+			return 0, err
+		}
+		n++
+		if !hash.Valid {
+			keys = append(keys, trashKey(userID, id))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if err := f.Blobs.Delete(ctx, key); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return 0, err
+		}
+	}
+	return n, f.freeBlobs(ctx)
+}
+
+func (f *FileStore) setTrashExpiry(item *TrashItem) {
+	if f.TrashRetention > 0 {
+		expiresAt := item.DeletedAt.Add(f.TrashRetention)
+		item.ExpiresAt = &expiresAt
+	}
+}
+
+// trashKey — ключ содержимого файла в корзине.
+func trashKey(userID int, id string) string {
+	return fmt.Sprintf("trash/%d/%s", userID, id)
+}
@@ -0,0 +1,298 @@
+package filestore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUploadExpired        = errors.New("upload has expired")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadLengthExceeded = errors.New("upload is longer than its declared length")
+	ErrUploadIncomplete     = errors.New("upload is not complete")
+)
+
+// TusUpload — загрузка по протоколу tus: содержимое приходит запросами PATCH по порядку
+// и дописывается сегментами, пока не наберется Length байт. Каждый запрос сохраняет
+// все, что успел получить, поэтому после обрыва загрузка продолжается с Offset.
+type TusUpload struct {
+	ID       string
+	Bucket   string
+	Filename string
+	Length   int64
+	Offset   int64
+	// ExpiresAt — когда брошенная загрузка будет удалена; каждый PATCH отодвигает срок.
+	// Нулевой — без срока.
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// Metadata сохраняется при создании загрузки и применяется к собранному файлу.
+	Metadata Metadata
+
+	segments []string
+}
+
+const tusColumns = `id, bucket, filename, upload_length, upload_offset, segments,
+	COALESCE(content_type, ''), metadata, expires_at, created_at`
+
+// CreateTusUpload начинает загрузку length байт в filename. Бакет, метаданные и квота
+// проверяются сразу, чтобы клиент не передавал файл, который все равно не поместится.
+func (f *FileStore) CreateTusUpload(ctx context.Context, userID int, bucket, filename string, length int64, meta Metadata) (*TusUpload, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+	if err := meta.validate(); err != nil {
+		return nil, err
+	}
+	pending, err := f.pendingUploadBytes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.limitToQuota(ctx, userID, bucket, filename, pending+length, nil); err != nil {
+		return nil, err
+	}
+	metadata, err := metadataJSON(meta.User)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanTusUpload(f.Files.QueryRowContext(ctx,
+		`INSERT INTO tus_uploads (userid, bucket, filename, upload_length, content_type, metadata, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $7::float8 > 0 THEN now() + make_interval(secs => $7::float8) END)
+		RETURNING `+tusColumns,
+		userID,
+		bucket,
+		filename,
+		length,
+		meta.ContentType,
+		metadata,
+		f.UploadTTL.Seconds(),
+	))
+}
+
+// FindTusUpload возвращает загрузку пользователя. Истекшая, но еще не удаленная
+// загрузка дает ErrUploadExpired.
+func (f *FileStore) FindTusUpload(ctx context.Context, userID int, id string) (*TusUpload, error) {
+	u, err := scanTusUpload(f.Files.QueryRowContext(ctx,
+		"SELECT "+tusColumns+" FROM tus_uploads WHERE id = $1 AND userid = $2;",
+		id,
+		userID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !u.ExpiresAt.IsZero() && !u.ExpiresAt.After(time.Now()) {
+		return nil, ErrUploadExpired
+	}
+	return u, nil
+}
+
+// WriteTusUpload дописывает r к загрузке, если она получила ровно offset байт. Если чтение r
+// прервалось, полученное до обрыва сохраняется, а ошибка чтения возвращается вместе
+// с обновленной загрузкой. Параллельная запись с того же смещения дает ErrUploadOffsetMismatch.
+func (f *FileStore) WriteTusUpload(ctx context.Context, userID int, id string, offset int64, r io.Reader) (*TusUpload, error) {
+	u, err := f.FindTusUpload(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return nil, ErrUploadOffsetMismatch
+	}
+
+	pending, err := f.pendingUploadBytes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	body := &partialReader{r: r}
+	limited, err := f.limitToQuota(ctx, userID, u.Bucket, "", pending, &lengthReader{r: body, remaining: u.Length - u.Offset})
+	if err != nil {
+		return nil, err
+	}
+
+	// Сохраненное не должно пропасть из-за того, что клиент уже отключился.
+	key := fmt.Sprintf("%s%020d.%s", tusPrefix(id), offset, rand.Text())
+	n, err := f.Blobs.Put(context.WithoutCancel(ctx), key, limited)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		_ = f.Blobs.Delete(context.Background(), key)
+		return u, body.err
+	}
+
+	err = f.Files.QueryRowContext(ctx,
+		`UPDATE tus_uploads SET upload_offset = upload_offset + $4, segments = array_append(segments, $5),
+			expires_at = CASE WHEN $6::float8 > 0 THEN now() + make_interval(secs => $6::float8) END
+		WHERE id = $1 AND userid = $2 AND upload_offset = $3
+		RETURNING upload_offset, expires_at`,
+		id,
+		userID,
+		offset,
+		n,
+		key,
+		f.UploadTTL.Seconds(),
+	).Scan(&u.Offset, (*nullTime)(&u.ExpiresAt))
+	if err != nil {
+		// Загрузку отменили или дописали параллельным запросом, пока читалось тело.
+		_ = f.Blobs.Delete(context.Background(), key)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadOffsetMismatch
+		}
+		return nil, err
+	}
+	u.segments = append(u.segments, key)
+	return u, body.err
+}
+
+// AssembleTusUpload возвращает reader, последовательно читающий содержимое завершенной
+// загрузки. После записи итогового файла загрузку нужно удалить через DeleteTusUpload.
+func (f *FileStore) AssembleTusUpload(ctx context.Context, userID int, id string) (*TusUpload, io.ReadCloser, error) {
+	u, err := f.FindTusUpload(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Offset != u.Length {
+		return nil, nil, ErrUploadIncomplete
+	}
+	return u, &partsReader{ctx: ctx, blobs: f.Blobs, keys: u.segments}, nil
+}
+
+// DeleteTusUpload отменяет загрузку и удаляет полученное содержимое.
+func (f *FileStore) DeleteTusUpload(ctx context.Context, userID int, id string) error {
+	res, err := f.Files.ExecContext(ctx, "DELETE FROM tus_uploads WHERE id = $1 AND userid = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadNotFound
+	}
+
+	return f.deletePrefix(ctx, tusPrefix(id))
+}
+
+// deleteExpiredTusUploads удаляет загрузки tus с истекшим сроком и возвращает их число.
+func (f *FileStore) deleteExpiredTusUploads(ctx context.Context) (int, error) {
+	rows, err := f.Files.QueryContext(ctx, "DELETE FROM tus_uploads WHERE expires_at < now() RETURNING id")
+	if err != nil {
+		return 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := f.deletePrefix(ctx, tusPrefix(id)); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// pendingUploadBytes — сколько места занимают незавершенные загрузки пользователя:
+// части составных загрузок и уже полученное содержимое загрузок tus.
+func (f *FileStore) pendingUploadBytes(ctx context.Context, userID int) (int64, error) {
+	var pending int64
+	err := f.Files.QueryRowContext(ctx,
+		`SELECT
+			(SELECT COALESCE(SUM(p.size), 0) FROM upload_parts p JOIN uploads u USING (upload_id) WHERE u.userid = $1) +
+			(SELECT COALESCE(SUM(upload_offset), 0) FROM tus_uploads WHERE userid = $1)`,
+		userID,
+	).Scan(&pending)
+	return pending, err
+}
+
+func tusPrefix(id string) string {
+	return fmt.Sprintf("tus/%s/", id)
+}
+
+func scanTusUpload(row rowScanner) (*TusUpload, error) {
+	var (
+		u        TusUpload
+		metadata []byte
+	)
+	if err := row.Scan(&u.ID, &u.Bucket, &u.Filename, &u.Length, &u.Offset, pq.Array(&u.segments),
+		&u.Metadata.ContentType, &metadata, (*nullTime)(&u.ExpiresAt), &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &u.Metadata.User); err != nil {
+			return nil, err
+		}
+	}
+	return &u, nil
+}
+
+// nullTime читает NULL как нулевое время.
+type nullTime time.Time
+
+func (t *nullTime) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+	*t = nullTime(nt.Time)
+	return nil
+}
+
+// partialReader заканчивает чтение на первой ошибке r, запоминая ее, чтобы backend
+// сохранил уже прочитанное, а не отбросил его как недописанное.
+type partialReader struct {
+	r   io.Reader
+	err error
+}
+
+func (p *partialReader) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, io.EOF
+	}
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		p.err = err
+		err = io.EOF
+	}
+	return n, err
+}
+
+// lengthReader возвращает ErrUploadLengthExceeded, как только прочитано больше remaining байт.
+type lengthReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *lengthReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		return 0, ErrUploadLengthExceeded
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,254 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testUploadID = "0b7c1c1e-6f0d-4b8e-9d55-2f3c8a1e9b10"
+
+func TestWriteTusUpload(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	f.UploadTTL = time.Hour
+	expires := time.Now().Add(time.Hour)
+
+	expectTusUpload(mock, 10, 4, nil, nil)
+	expectTusWrite(mock, 4, 6, expires)
+
+	u, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 4, strings.NewReader("456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Offset != 10 || !u.ExpiresAt.Equal(expires) {
+		t.Errorf("upload offset %d, expires %v, want 10, %v", u.Offset, u.ExpiresAt, expires)
+	}
+	checkSegments(t, blobs, "456789")
+	checkExpectations(t, mock)
+}
+
+func TestWriteTusUploadOffsetMismatch(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	expectTusUpload(mock, 10, 4, nil, nil)
+
+	_, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 0, strings.NewReader("0123"))
+	if !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Fatalf("WriteTusUpload() error = %v, want ErrUploadOffsetMismatch", err)
+	}
+	checkBlobs(t, blobs, nil)
+	checkExpectations(t, mock)
+}
+
+func TestWriteTusUploadConcurrentWrite(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	expectTusUpload(mock, 10, 4, nil, nil)
+	expectTusQuota(mock)
+	// Пока читалось тело, смещение сдвинул другой запрос.
+	mock.ExpectQuery(sqlPrefix("UPDATE tus_uploads")).
+		WillReturnRows(sqlmock.NewRows([]string{"upload_offset", "expires_at"}))
+
+	_, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 4, strings.NewReader("4567"))
+	if !errors.Is(err, ErrUploadOffsetMismatch) {
+		t.Fatalf("WriteTusUpload() error = %v, want ErrUploadOffsetMismatch", err)
+	}
+	checkBlobs(t, blobs, nil)
+	checkExpectations(t, mock)
+}
+
+func TestWriteTusUploadPartial(t *testing.T) {
+	errRead := errors.New("connection reset")
+
+	t.Run("keeps what arrived", func(t *testing.T) {
+		f, mock, blobs := newTestStore(t)
+		expectTusUpload(mock, 10, 0, nil, nil)
+		expectTusWrite(mock, 0, 4, nil)
+
+		body := io.MultiReader(strings.NewReader("0123"), iotest.ErrReader(errRead))
+		u, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 0, body)
+		if !errors.Is(err, errRead) {
+			t.Fatalf("WriteTusUpload() error = %v, want %v", err, errRead)
+		}
+		if u == nil || u.Offset != 4 {
+			t.Fatalf("WriteTusUpload() = %+v, want the upload at offset 4", u)
+		}
+		checkSegments(t, blobs, "0123")
+		checkExpectations(t, mock)
+	})
+
+	t.Run("nothing arrived", func(t *testing.T) {
+		f, mock, blobs := newTestStore(t)
+		expectTusUpload(mock, 10, 0, nil, nil)
+		expectTusQuota(mock)
+
+		u, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 0, iotest.ErrReader(errRead))
+		if !errors.Is(err, errRead) {
+			t.Fatalf("WriteTusUpload() error = %v, want %v", err, errRead)
+		}
+		if u == nil || u.Offset != 0 {
+			t.Fatalf("WriteTusUpload() = %+v, want the upload at offset 0", u)
+		}
+		checkBlobs(t, blobs, nil)
+		checkExpectations(t, mock)
+	})
+}
+
+func TestWriteTusUploadTooLong(t *testing.T) {
+	f, mock, blobs := newTestStore(t)
+	expectTusUpload(mock, 10, 4, nil, nil)
+	expectTusQuota(mock)
+
+	_, err := f.WriteTusUpload(context.Background(), testUser, testUploadID, 4, strings.NewReader("4567890"))
+	if !errors.Is(err, ErrUploadLengthExceeded) {
+		t.Fatalf("WriteTusUpload() error = %v, want ErrUploadLengthExceeded", err)
+	}
+	checkBlobs(t, blobs, nil)
+	checkExpectations(t, mock)
+}
+
+func TestFindTusUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires interface{}
+		want    error
+	}{
+		{"without expiry", nil, nil},
+		{"not expired", time.Now().Add(time.Minute), nil},
+		{"expired", time.Now().Add(-time.Minute), ErrUploadExpired},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, _ := newTestStore(t)
+			expectTusUpload(mock, 10, 4, nil, tc.expires)
+
+			u, err := f.FindTusUpload(context.Background(), testUser, testUploadID)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("FindTusUpload() error = %v, want %v", err, tc.want)
+			}
+			if err == nil && (u.Length != 10 || u.Offset != 4 || u.Filename != "a.txt") {
+				t.Errorf("FindTusUpload() = %+v", u)
+			}
+			checkExpectations(t, mock)
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		f, mock, _ := newTestStore(t)
+		mock.ExpectQuery(sqlPrefix("SELECT id, bucket, filename")).
+			WillReturnRows(sqlmock.NewRows(tusRowColumns))
+
+		if _, err := f.FindTusUpload(context.Background(), testUser, testUploadID); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("FindTusUpload() error = %v, want ErrUploadNotFound", err)
+		}
+	})
+}
+
+func TestAssembleTusUpload(t *testing.T) {
+	first, second := tusPrefix(testUploadID)+"00000000000000000000.a", tusPrefix(testUploadID)+"00000000000000000004.b"
+
+	t.Run("complete", func(t *testing.T) {
+		f, mock, blobs := newTestStore(t)
+		putBlobs(t, blobs, map[string][]byte{first: []byte("0123"), second: []byte("456789")})
+		expectTusUpload(mock, 10, 10, []string{first, second}, nil)
+
+		_, body, err := f.AssembleTusUpload(context.Background(), testUser, testUploadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(body)
+		_ = body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "0123456789" {
+			t.Errorf("assembled %q, want %q", got, "0123456789")
+		}
+		checkExpectations(t, mock)
+	})
+
+	t.Run("incomplete", func(t *testing.T) {
+		f, mock, _ := newTestStore(t)
+		expectTusUpload(mock, 10, 4, []string{first}, nil)
+
+		if _, _, err := f.AssembleTusUpload(context.Background(), testUser, testUploadID); !errors.Is(err, ErrUploadIncomplete) {
+			t.Errorf("AssembleTusUpload() error = %v, want ErrUploadIncomplete", err)
+		}
+		checkExpectations(t, mock)
+	})
+}
+
+func TestLengthReader(t *testing.T) {
+	r := &lengthReader{r: iotest.OneByteReader(strings.NewReader("0123")), remaining: 4}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "0123" {
+		t.Errorf("exactly the length: %q, %v", got, err)
+	}
+
+	r = &lengthReader{r: strings.NewReader("01234"), remaining: 4}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrUploadLengthExceeded) {
+		t.Errorf("one byte more: error = %v, want ErrUploadLengthExceeded", err)
+	}
+}
+
+func TestPartialReader(t *testing.T) {
+	errRead := errors.New("connection reset")
+	r := &partialReader{r: io.MultiReader(strings.NewReader("0123"), iotest.ErrReader(errRead))}
+
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "0123" {
+		t.Errorf("ReadAll() = %q, %v, want the bytes before the error", got, err)
+	}
+	if !errors.Is(r.err, errRead) {
+		t.Errorf("remembered error = %v, want %v", r.err, errRead)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("Read() after the error = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+var tusRowColumns = []string{"id", "bucket", "filename", "upload_length", "upload_offset", "segments",
+	"content_type", "metadata", "expires_at", "created_at"}
+
+// expectTusUpload ожидает чтение загрузки testUploadID в a.txt.
+func expectTusUpload(mock sqlmock.Sqlmock, length, offset int64, segments []string, expires interface{}) {
+	mock.ExpectQuery(sqlPrefix("SELECT id, bucket, filename")).
+		WithArgs(testUploadID, testUser).
+		WillReturnRows(sqlmock.NewRows(tusRowColumns).AddRow(
+			testUploadID, "", "a.txt", length, offset, "{"+strings.Join(segments, ",")+"}",
+			"", nil, expires, time.Now(),
+		))
+}
+
+// expectTusQuota ожидает проверку квоты перед записью в загрузку.
+func expectTusQuota(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`^SELECT\s+\(SELECT COALESCE\(SUM\(p\.size\), 0\)`).
+		WithArgs(testUser).
+		WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(0))
+	expectQuota(mock)
+}
+
+// expectTusWrite ожидает сохранение n байт, записанных с offset.
+func expectTusWrite(mock sqlmock.Sqlmock, offset, n int64, expires interface{}) {
+	expectTusQuota(mock)
+	mock.ExpectQuery(sqlPrefix("UPDATE tus_uploads")).
+		WithArgs(testUploadID, testUser, offset, n, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"upload_offset", "expires_at"}).AddRow(offset+n, expires))
+}
+
+// checkSegments проверяет, что у загрузки сохранен один сегмент с содержимым want.
+func checkSegments(t *testing.T, blobs blobstore.BlobBackend, want string) {
+	t.Helper()
+	infos, err := blobs.List(context.Background(), tusPrefix(testUploadID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("upload has %d segments, want 1", len(infos))
+	}
+	checkBlobs(t, blobs, map[string][]byte{infos[0].Key: []byte(want)})
+}
@@ -0,0 +1,381 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// MaxPartNumber — наибольший номер части, как и в S3.
+const MaxPartNumber = 10000
+
+var (
+	ErrUploadNotFound  = errors.New("upload not found")
+	ErrInvalidPart     = errors.New("one or more of the specified parts could not be found or the etag does not match")
+	ErrInvalidPartList = errors.New("the list of parts was not in ascending order")
+)
+
+// Upload — сессия составной загрузки: части загружаются независимо и собираются в файл при завершении.
+type Upload struct {
+	UploadID  string    `json:"upload_id"`
+	Bucket    string    `json:"bucket"`
+	Filename  string    `json:"filename"`
+	CreatedAt time.Time `json:"created_at"`
+	// Metadata сохраняется при создании загрузки и применяется к собранному файлу.
+	Metadata Metadata `json:"-"`
+}
+
+type Part struct {
+	PartNumber   int       `json:"part_number"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func (f *FileStore) CreateUpload(ctx context.Context, userID int, bucket, filename string, meta Metadata) (*Upload, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+	if err := meta.validate(); err != nil {
+		return nil, err
+	}
+	metadata, err := metadataJSON(meta.User)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Upload{Bucket: bucket, Filename: filename, Metadata: meta}
+	if err := f.Files.QueryRowContext(ctx,
+		`INSERT INTO uploads (userid, bucket, filename, content_type, metadata)
+		VALUES ($1, $2, $3, $4, $5) RETURNING upload_id, created_at`,
+		userID,
+		bucket,
+		filename,
+		meta.ContentType,
+		metadata,
+	).Scan(&u.UploadID, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// FindUpload возвращает сессию загрузки, если она принадлежит пользователю.
+func (f *FileStore) FindUpload(ctx context.Context, userID int, uploadID string) (*Upload, error) {
+	u := &Upload{UploadID: uploadID}
+	var metadata []byte
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT bucket, filename, created_at, COALESCE(content_type, ''), metadata FROM uploads WHERE upload_id = $1 AND userid = $2;",
+		uploadID,
+		userID,
+	).Scan(&u.Bucket, &u.Filename, &u.CreatedAt, &u.Metadata.ContentType, &metadata)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &u.Metadata.User); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// ListUploads возвращает незавершенные загрузки пользователя в бакете.
+func (f *FileStore) ListUploads(ctx context.Context, userID int, bucket string) ([]Upload, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+
+	rows, err := f.Files.QueryContext(ctx,
+		"SELECT upload_id, filename, created_at FROM uploads WHERE userid = $1 AND bucket = $2 ORDER BY filename, created_at;",
+		userID,
+		bucket,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var uploads []Upload
+	for rows.Next() {
+		u := Upload{Bucket: bucket}
+		if err := rows.Scan(&u.UploadID, &u.Filename, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+
+	return uploads, rows.Err()
+}
+
+// UploadPart записывает часть загрузки. Повторная загрузка части с тем же номером
+// заменяет предыдущую, поэтому части можно слать параллельно и повторять независимо.
+func (f *FileStore) UploadPart(ctx context.Context, userID int, uploadID string, partNumber int, r io.Reader) (*Part, error) {
+	if partNumber < 1 || partNumber > MaxPartNumber {
+		return nil, ErrInvalidPart
+	}
+	u, err := f.FindUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Части всех незавершенных загрузок пользователя занимают место, кроме заменяемой.
+	var pending int64
+	if err := f.Files.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(p.size), 0) FROM upload_parts p JOIN uploads u USING (upload_id)
+		WHERE u.userid = $1 AND NOT (p.upload_id = $2 AND p.part_number = $3)`,
+		userID,
+		uploadID,
+		partNumber,
+	).Scan(&pending); err != nil {
+		return nil, err
+	}
+	r, err = f.limitToQuota(ctx, userID, u.Bucket, "", pending, r)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := md5.New()
+	size, err := f.Blobs.Put(ctx, partKey(uploadID, partNumber), io.TeeReader(r, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Part{PartNumber: partNumber, Size: size, ETag: hex.EncodeToString(hash.Sum(nil))}
+	err = f.Files.QueryRowContext(ctx,
+		`INSERT INTO upload_parts (upload_id, part_number, size, etag) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (upload_id, part_number) DO UPDATE SET size = excluded.size, etag = excluded.etag, uploaded_at = now()
+		RETURNING uploaded_at`,
+		uploadID,
+		partNumber,
+		p.Size,
+		p.ETag,
+	).Scan(&p.LastModified)
+	if err != nil {
+		// Сессию могли отменить, пока часть загружалась.
+		_ = f.Blobs.Delete(context.Background(), partKey(uploadID, partNumber))
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (f *FileStore) ListParts(ctx context.Context, userID int, uploadID string) ([]Part, error) {
+	if _, err := f.FindUpload(ctx, userID, uploadID); err != nil {
+		return nil, err
+	}
+
+	rows, err := f.Files.QueryContext(ctx,
+		"SELECT part_number, size, etag, uploaded_at FROM upload_parts WHERE upload_id = $1 ORDER BY part_number;",
+		uploadID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var parts []Part
+	for rows.Next() {
+		var p Part
+		if err := rows.Scan(&p.PartNumber, &p.Size, &p.ETag, &p.LastModified); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+
+	return parts, rows.Err()
+}
+
+// AssembleUpload проверяет список частей и возвращает reader, последовательно читающий
+// их содержимое. Пустой список означает все загруженные части по порядку. ETag в списке
+// необязателен, но если указан, должен совпадать с ETag загруженной части.
+// Собранный файл получает ETag составной загрузки, как в S3: MD5 от MD5-сумм частей и их число.
+// После записи итогового файла сессию нужно удалить через DeleteUpload.
+func (f *FileStore) AssembleUpload(ctx context.Context, userID int, uploadID string, parts []Part) (*Upload, io.ReadCloser, error) {
+	u, err := f.FindUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uploaded, err := f.ListParts(ctx, userID, uploadID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(parts) == 0 {
+		if len(uploaded) == 0 {
+			return nil, nil, ErrInvalidPart
+		}
+		parts = uploaded
+	}
+
+	byNumber := make(map[int]Part, len(uploaded))
+	for _, p := range uploaded {
+		byNumber[p.PartNumber] = p
+	}
+
+	keys := make([]string, len(parts))
+	sums := md5.New()
+	for i, p := range parts {
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return nil, nil, ErrInvalidPartList
+		}
+		stored, ok := byNumber[p.PartNumber]
+		if !ok || (p.ETag != "" && strings.Trim(p.ETag, "\"") != stored.ETag) {
+			return nil, nil, ErrInvalidPart
+		}
+		sum, err := hex.DecodeString(stored.ETag)
+		if err != nil {
+			return nil, nil, err
+		}
+		sums.Write(sum)
+		keys[i] = partKey(uploadID, p.PartNumber)
+	}
+	u.Metadata.etag = fmt.Sprintf("%x-%d", sums.Sum(nil), len(parts))
+
+	return u, &partsReader{ctx: ctx, blobs: f.Blobs, keys: keys}, nil
+}
+
+// DeleteUpload отменяет загрузку и удаляет ее части.
+func (f *FileStore) DeleteUpload(ctx context.Context, userID int, uploadID string) error {
+	res, err := f.Files.ExecContext(ctx, "DELETE FROM uploads WHERE upload_id = $1 AND userid = $2", uploadID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadNotFound
+	}
+
+	return f.deleteParts(ctx, uploadID)
+}
+
+// DeleteStaleUploads удаляет загрузки старше ttl вместе с частями и истекшие загрузки tus,
+// а заодно временное содержимое прерванных записей файлов и так и не собранные в файлы
+// фрагменты. Возвращает число удаленных загрузок.
+func (f *FileStore) DeleteStaleUploads(ctx context.Context, ttl time.Duration) (int, error) {
+	// Время сравнивается на стороне базы: created_at хранится без часового пояса.
+	rows, err := f.Files.QueryContext(ctx,
+		"DELETE FROM uploads WHERE created_at < now() - make_interval(secs => $1) RETURNING upload_id",
+		ttl.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if err := f.deleteParts(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.deleteExpiredTusUploads(ctx)
+	n += len(ids)
+	if err != nil {
+		return n, err
+	}
+	if err := f.deleteStaleChunks(ctx, ttl); err != nil {
+		return n, err
+	}
+	return n, f.deleteStaleStaging(ctx, ttl)
+}
+
+func (f *FileStore) deleteParts(ctx context.Context, uploadID string) error {
+	return f.deletePrefix(ctx, partPrefix(uploadID))
+}
+
+// deletePrefix удаляет из хранилища все содержимое с ключами, начинающимися с prefix.
+func (f *FileStore) deletePrefix(ctx context.Context, prefix string) error {
+	blobs, err := f.Blobs.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		if err := f.Blobs.Delete(ctx, b.Key); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func partPrefix(uploadID string) string {
+	return fmt.Sprintf("uploads/%s/", uploadID)
+}
+
+func partKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf("%s%05d", partPrefix(uploadID), partNumber)
+}
+
+// partsReader читает части по очереди, открывая следующую только после окончания предыдущей.
+type partsReader struct {
+	ctx   context.Context
+	blobs blobstore.BlobBackend
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			part, err := p.blobs.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.cur, p.keys = part, p.keys[1:]
+		}
+
+		n, err := p.cur.Read(b)
+		if err == io.EOF {
+			_ = p.cur.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur == nil {
+		return nil
+	}
+	err := p.cur.Close()
+	p.cur = nil
+	return err
+}
@@ -0,0 +1,82 @@
+package filestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAssembleUploadETag(t *testing.T) {
+	const uploadID = "5d1b7a4e-2c3f-4e8a-9b6d-0f1e2d3c4b5a"
+	parts := [][]byte{[]byte("first part"), []byte("second part"), []byte("third part")}
+
+	tests := []struct {
+		name   string
+		chosen []Part
+		want   []int
+	}{
+		{"all parts", nil, []int{1, 2, 3}},
+		{"chosen parts", []Part{{PartNumber: 1}, {PartNumber: 3, ETag: `"` + md5Hex(parts[2]) + `"`}}, []int{1, 3}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f, mock, _ := newTestStore(t)
+			rows := sqlmock.NewRows([]string{"part_number", "size", "etag", "uploaded_at"})
+			for i, p := range parts {
+				rows.AddRow(i+1, len(p), md5Hex(p), time.Now())
+			}
+			for range 2 {
+				mock.ExpectQuery(sqlPrefix("SELECT bucket, filename, created_at")).
+					WithArgs(uploadID, testUser).
+					WillReturnRows(sqlmock.NewRows([]string{"bucket", "filename", "created_at", "content_type", "metadata"}).
+						AddRow("docs", "a.txt", time.Now(), "", nil))
+			}
+			mock.ExpectQuery(sqlPrefix("SELECT part_number, size, etag")).WithArgs(uploadID).WillReturnRows(rows)
+
+			u, body, err := f.AssembleUpload(context.Background(), testUser, uploadID, tc.chosen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = body.Close()
+
+			// ETag составной загрузки в S3 — MD5 от склеенных MD5-сумм частей и их число.
+			var sums []byte
+			for _, n := range tc.want {
+				sum := md5.Sum(parts[n-1])
+				sums = append(sums, sum[:]...)
+			}
+			total := md5.Sum(sums)
+			want := fmt.Sprintf("%s-%d", hex.EncodeToString(total[:]), len(tc.want))
+			if u.Metadata.etag != want {
+				t.Errorf("upload ETag = %s, want %s", u.Metadata.etag, want)
+			}
+			checkExpectations(t, mock)
+		})
+	}
+}
+
+func TestInspectorETag(t *testing.T) {
+	content := []byte("hello, world")
+
+	in := newInspector(bytes.NewReader(content))
+	if _, err := io.ReadAll(in); err != nil {
+		t.Fatal(err)
+	}
+	if o := in.object("a.txt", Metadata{}); o.ETag != md5Hex(content) {
+		t.Errorf("ETag = %s, want MD5 of the content %s", o.ETag, md5Hex(content))
+	}
+	if o := in.object("a.txt", Metadata{etag: "ab12-2"}); o.ETag != "ab12-2" {
+		t.Errorf("ETag = %s, want the multipart ETag", o.ETag)
+	}
+	// По MD5 зашифрованного содержимого сервер узнал бы известный ему файл.
+	if o := in.object("a.txt", Metadata{CustomerKey: make([]byte, 32)}); o.ETag != "" || o.SHA256 != "" {
+		t.Errorf("encrypted content keeps ETag %q, SHA-256 %q", o.ETag, o.SHA256)
+	}
+}
@@ -0,0 +1,487 @@
+package filestore
+
+import (
+	"S3_project/S3/internal/app/store/blobstore"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// NullVersionID — версия содержимого, загруженного без версионирования (как "null" в S3).
+const NullVersionID = "null"
+
+var ErrVersionNotFound = errors.New("version not found")
+
+type Version struct {
+	VersionID    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	IsLatest     bool      `json:"is_latest"`
+	DeleteMarker bool      `json:"delete_marker"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SetVersioning включает или приостанавливает версионирование бакета. Уже созданные версии
+// при приостановке сохраняются.
+func (f *FileStore) SetVersioning(ctx context.Context, userID int, bucket string, enabled bool) error {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return err
+	}
+
+	_, err := f.Files.ExecContext(ctx,
+		`INSERT INTO bucket_versioning (userid, bucket, enabled) VALUES ($1, $2, $3)
+		ON CONFLICT (userid, bucket) DO UPDATE SET enabled = excluded.enabled`,
+		userID,
+		bucket,
+		enabled,
+	)
+	return err
+}
+
+func (f *FileStore) VersioningEnabled(ctx context.Context, userID int, bucket string) (bool, error) {
+	var enabled bool
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT enabled FROM bucket_versioning WHERE userid = $1 AND bucket = $2;",
+		userID,
+		bucket,
+	).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// SaveVersion записывает новую версию файла и делает ее текущей. Прежнее текущее
+// содержимое сохраняется в хранилище версий. Возвращает идентификатор новой версии.
+func (f *FileStore) SaveVersion(ctx context.Context, userID int, bucket, filename string, r io.Reader, meta Metadata) (string, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return "", err
+	}
+	if err := f.checkPathConflict(ctx, userID, bucket, filename); err != nil {
+		return "", err
+	}
+	if err := f.archiveCurrent(ctx, userID, bucket, filename, true); err != nil {
+		return "", err
+	}
+
+	o, err := f.putBlob(ctx, userID, bucket, filename, r, meta)
+	if err != nil {
+		return "", err
+	}
+	metadata, err := metadataJSON(o.Metadata)
+	if err != nil {
+		return "", err
+	}
+
+	var versionID string
+	err = f.commitObject(ctx, o, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO file_versions (userid, bucket, filename, size, content_type, sha256, metadata,
+				key_fingerprint, compression, stored_size, blob_hash, etag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING version_id`,
+			userID,
+			bucket,
+			filename,
+			o.Size,
+			o.ContentType,
+			o.SHA256,
+			metadata,
+			o.keyFingerprint(),
+			o.compression(),
+			o.StoredSize,
+			o.blobHash(),
+			o.etagColumn(),
+		).Scan(&versionID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO files (userid, bucket, filename, version_id, size, content_type, sha256, metadata,
+				key_fingerprint, compression, stored_size, blob_hash, etag)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			ON CONFLICT (userid, bucket, filename) DO UPDATE SET uploaded_at = now(), version_id = excluded.version_id,
+			size = excluded.size, content_type = excluded.content_type, sha256 = excluded.sha256, metadata = excluded.metadata,
+			key_fingerprint = excluded.key_fingerprint, compression = excluded.compression, stored_size = excluded.stored_size,
+			blob_hash = excluded.blob_hash, etag = excluded.etag`,
+			userID,
+			bucket,
+			filename,
+			versionID,
+			o.Size,
+			o.ContentType,
+			o.SHA256,
+			metadata,
+			o.keyFingerprint(),
+			o.compression(),
+			o.StoredSize,
+			o.blobHash(),
+			o.etagColumn(),
+		)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return versionID, f.dropReplaced(ctx, userID, bucket, filename, o)
+}
+
+// DeleteVersioned удаляет файл из текущего состояния, оставляя его версии и добавляя
+// маркер удаления. Возвращает идентификатор маркера.
+func (f *FileStore) DeleteVersioned(ctx context.Context, userID int, bucket, filename string) (string, error) {
+	if err := f.archiveCurrent(ctx, userID, bucket, filename, true); err != nil {
+		return "", err
+	}
+
+	tx, err := f.Files.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3",
+		userID,
+		bucket,
+		filename,
+	); err != nil {
+		return "", err
+	}
+
+	if err := deleteTags(ctx, tx, userID, bucket, filename); err != nil {
+		return "", err
+	}
+
+	var versionID string
+	if err := tx.QueryRowContext(ctx,
+		"INSERT INTO file_versions (userid, bucket, filename, delete_marker) VALUES ($1, $2, $3, true) RETURNING version_id",
+		userID,
+		bucket,
+		filename,
+	).Scan(&versionID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	if err := f.Blobs.Delete(ctx, blobKey(userID, bucket, filename)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+		return "", err
+	}
+	return versionID, nil
+}
+
+// ListVersions возвращает версии файла от новой к старой, включая маркеры удаления.
+func (f *FileStore) ListVersions(ctx context.Context, userID int, bucket, filename string) ([]Version, error) {
+	if err := f.checkBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+
+	current, exists, err := f.currentVersion(ctx, userID, bucket, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	if exists && !current.Valid {
+		// Содержимое без версии еще не попало в file_versions — показываем его как "null".
+		o, err := f.Head(ctx, userID, bucket, filename)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, Version{
+			VersionID:   NullVersionID,
+			Size:        o.Size,
+			ContentType: o.ContentType,
+			SHA256:      o.SHA256,
+			CreatedAt:   o.LastModified,
+		})
+	}
+
+	rows, err := f.Files.QueryContext(ctx,
+		`SELECT version_id, delete_marker, created_at, size, content_type, sha256 FROM file_versions
+		WHERE userid = $1 AND bucket = $2 AND filename = $3
+		ORDER BY created_at DESC, id DESC`,
+		userID,
+		bucket,
+		filename,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	sized := make(map[string]bool)
+	for rows.Next() {
+		var (
+			v           Version
+			size        sql.NullInt64
+			contentType sql.NullString
+			sum         sql.NullString
+		)
+		if err := rows.Scan(&v.VersionID, &v.DeleteMarker, &v.CreatedAt, &size, &contentType, &sum); err != nil {
+			return nil, err
+		}
+		if v.VersionID == NullVersionID && exists && !current.Valid {
+			// Старая версия "null" заменена текущим содержимым без версии.
+			continue
+		}
+		v.Size, v.ContentType, v.SHA256 = size.Int64, contentType.String, sum.String
+		sized[v.VersionID] = size.Valid
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range versions {
+		versions[i].IsLatest = i == 0
+		if versions[i].DeleteMarker || sized[versions[i].VersionID] || (i == 0 && exists && !current.Valid) {
+			continue
+		}
+		// Размер версий, сохраненных до появления метаданных, берется из хранилища.
+		key := versionKey(userID, bucket, filename, versions[i].VersionID)
+		if isCurrent(current, exists, versions[i].VersionID) {
+			key = blobKey(userID, bucket, filename)
+		}
+		info, err := f.Blobs.Stat(ctx, key)
+		if err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return nil, err
+		}
+		versions[i].Size = info.Size
+	}
+
+	return versions, nil
+}
+
+// OpenVersion открывает конкретную версию файла для потокового чтения и возвращает ее метаданные.
+// Версия, загруженная с ключом клиента, открывается только с тем же ключом key.
+func (f *FileStore) OpenVersion(ctx context.Context, userID int, bucket, filename, versionID string, key []byte) (io.ReadSeekCloser, *Object, error) {
+	current, exists, err := f.currentVersion(ctx, userID, bucket, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isCurrent(current, exists, versionID) {
+		return f.Open(ctx, userID, bucket, filename, key)
+	}
+
+	var (
+		o            = &Object{Key: filename}
+		deleteMarker bool
+		size         sql.NullInt64
+		contentType  sql.NullString
+		sum          sql.NullString
+		metadata     []byte
+		fingerprint  sql.NullString
+		compression  sql.NullString
+		hash         sql.NullString
+		etag         sql.NullString
+	)
+	err = f.Files.QueryRowContext(ctx,
+		`SELECT delete_marker, created_at, size, content_type, sha256, etag, metadata, key_fingerprint, compression, blob_hash
+		FROM file_versions
+		WHERE userid = $1 AND bucket = $2 AND filename = $3 AND version_id = $4;`,
+		userID,
+		bucket,
+		filename,
+		versionID,
+	).Scan(&deleteMarker, &o.UploadedAt, &size, &contentType, &sum, &etag, &metadata, &fingerprint, &compression, &hash)
+	if errors.Is(err, sql.ErrNoRows) || deleteMarker {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := o.fill(size, contentType, sum, etag, metadata); err != nil {
+		return nil, nil, err
+	}
+	o.KeyFingerprint = fingerprint.String
+	o.Compression = compression.String
+	o.BlobHash = hash.String
+
+	blob := versionKey(userID, bucket, filename, versionID)
+	if err := f.statBlob(ctx, blob, o, size.Valid); err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, nil, ErrVersionNotFound
+		}
+		return nil, nil, err
+	}
+	file, err := f.openBlob(ctx, blob, o, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, o, nil
+}
+
+// RestoreVersion делает копию старой версии новой текущей версией файла.
+// Сама восстановленная версия и все остальные остаются в истории. Версия, зашифрованная
+// ключом клиента, восстанавливается с тем же ключом key и остается зашифрованной им.
+func (f *FileStore) RestoreVersion(ctx context.Context, userID int, bucket, filename, versionID string, key []byte) (string, error) {
+	file, o, err := f.OpenVersion(ctx, userID, bucket, filename, versionID, key)
+	if err != nil {
+		return "", err
+	}
+	defer func(file io.Closer) {
+		_ = file.Close()
+	}(file)
+
+	return f.SaveVersion(ctx, userID, bucket, filename, file, Metadata{ContentType: o.ContentType, User: o.Metadata, CustomerKey: key})
+}
+
+// DeleteVersion безвозвратно удаляет одну версию. Если это текущая версия, файл
+// пропадает из текущего состояния, а вернуть его можно восстановлением другой версии.
+func (f *FileStore) DeleteVersion(ctx context.Context, userID int, bucket, filename, versionID string) error {
+	current, exists, err := f.currentVersion(ctx, userID, bucket, filename)
+	if err != nil {
+		return err
+	}
+	if isCurrent(current, exists, versionID) {
+		return f.Delete(ctx, userID, bucket, filename)
+	}
+
+	res, err := f.Files.ExecContext(ctx,
+		"DELETE FROM file_versions WHERE userid = $1 AND bucket = $2 AND filename = $3 AND version_id = $4",
+		userID,
+		bucket,
+		filename,
+		versionID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := f.checkBucket(ctx, userID, bucket); err != nil {
+			return err
+		}
+		return ErrVersionNotFound
+	}
+
+	if err := f.Blobs.Delete(ctx, versionKey(userID, bucket, filename, versionID)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+		return err
+	}
+	return f.freeBlobs(ctx)
+}
+
+// currentVersion возвращает версию текущего содержимого файла и признак того, что файл есть.
+func (f *FileStore) currentVersion(ctx context.Context, userID int, bucket, filename string) (sql.NullString, bool, error) {
+	var versionID sql.NullString
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT version_id FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3;",
+		userID,
+		bucket,
+		filename,
+	).Scan(&versionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sql.NullString{}, false, nil
+	}
+	if err != nil {
+		return sql.NullString{}, false, err
+	}
+	return versionID, true, nil
+}
+
+func isCurrent(current sql.NullString, exists bool, versionID string) bool {
+	if !exists {
+		return false
+	}
+	if !current.Valid {
+		return versionID == NullVersionID
+	}
+	return current.String == versionID
+}
+
+// archiveCurrent копирует текущее содержимое файла в хранилище версий перед перезаписью.
+// Содержимое, хранящееся по хешу, не копируется: версия ссылается на тот же хеш.
+// Содержимое без версии сохраняется как версия "null", только если withNull.
+func (f *FileStore) archiveCurrent(ctx context.Context, userID int, bucket, filename string, withNull bool) error {
+	var current, hash sql.NullString
+	err := f.Files.QueryRowContext(ctx,
+		"SELECT version_id, blob_hash FROM files WHERE userid = $1 AND bucket = $2 AND filename = $3;",
+		userID,
+		bucket,
+		filename,
+	).Scan(&current, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !current.Valid && !withNull {
+		return nil
+	}
+
+	versionID := current.String
+	if !current.Valid {
+		versionID = NullVersionID
+	}
+
+	if !hash.Valid {
+		src, err := f.Blobs.Get(ctx, blobKey(userID, bucket, filename))
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer func(src io.Closer) {
+			_ = src.Close()
+		}(src)
+
+		if _, err := f.Blobs.Put(ctx, versionKey(userID, bucket, filename, versionID), src); err != nil {
+			return err
+		}
+	}
+
+	if current.Valid {
+		return nil
+	}
+	if _, err := f.Files.ExecContext(ctx,
+		`INSERT INTO file_versions (userid, bucket, filename, version_id, created_at, size, content_type, sha256, metadata,
+			key_fingerprint, compression, stored_size, blob_hash, etag)
+		SELECT userid, bucket, filename, $4, uploaded_at, size, content_type, sha256, metadata,
+			key_fingerprint, compression, stored_size, blob_hash, etag FROM files
+		WHERE userid = $1 AND bucket = $2 AND filename = $3
+		ON CONFLICT (userid, bucket, filename, version_id) DO UPDATE SET created_at = excluded.created_at, delete_marker = false,
+		size = excluded.size, content_type = excluded.content_type, sha256 = excluded.sha256, metadata = excluded.metadata,
+		key_fingerprint = excluded.key_fingerprint, compression = excluded.compression, stored_size = excluded.stored_size,
+		blob_hash = excluded.blob_hash, etag = excluded.etag`,
+		userID,
+		bucket,
+		filename,
+		versionID,
+	); err != nil {
+		return err
+	}
+
+	// Прежняя версия "null" заменена, ее содержимое больше не нужно.
+	if hash.Valid {
+		if err := f.Blobs.Delete(ctx, versionKey(userID, bucket, filename, versionID)); err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return err
+		}
+	}
+	return f.freeBlobs(ctx)
+}
+
+// versionKey — ключ содержимого старой версии файла. Версии лежат рядом с файлами
+// пользователя в отдельном дереве, "_" обозначает корневое пространство.
+func versionKey(userID int, bucket, filename, versionID string) string {
+	if bucket == "" {
+		bucket = "_"
+	}
+	return fmt.Sprintf("versions/%d/%s/%s/%s", userID, bucket, url.PathEscape(filename), versionID)
+}
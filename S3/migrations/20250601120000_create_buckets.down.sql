@@ -0,0 +1,4 @@
+DROP TABLE access_keys;
+DROP INDEX files_userid_bucket_filename_key;
+ALTER TABLE files DROP COLUMN bucket;
+DROP TABLE buckets;
@@ -0,0 +1,18 @@
+CREATE TABLE buckets (
+    id serial not null primary key,
+    userid integer not null,
+    name text not null,
+    created_at timestamp not null default now(),
+    UNIQUE (userid, name)
+);
+
+-- '' — корневое пространство пользователя, в котором работает /api
+ALTER TABLE files ADD COLUMN bucket text not null default '';
+CREATE UNIQUE INDEX files_userid_bucket_filename_key ON files (userid, bucket, filename);
+
+CREATE TABLE access_keys (
+    access_key text not null primary key,
+    secret_key text not null,
+    userid integer not null,
+    created_at timestamp not null default now()
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS files_userid_bucket_filename_c_idx;
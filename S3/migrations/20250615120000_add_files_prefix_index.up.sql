@@ -0,0 +1 @@
+CREATE INDEX files_userid_bucket_filename_c_idx ON files (userid, bucket, filename COLLATE "C");
@@ -0,0 +1,3 @@
+DROP TABLE file_versions;
+ALTER TABLE files DROP COLUMN version_id;
+DROP TABLE bucket_versioning;
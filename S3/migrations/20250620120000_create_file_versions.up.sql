@@ -0,0 +1,20 @@
+CREATE TABLE bucket_versioning (
+    userid integer not null,
+    bucket text not null,
+    enabled bool not null default false,
+    PRIMARY KEY (userid, bucket)
+);
+
+-- версия текущего содержимого файла; NULL — содержимое загружено без версионирования
+ALTER TABLE files ADD COLUMN version_id text;
+
+CREATE TABLE file_versions (
+    id serial not null primary key,
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    version_id text not null default gen_random_uuid()::text,
+    delete_marker bool not null default false,
+    created_at timestamp not null default now(),
+    UNIQUE (userid, bucket, filename, version_id)
+);
@@ -0,0 +1,18 @@
+CREATE TABLE uploads (
+    upload_id text not null primary key default gen_random_uuid()::text,
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX uploads_created_at_idx ON uploads (created_at);
+
+CREATE TABLE upload_parts (
+    upload_id text not null references uploads (upload_id) on delete cascade,
+    part_number integer not null,
+    size bigint not null,
+    etag text not null,
+    uploaded_at timestamp not null default now(),
+    PRIMARY KEY (upload_id, part_number)
+);
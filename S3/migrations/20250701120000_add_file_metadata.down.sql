@@ -0,0 +1,15 @@
+ALTER TABLE uploads
+    DROP COLUMN content_type,
+    DROP COLUMN metadata;
+
+ALTER TABLE file_versions
+    DROP COLUMN size,
+    DROP COLUMN content_type,
+    DROP COLUMN sha256,
+    DROP COLUMN metadata;
+
+ALTER TABLE files
+    DROP COLUMN size,
+    DROP COLUMN content_type,
+    DROP COLUMN sha256,
+    DROP COLUMN metadata;
@@ -0,0 +1,16 @@
+-- NULL у файлов, загруженных до появления метаданных: размер тогда берется из хранилища
+ALTER TABLE files
+    ADD COLUMN size bigint,
+    ADD COLUMN content_type text,
+    ADD COLUMN sha256 text,
+    ADD COLUMN metadata jsonb;
+
+ALTER TABLE file_versions
+    ADD COLUMN size bigint,
+    ADD COLUMN content_type text,
+    ADD COLUMN sha256 text,
+    ADD COLUMN metadata jsonb;
+
+ALTER TABLE uploads
+    ADD COLUMN content_type text,
+    ADD COLUMN metadata jsonb;
@@ -0,0 +1,6 @@
+-- переопределения квот из конфигурации; NULL — значение по умолчанию
+CREATE TABLE user_quotas (
+    userid integer not null primary key,
+    max_bytes bigint,
+    max_files bigint
+);
@@ -0,0 +1,18 @@
+CREATE TABLE shares (
+    id text not null primary key default gen_random_uuid()::text,
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    password_hash text,
+    expires_at timestamptz,
+    max_downloads integer,
+    downloads integer not null default 0,
+    revoked_at timestamptz,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX shares_userid_bucket_filename_idx ON shares (userid, bucket, filename);
+
+-- прежние ссылки продолжают работать: их uuid становится идентификатором ссылки
+INSERT INTO shares (id, userid, bucket, filename)
+SELECT uuid::text, userid, bucket, filename FROM files WHERE public;
@@ -0,0 +1,16 @@
+-- удаленные через /api файлы; содержимое лежит в хранилище под ключом trash/<userid>/<id>
+CREATE TABLE trash (
+    id text not null primary key default gen_random_uuid()::text,
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    size bigint not null default 0,
+    content_type text,
+    sha256 text,
+    metadata jsonb,
+    uploaded_at timestamp not null,
+    deleted_at timestamptz not null default now()
+);
+
+CREATE INDEX trash_userid_idx ON trash (userid);
+CREATE INDEX trash_deleted_at_idx ON trash (deleted_at);
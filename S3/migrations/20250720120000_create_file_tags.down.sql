@@ -0,0 +1,3 @@
+ALTER TABLE trash DROP COLUMN tags;
+
+DROP TABLE file_tags;
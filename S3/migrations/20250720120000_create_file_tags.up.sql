@@ -0,0 +1,13 @@
+CREATE TABLE file_tags (
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    key text not null,
+    value text not null,
+    primary key (userid, bucket, filename, key)
+);
+
+CREATE INDEX file_tags_userid_key_value_idx ON file_tags (userid, key, value);
+
+-- теги файла в корзине хранятся вместе с ним и возвращаются при восстановлении
+ALTER TABLE trash ADD COLUMN tags jsonb;
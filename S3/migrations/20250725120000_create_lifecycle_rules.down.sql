@@ -0,0 +1,3 @@
+ALTER TABLE files DROP COLUMN last_accessed_at;
+
+DROP TABLE lifecycle_rules;
@@ -0,0 +1,15 @@
+CREATE TABLE lifecycle_rules (
+    id text not null primary key default gen_random_uuid()::text,
+    userid integer not null,
+    bucket text, -- NULL: правило действует во всех бакетах пользователя
+    prefix text not null default '',
+    action text not null,
+    days integer not null,
+    since text not null default 'upload',
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX lifecycle_rules_userid_idx ON lifecycle_rules (userid);
+
+-- время последнего скачивания для правил "не открывался N дней"; NULL — файл не скачивали
+ALTER TABLE files ADD COLUMN last_accessed_at timestamp;
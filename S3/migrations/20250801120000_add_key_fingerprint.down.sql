@@ -0,0 +1,3 @@
+ALTER TABLE trash DROP COLUMN key_fingerprint;
+ALTER TABLE file_versions DROP COLUMN key_fingerprint;
+ALTER TABLE files DROP COLUMN key_fingerprint;
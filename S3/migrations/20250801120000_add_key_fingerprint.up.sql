@@ -0,0 +1,4 @@
+-- отпечаток ключа клиента (SSE-C), которым зашифровано содержимое; сам ключ не хранится
+ALTER TABLE files ADD COLUMN key_fingerprint text;
+ALTER TABLE file_versions ADD COLUMN key_fingerprint text;
+ALTER TABLE trash ADD COLUMN key_fingerprint text;
@@ -0,0 +1,3 @@
+ALTER TABLE trash DROP COLUMN compression, DROP COLUMN stored_size;
+ALTER TABLE file_versions DROP COLUMN compression, DROP COLUMN stored_size;
+ALTER TABLE files DROP COLUMN compression, DROP COLUMN stored_size;
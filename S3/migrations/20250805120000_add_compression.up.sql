@@ -0,0 +1,4 @@
+-- алгоритм сжатия содержимого (NULL — без сжатия) и сколько оно занимает в хранилище
+ALTER TABLE files ADD COLUMN compression text, ADD COLUMN stored_size bigint;
+ALTER TABLE file_versions ADD COLUMN compression text, ADD COLUMN stored_size bigint;
+ALTER TABLE trash ADD COLUMN compression text, ADD COLUMN stored_size bigint;
@@ -0,0 +1,8 @@
+DROP TRIGGER trash_blob_refs ON trash;
+DROP TRIGGER file_versions_blob_refs ON file_versions;
+DROP TRIGGER files_blob_refs ON files;
+DROP FUNCTION count_blob_refs();
+ALTER TABLE trash DROP COLUMN blob_hash;
+ALTER TABLE file_versions DROP COLUMN blob_hash;
+ALTER TABLE files DROP COLUMN blob_hash;
+DROP TABLE blobs;
@@ -0,0 +1,40 @@
+-- содержимое файлов по SHA-256: одинаковое содержимое хранится один раз под ключом cas/<hash>.
+-- refs — число строк files, file_versions и trash, ссылающихся на содержимое
+CREATE TABLE blobs (
+    hash text not null primary key,
+    size bigint not null,
+    stored_size bigint not null,
+    compression text,
+    refs bigint not null default 0,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX blobs_unreferenced ON blobs (hash) WHERE refs = 0;
+
+-- NULL — содержимое лежит под ключом самого файла: зашифрованное ключом клиента или записанное раньше
+ALTER TABLE files ADD COLUMN blob_hash text REFERENCES blobs (hash);
+ALTER TABLE file_versions ADD COLUMN blob_hash text REFERENCES blobs (hash);
+ALTER TABLE trash ADD COLUMN blob_hash text REFERENCES blobs (hash);
+
+CREATE FUNCTION count_blob_refs() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        IF OLD.blob_hash IS NOT NULL THEN
+            UPDATE blobs SET refs = refs - 1 WHERE hash = OLD.blob_hash;
+        END IF;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        IF NEW.blob_hash IS NOT NULL THEN
+            UPDATE blobs SET refs = refs + 1 WHERE hash = NEW.blob_hash;
+        END IF;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER files_blob_refs AFTER INSERT OR UPDATE OF blob_hash OR DELETE ON files
+    FOR EACH ROW EXECUTE FUNCTION count_blob_refs();
+CREATE TRIGGER file_versions_blob_refs AFTER INSERT OR UPDATE OF blob_hash OR DELETE ON file_versions
+    FOR EACH ROW EXECUTE FUNCTION count_blob_refs();
+CREATE TRIGGER trash_blob_refs AFTER INSERT OR UPDATE OF blob_hash OR DELETE ON trash
+    FOR EACH ROW EXECUTE FUNCTION count_blob_refs();
@@ -0,0 +1,3 @@
+DROP TABLE user_chunks;
+DROP TABLE blob_chunks;
+ALTER TABLE blobs DROP COLUMN chunked;
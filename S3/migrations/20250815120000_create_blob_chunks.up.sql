@@ -0,0 +1,29 @@
+-- chunked — содержимое собрано из фрагментов blob_chunks и под ключом cas/<hash> не хранится.
+-- Фрагменты — обычные строки blobs: их refs считают и ссылки из blob_chunks и user_chunks
+ALTER TABLE blobs ADD COLUMN chunked boolean not null default false;
+
+-- фрагменты содержимого manifest по порядку; start — смещение фрагмента в содержимом
+CREATE TABLE blob_chunks (
+    manifest text not null REFERENCES blobs (hash) ON DELETE CASCADE,
+    seq integer not null,
+    blob_hash text not null REFERENCES blobs (hash),
+    start bigint not null,
+    size bigint not null,
+    primary key (manifest, seq)
+);
+
+CREATE INDEX blob_chunks_blob_hash_idx ON blob_chunks (blob_hash);
+
+-- фрагменты, загруженные пользователем, но еще не вошедшие в файл; брошенные удаляются
+-- через upload_ttl вместе с незавершенными загрузками
+CREATE TABLE user_chunks (
+    userid integer not null,
+    blob_hash text not null REFERENCES blobs (hash),
+    uploaded_at timestamp not null default now(),
+    primary key (userid, blob_hash)
+);
+
+CREATE TRIGGER blob_chunks_blob_refs AFTER INSERT OR DELETE ON blob_chunks
+    FOR EACH ROW EXECUTE FUNCTION count_blob_refs();
+CREATE TRIGGER user_chunks_blob_refs AFTER INSERT OR DELETE ON user_chunks
+    FOR EACH ROW EXECUTE FUNCTION count_blob_refs();
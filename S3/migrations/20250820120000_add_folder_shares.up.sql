@@ -0,0 +1,2 @@
+-- папка: filename хранит префикс с "/" на конце, ссылка отдает архив файлов под ним
+ALTER TABLE shares ADD COLUMN folder boolean not null default false;
@@ -0,0 +1,17 @@
+-- загрузки по протоколу tus: содержимое дописывается сегментами tus/<id>/... в хранилище,
+-- segments перечисляет их по порядку; брошенные загрузки удаляются после expires_at
+CREATE TABLE tus_uploads (
+    id text not null primary key default gen_random_uuid()::text,
+    userid integer not null,
+    bucket text not null,
+    filename text not null,
+    upload_length bigint not null,
+    upload_offset bigint not null default 0,
+    segments text[] not null default '{}',
+    content_type text,
+    metadata jsonb,
+    expires_at timestamptz,
+    created_at timestamp not null default now()
+);
+
+CREATE INDEX tus_uploads_expires_at_idx ON tus_uploads (expires_at);
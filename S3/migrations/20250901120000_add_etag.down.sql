@@ -0,0 +1,3 @@
+ALTER TABLE trash DROP COLUMN etag;
+ALTER TABLE file_versions DROP COLUMN etag;
+ALTER TABLE files DROP COLUMN etag;
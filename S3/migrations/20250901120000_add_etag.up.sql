@@ -0,0 +1,4 @@
+-- ETag объекта в формате S3: MD5 содержимого, а у собранного из частей — MD5 их MD5-сумм и число частей
+ALTER TABLE files ADD COLUMN etag text;
+ALTER TABLE file_versions ADD COLUMN etag text;
+ALTER TABLE trash ADD COLUMN etag text;